@@ -1,13 +1,19 @@
+//go:build !js
+// +build !js
+
 package main
 
 import (
 	"fmt"
 	"github.com/mattn/go-isatty"
+	"image/color"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 var outputDebugLog = false
@@ -18,32 +24,79 @@ type options struct {
 	colorProfileInput string
 	itsOutput         string
 	bufferSize        sizeStruct
+	bufferSizeSet     bool
 	evenIfNotTty      bool
+	jobs              int
+
+	colorProfileOutput     string
+	colorProfileFormat     string
+	colorProfileDebugImage string
+	recolorFrom            string
+	colorMode              string
+	seekStep               float64
+	queryTimeout           time.Duration
 
-	shell string
-	quiet bool
+	shell       string
+	quiet       bool
+	recordInput bool
 
-	script string
-	timing string
+	script     string
+	timing     string
+	stream     bool
+	dictFrames int
 
 	itsInput string
 
-	fontFamily  string
-	ffplay      bool
-	videoOutput string
-	dpi         float64
-	ss          uint64
-	t           uint64
+	fontFamily    string
+	fontFile      string
+	boldFontFile  string
+	fallbackFonts []string
+	fontSize      float64
+	ffplay        bool
+	videoOutput   string
+	dpi           float64
+	speed         float64
+	maxIdle       float64
+	padding       int
+	background    color.RGBA
+	backgroundSet bool
+	chrome        bool
+	progress      bool
+	keys          bool
+	title         string
+	subtitleFiles []string
+	chaptersFile  string
+
+	htmlOutput string
+
+	startTime float64
+	duration  float64
+	endTime   float64
+
+	imageOutput  string
+	at           float64
+	atSet        bool
+	contactSheet int
+	sheetColumns int
+	sheetChanges bool
+	thumbWidth   int
 }
 
 const (
-	opEncode            = "encode"
-	opPlay              = "play"
-	opRecord            = "record"
-	opOptimize          = "optimize"
-	opGetColorProfile   = "get-color-profile"
-	opCheckColorProfile = "check-color-profile"
-	opToVideo           = "to-video"
+	opEncode              = "encode"
+	opPlay                = "play"
+	opRecord              = "record"
+	opOptimize            = "optimize"
+	opRecolor             = "recolor"
+	opGetColorProfile     = "get-color-profile"
+	opCheckColorProfile   = "check-color-profile"
+	opListColorProfiles   = "list-color-profiles"
+	opConvertColorProfile = "convert-color-profile"
+	opToVideo             = "to-video"
+	opToHTML              = "to-html"
+	opToGIF               = "to-gif"
+	opToSVG               = "to-svg"
+	opScreenshot          = "screenshot"
 )
 
 func log(format string, args ...interface{}) {
@@ -80,12 +133,26 @@ func main() {
 		doOpRecord(opt)
 	case opOptimize:
 		doOpOptimize(opt)
+	case opRecolor:
+		doOpRecolor(opt)
 	case opGetColorProfile:
 		doOpGetColorProfile(opt)
 	case opCheckColorProfile:
 		doOpCheckColorProfile(opt)
+	case opListColorProfiles:
+		doOpListColorProfiles(opt)
+	case opConvertColorProfile:
+		doOpConvertColorProfile(opt)
 	case opToVideo:
 		doOpToVideo(opt)
+	case opToHTML:
+		doOpToHTML(opt)
+	case opToGIF:
+		doOpToGIF(opt)
+	case opToSVG:
+		doOpToSVG(opt)
+	case opScreenshot:
+		doOpScreenshot(opt)
 	default:
 		// default case handled by parseArgs
 		panic("!")
@@ -95,9 +162,40 @@ func main() {
 type Palette [18]uint32
 
 var (
-	regXxY = regexp.MustCompile(`^(\d+)x(\d+)$`)
+	regXxY       = regexp.MustCompile(`^(\d+)x(\d+)$`)
+	regTimestamp = regexp.MustCompile(`^(?:(?:(\d+):)?(\d+):)?(\d+(?:\.\d*)?)$`)
 )
 
+// parseHexColor parses a color in the form #rrggbb or #rgb, with or without the #.
+func parseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return color.RGBA{}, fmt.Errorf("Invalid color %v. Expected #rrggbb", strconv.Quote(s))
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
+
+// parseTimestamp parses a time in seconds, [[hh:]mm:]ss(.sss), e.g. 90, 1:30 or 1:23:45.5.
+func parseTimestamp(s string) (float64, error) {
+	sm := regTimestamp.FindStringSubmatch(s)
+	if sm == nil {
+		return 0, fmt.Errorf("Invalid time %v. Expected [[hh:]mm:]ss", strconv.Quote(s))
+	}
+	var hours, minutes float64
+	if sm[1] != "" {
+		hours, _ = strconv.ParseFloat(sm[1], 64)
+	}
+	if sm[2] != "" {
+		minutes, _ = strconv.ParseFloat(sm[2], 64)
+	}
+	seconds, _ := strconv.ParseFloat(sm[3], 64)
+	return hours*3600 + minutes*60 + seconds, nil
+}
+
 func parseArgs(args []string) (opt options, err error) {
 	err = nil
 	if len(args) == 3 && args[1] == "__rec_exec" {
@@ -111,6 +209,9 @@ func parseArgs(args []string) (opt options, err error) {
 	}
 	opt.fps = 60
 	opt.bufferSize = sizeStruct{300, 300}
+	opt.dictFrames = 200
+	opt.fontSize = 11
+	opt.queryTimeout = time.Second
 	nbNonOptionArgs := 0
 	if len(args) <= 1 {
 		err = fmt.Errorf("Not enough arguments")
@@ -133,10 +234,22 @@ func parseArgs(args []string) (opt options, err error) {
 				return
 			}
 			opt.operation = currentArg
+			if opt.operation == opPlay {
+				opt.seekStep = 5
+				opt.speed = 1
+			}
 			if opt.operation == opToVideo {
 				opt.fps = 25
 				opt.bufferSize = sizeStruct{160, 60}
 				opt.dpi = 150
+				opt.speed = 1
+			}
+			if opt.operation == opToGIF || opt.operation == opScreenshot {
+				opt.dpi = 96
+			}
+			if opt.operation == opScreenshot {
+				opt.sheetColumns = 4
+				opt.thumbWidth = 320
 			}
 			continue
 		}
@@ -159,9 +272,9 @@ func parseArgs(args []string) (opt options, err error) {
 			continue
 		}
 
-		if currentArg == "-c" && (opt.operation == opRecord || opt.operation == opEncode || opt.operation == opPlay || opt.operation == opToVideo) {
+		if currentArg == "-c" && (opt.operation == opRecord || opt.operation == opEncode || opt.operation == opRecolor || opt.operation == opPlay || opt.operation == opToVideo || opt.operation == opToHTML || opt.operation == opToGIF || opt.operation == opToSVG || opt.operation == opScreenshot) {
 			if !hasNextArg {
-				err = fmt.Errorf("-c <color profile file>")
+				err = fmt.Errorf("-c <color profile name or file>")
 				return
 			}
 			opt.colorProfileInput = nextArg
@@ -170,7 +283,7 @@ func parseArgs(args []string) (opt options, err error) {
 		}
 
 		const ddBufSizeEqual = "--buffer-size="
-		if strings.HasPrefix(currentArg, ddBufSizeEqual) && (opt.operation == opRecord || opt.operation == opEncode || opt.operation == opOptimize || opt.operation == opToVideo) {
+		if strings.HasPrefix(currentArg, ddBufSizeEqual) && (opt.operation == opRecord || opt.operation == opEncode || opt.operation == opOptimize || opt.operation == opToVideo || opt.operation == opToGIF || opt.operation == opToSVG || opt.operation == opScreenshot) {
 			equals := currentArg[len(ddBufSizeEqual):]
 			sm := regXxY.FindStringSubmatch(equals)
 			if sm == nil {
@@ -187,9 +300,61 @@ func parseArgs(args []string) (opt options, err error) {
 				return
 			}
 			opt.bufferSize = sizeStruct{rows: rows, cols: cols}
+			opt.bufferSizeSet = true
+			continue
+		}
+
+		if currentArg == "-j" && (opt.operation == opEncode || opt.operation == opOptimize || opt.operation == opRecolor) {
+			if !hasNextArg {
+				err = fmt.Errorf("-j <number of threads>")
+				return
+			}
+			opt.jobs, err = strconv.Atoi(nextArg)
+			if err != nil {
+				return
+			}
+			if opt.jobs <= 0 {
+				err = fmt.Errorf("-j must be positive")
+				return
+			}
+			i++
 			continue
 		}
 
+		if currentArg == "--record-input" && opt.operation == opRecord {
+			opt.recordInput = true
+			continue
+		}
+
+		const ddTitleEqual = "--title="
+		if strings.HasPrefix(currentArg, ddTitleEqual) && (opt.operation == opRecord || opt.operation == opEncode || opt.operation == opToVideo) {
+			opt.title = currentArg[len(ddTitleEqual):]
+			continue
+		}
+
+		if opt.operation == opGetColorProfile {
+			if currentArg == "--query" {
+				if !hasNextArg {
+					err = fmt.Errorf("--query <output file>")
+					return
+				}
+				opt.colorProfileOutput = nextArg
+				i++
+				continue
+			}
+
+			const ddTimeoutEqual = "--timeout="
+			if strings.HasPrefix(currentArg, ddTimeoutEqual) {
+				var seconds float64
+				seconds, err = parseTimestamp(currentArg[len(ddTimeoutEqual):])
+				if err != nil {
+					return
+				}
+				opt.queryTimeout = time.Duration(seconds * float64(time.Second))
+				continue
+			}
+		}
+
 		const ddEvenIfNotTty = "--even-if-not-tty"
 		if currentArg == ddEvenIfNotTty && (opt.operation == opRecord || opt.operation == opPlay || opt.operation == opGetColorProfile) {
 			opt.evenIfNotTty = true
@@ -222,7 +387,23 @@ func parseArgs(args []string) (opt options, err error) {
 		}
 
 		if opt.operation == opEncode {
-			if currentArg[0] != '-' {
+			if currentArg == "--stream" {
+				opt.stream = true
+				continue
+			}
+
+			const ddDictFramesEqual = "--dict-frames="
+			if strings.HasPrefix(currentArg, ddDictFramesEqual) {
+				equals := currentArg[len(ddDictFramesEqual):]
+				opt.dictFrames, err = strconv.Atoi(equals)
+				if err != nil || opt.dictFrames < 0 {
+					err = fmt.Errorf("--dict-frames=<number of frames>")
+					return
+				}
+				continue
+			}
+
+			if currentArg[0] != '-' || (currentArg == "-" && nbNonOptionArgs < 2) {
 				if nbNonOptionArgs == 0 {
 					nbNonOptionArgs++
 					opt.script = currentArg
@@ -242,6 +423,29 @@ func parseArgs(args []string) (opt options, err error) {
 		}
 
 		if opt.operation == opPlay {
+			const ddColorsEqual = "--colors="
+			if strings.HasPrefix(currentArg, ddColorsEqual) {
+				opt.colorMode = currentArg[len(ddColorsEqual):]
+				if _, ok := parseColorMode(opt.colorMode); !ok {
+					err = fmt.Errorf("%v<truecolor|256|16|mono>", ddColorsEqual)
+					return
+				}
+				continue
+			}
+
+			const ddSeekStepEqual = "--seek-step="
+			if strings.HasPrefix(currentArg, ddSeekStepEqual) {
+				opt.seekStep, err = parseTimestamp(currentArg[len(ddSeekStepEqual):])
+				if err != nil {
+					return
+				}
+				if opt.seekStep <= 0 {
+					err = fmt.Errorf("%v must be more than 0", ddSeekStepEqual)
+					return
+				}
+				continue
+			}
+
 			if currentArg[0] != '-' {
 				if nbNonOptionArgs == 0 {
 					nbNonOptionArgs++
@@ -251,7 +455,15 @@ func parseArgs(args []string) (opt options, err error) {
 			}
 		}
 
-		if opt.operation == opOptimize {
+		if opt.operation == opRecolor {
+			const ddFromEqual = "--from="
+			if strings.HasPrefix(currentArg, ddFromEqual) {
+				opt.recolorFrom = currentArg[len(ddFromEqual):]
+				continue
+			}
+		}
+
+		if opt.operation == opOptimize || opt.operation == opRecolor {
 			if currentArg[0] != '-' {
 				if nbNonOptionArgs == 0 {
 					nbNonOptionArgs++
@@ -267,6 +479,12 @@ func parseArgs(args []string) (opt options, err error) {
 		}
 
 		if opt.operation == opCheckColorProfile {
+			const ddDebugImageEqual = "--debug-image="
+			if strings.HasPrefix(currentArg, ddDebugImageEqual) {
+				opt.colorProfileDebugImage = currentArg[len(ddDebugImageEqual):]
+				continue
+			}
+
 			if currentArg[0] != '-' {
 				if nbNonOptionArgs == 0 {
 					nbNonOptionArgs++
@@ -276,9 +494,51 @@ func parseArgs(args []string) (opt options, err error) {
 			}
 		}
 
-		if opt.operation == opToVideo {
+		if opt.operation == opConvertColorProfile {
+			const ddFormatEqual = "--format="
+			if strings.HasPrefix(currentArg, ddFormatEqual) {
+				opt.colorProfileFormat = currentArg[len(ddFormatEqual):]
+				switch opt.colorProfileFormat {
+				case profileFormatJSON, profileFormatPNG, profileFormatXresources, profileFormatITerm, profileFormatWindowsTerm:
+				default:
+					err = fmt.Errorf("%v<json|png|xresources|iterm|windows-terminal>", ddFormatEqual)
+					return
+				}
+				continue
+			}
+
+			if currentArg[0] != '-' || (currentArg == "-" && nbNonOptionArgs == 1) {
+				if nbNonOptionArgs == 0 {
+					nbNonOptionArgs++
+					opt.colorProfileInput = currentArg
+					continue
+				}
+				if nbNonOptionArgs == 1 {
+					nbNonOptionArgs++
+					opt.colorProfileOutput = currentArg
+					continue
+				}
+			}
+		}
+
+		if opt.operation == opToHTML {
+			if currentArg[0] != '-' {
+				if nbNonOptionArgs == 0 {
+					nbNonOptionArgs++
+					opt.itsInput = currentArg
+					continue
+				}
+				if nbNonOptionArgs == 1 {
+					nbNonOptionArgs++
+					opt.htmlOutput = currentArg
+					continue
+				}
+			}
+		}
+
+		if opt.operation == opToVideo || opt.operation == opToGIF || opt.operation == opScreenshot {
 			const ddFontEqual = "--font="
-			if strings.HasPrefix(currentArg, ddFontEqual) && (opt.operation == opToVideo) {
+			if strings.HasPrefix(currentArg, ddFontEqual) {
 				equals := currentArg[len(ddFontEqual):]
 				if strings.ContainsAny(equals, "-,:=_") {
 					err = fmt.Errorf("Font-config pattern detected. Pass family name directly instead")
@@ -288,8 +548,36 @@ func parseArgs(args []string) (opt options, err error) {
 				continue
 			}
 
+			const ddFontFileEqual = "--font-file="
+			if strings.HasPrefix(currentArg, ddFontFileEqual) {
+				opt.fontFile = currentArg[len(ddFontFileEqual):]
+				continue
+			}
+
+			const ddBoldFontFileEqual = "--bold-font-file="
+			if strings.HasPrefix(currentArg, ddBoldFontFileEqual) {
+				opt.boldFontFile = currentArg[len(ddBoldFontFileEqual):]
+				continue
+			}
+
+			const ddFallbackFontEqual = "--fallback-font="
+			if strings.HasPrefix(currentArg, ddFallbackFontEqual) {
+				opt.fallbackFonts = append(opt.fallbackFonts, currentArg[len(ddFallbackFontEqual):])
+				continue
+			}
+
+			const ddFontSizeEqual = "--font-size="
+			if strings.HasPrefix(currentArg, ddFontSizeEqual) {
+				opt.fontSize, err = strconv.ParseFloat(currentArg[len(ddFontSizeEqual):], 64)
+				if err != nil || opt.fontSize <= 0 {
+					err = fmt.Errorf("--font-size=<points>")
+					return
+				}
+				continue
+			}
+
 			const ddDpiEqual = "--dpi="
-			if strings.HasPrefix(currentArg, ddDpiEqual) && (opt.operation == opToVideo) {
+			if strings.HasPrefix(currentArg, ddDpiEqual) {
 				equals := currentArg[len(ddDpiEqual):]
 				var f float64
 				f, err = strconv.ParseFloat(equals, 64)
@@ -304,40 +592,204 @@ func parseArgs(args []string) (opt options, err error) {
 				opt.dpi = f
 				continue
 			}
+		}
+
+		if opt.operation == opToSVG || opt.operation == opScreenshot || opt.operation == opToVideo {
+			if currentArg == "-ss" || currentArg == "-t" || currentArg == "-to" {
+				if !hasNextArg {
+					err = fmt.Errorf("%v <time>", currentArg)
+					return
+				}
+				var t float64
+				t, err = parseTimestamp(nextArg)
+				if err != nil {
+					return
+				}
+				switch currentArg {
+				case "-ss":
+					opt.startTime = t
+				case "-t":
+					opt.duration = t
+				case "-to":
+					opt.endTime = t
+				}
+				i++
+				continue
+			}
+		}
+
+		if opt.operation == opToGIF || opt.operation == opToSVG {
+			if currentArg[0] != '-' {
+				if nbNonOptionArgs == 0 {
+					nbNonOptionArgs++
+					opt.itsInput = currentArg
+					continue
+				}
+				if nbNonOptionArgs == 1 {
+					nbNonOptionArgs++
+					opt.videoOutput = currentArg
+					continue
+				}
+			}
+		}
+
+		if opt.operation == opScreenshot {
+			const ddAtEqual = "--at="
+			if strings.HasPrefix(currentArg, ddAtEqual) {
+				opt.at, err = parseTimestamp(currentArg[len(ddAtEqual):])
+				if err != nil {
+					return
+				}
+				opt.atSet = true
+				continue
+			}
+
+			const ddContactSheet = "--contact-sheet"
+			if currentArg == ddContactSheet {
+				opt.contactSheet = 16
+				continue
+			}
+			if strings.HasPrefix(currentArg, ddContactSheet+"=") {
+				opt.contactSheet, err = strconv.Atoi(currentArg[len(ddContactSheet)+1:])
+				if err != nil || opt.contactSheet <= 0 {
+					err = fmt.Errorf("%v=<number of thumbnails>", ddContactSheet)
+					return
+				}
+				continue
+			}
+
+			if currentArg == "--changes" {
+				opt.sheetChanges = true
+				continue
+			}
+
+			const ddColumnsEqual = "--columns="
+			if strings.HasPrefix(currentArg, ddColumnsEqual) {
+				opt.sheetColumns, err = strconv.Atoi(currentArg[len(ddColumnsEqual):])
+				if err != nil || opt.sheetColumns <= 0 {
+					err = fmt.Errorf("%v<columns>", ddColumnsEqual)
+					return
+				}
+				continue
+			}
 
+			const ddThumbWidthEqual = "--thumb-width="
+			if strings.HasPrefix(currentArg, ddThumbWidthEqual) {
+				opt.thumbWidth, err = strconv.Atoi(currentArg[len(ddThumbWidthEqual):])
+				if err != nil || opt.thumbWidth <= 0 {
+					err = fmt.Errorf("%v<pixels>", ddThumbWidthEqual)
+					return
+				}
+				continue
+			}
+
+			if currentArg[0] != '-' {
+				if nbNonOptionArgs == 0 {
+					nbNonOptionArgs++
+					opt.itsInput = currentArg
+					continue
+				}
+				if nbNonOptionArgs == 1 {
+					nbNonOptionArgs++
+					opt.imageOutput = currentArg
+					continue
+				}
+			}
+		}
+
+		if opt.operation == opToVideo || opt.operation == opPlay {
+			const ddSpeedEqual = "--speed="
+			if strings.HasPrefix(currentArg, ddSpeedEqual) {
+				opt.speed, err = strconv.ParseFloat(currentArg[len(ddSpeedEqual):], 64)
+				if err != nil || opt.speed <= 0 {
+					err = fmt.Errorf("--speed=<positive number>")
+					return
+				}
+				if opt.operation == opPlay && (opt.speed < minPlaySpeed || opt.speed > maxPlaySpeed) {
+					err = fmt.Errorf("%v must be between %v and %v", ddSpeedEqual, minPlaySpeed, maxPlaySpeed)
+					return
+				}
+				continue
+			}
+
+			const ddMaxIdleEqual = "--max-idle="
+			if strings.HasPrefix(currentArg, ddMaxIdleEqual) {
+				opt.maxIdle, err = parseTimestamp(currentArg[len(ddMaxIdleEqual):])
+				if err != nil {
+					return
+				}
+				continue
+			}
+		}
+
+		if opt.operation == opToVideo {
 			const ddFfplay = "--ffplay"
 			if currentArg == ddFfplay {
 				opt.ffplay = true
 				continue
 			}
 
-			if currentArg == "-ss" {
-				if !hasNextArg {
-					err = fmt.Errorf("-ss <skip frames>")
+			const ddPaddingEqual = "--padding="
+			if strings.HasPrefix(currentArg, ddPaddingEqual) {
+				opt.padding, err = strconv.Atoi(currentArg[len(ddPaddingEqual):])
+				if err != nil || opt.padding < 0 {
+					err = fmt.Errorf("--padding=<pixels>")
 					return
 				}
-				var ss uint64
-				ss, err = strconv.ParseUint(nextArg, 10, 64)
+				continue
+			}
+
+			const ddBackgroundEqual = "--background="
+			if strings.HasPrefix(currentArg, ddBackgroundEqual) {
+				opt.background, err = parseHexColor(currentArg[len(ddBackgroundEqual):])
 				if err != nil {
 					return
 				}
-				opt.ss = ss
-				i++
+				opt.backgroundSet = true
 				continue
 			}
 
-			if currentArg == "-t" {
-				if !hasNextArg {
-					err = fmt.Errorf("-t <frames>")
+			if currentArg == "--chrome" {
+				opt.chrome = true
+				continue
+			}
+
+			if currentArg == "--progress" {
+				opt.progress = true
+				continue
+			}
+
+			if currentArg == "--keys" {
+				opt.keys = true
+				continue
+			}
+
+			const ddSubtitlesEqual = "--subtitles="
+			if strings.HasPrefix(currentArg, ddSubtitlesEqual) {
+				file := currentArg[len(ddSubtitlesEqual):]
+				ext := strings.ToLower(filepath.Ext(file))
+				if ext != ".vtt" && ext != ".srt" {
+					err = fmt.Errorf("%v<file.vtt|file.srt>", ddSubtitlesEqual)
 					return
 				}
-				var t uint64
-				t, err = strconv.ParseUint(nextArg, 10, 64)
-				if err != nil {
+				opt.subtitleFiles = append(opt.subtitleFiles, file)
+				continue
+			}
+
+			const ddChaptersEqual = "--chapters="
+			if strings.HasPrefix(currentArg, ddChaptersEqual) {
+				opt.chaptersFile = currentArg[len(ddChaptersEqual):]
+				if opt.chaptersFile == "" {
+					err = fmt.Errorf("%v<file>", ddChaptersEqual)
 					return
 				}
-				opt.t = t
-				i++
+				continue
+			}
+
+			if currentArg == "-" && nbNonOptionArgs == 1 {
+				// y4m to stdout
+				nbNonOptionArgs++
+				opt.videoOutput = currentArg
 				continue
 			}
 
@@ -369,6 +821,10 @@ func parseArgs(args []string) (opt options, err error) {
 			err = fmt.Errorf("Expected 3 files as argument: script, timing and output")
 			return
 		}
+		if opt.script == "-" && opt.timing == "-" {
+			err = fmt.Errorf("Only one of script and timing can be read from stdin")
+			return
+		}
 	case opPlay:
 		if nbNonOptionArgs != 1 {
 			err = fmt.Errorf("Expected input file as argument")
@@ -379,6 +835,15 @@ func parseArgs(args []string) (opt options, err error) {
 			err = fmt.Errorf("Expected 2 files as argument: input and output")
 			return
 		}
+	case opRecolor:
+		if nbNonOptionArgs != 2 {
+			err = fmt.Errorf("Expected 2 files as argument: input and output")
+			return
+		}
+		if opt.colorProfileInput == "" {
+			err = fmt.Errorf("Requires the color profile to recolor to. Pass with -c, e.g. -c solarized-light")
+			return
+		}
 	case opGetColorProfile:
 		if nbNonOptionArgs != 0 {
 			err = fmt.Errorf("Expected no additional arguments")
@@ -386,7 +851,21 @@ func parseArgs(args []string) (opt options, err error) {
 		}
 	case opCheckColorProfile:
 		if nbNonOptionArgs != 1 {
-			err = fmt.Errorf("Expected a color profile image as argument")
+			err = fmt.Errorf("Expected a color profile name or image as argument")
+			return
+		}
+	case opConvertColorProfile:
+		if nbNonOptionArgs != 2 {
+			err = fmt.Errorf("Expected 2 arguments: input color profile and output file")
+			return
+		}
+		if opt.colorProfileFormat == "" && profileFormatFromName(opt.colorProfileOutput) == "" {
+			err = fmt.Errorf("Can't tell the output format from %v. Use --format=<json|png|xresources|iterm|windows-terminal>", opt.colorProfileOutput)
+			return
+		}
+	case opListColorProfiles:
+		if nbNonOptionArgs != 0 {
+			err = fmt.Errorf("Expected no additional arguments")
 			return
 		}
 	case opToVideo:
@@ -407,7 +886,47 @@ func parseArgs(args []string) (opt options, err error) {
 			return
 		}
 		if opt.colorProfileInput == "" {
-			err = fmt.Errorf("Requires color profile. Pass with -c.")
+			err = fmt.Errorf("Requires color profile. Pass with -c, e.g. -c xterm")
+			return
+		}
+		if opt.duration > 0 && opt.endTime > 0 {
+			err = fmt.Errorf("-t and -to can't be used together")
+			return
+		}
+	case opToGIF:
+		if nbNonOptionArgs != 2 {
+			err = fmt.Errorf("Expected 2 files as argument: input and output")
+			return
+		}
+	case opToSVG:
+		if nbNonOptionArgs != 2 {
+			err = fmt.Errorf("Expected 2 files as argument: input and output")
+			return
+		}
+		if opt.duration > 0 && opt.endTime > 0 {
+			err = fmt.Errorf("-t and -to can't be used together")
+			return
+		}
+	case opScreenshot:
+		if nbNonOptionArgs != 2 {
+			err = fmt.Errorf("Expected 2 files as argument: input and output")
+			return
+		}
+		if opt.contactSheet == 0 && !opt.atSet {
+			err = fmt.Errorf("Expected either --at=<time> or --contact-sheet")
+			return
+		}
+		if opt.contactSheet > 0 && opt.atSet {
+			err = fmt.Errorf("--at can't be used with --contact-sheet")
+			return
+		}
+		if opt.duration > 0 && opt.endTime > 0 {
+			err = fmt.Errorf("-t and -to can't be used together")
+			return
+		}
+	case opToHTML:
+		if nbNonOptionArgs != 2 {
+			err = fmt.Errorf("Expected 2 files as argument: input and output")
 			return
 		}
 	default:
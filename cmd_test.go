@@ -0,0 +1,38 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"testing"
+)
+
+func Test_parseTimestamp(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"0", 0},
+		{"90", 90},
+		{"2.5", 2.5},
+		{"1:30", 90},
+		{"01:02:03", 3723},
+		{"1:23:45.5", 5025.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseTimestamp(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("parseTimestamp(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+	for _, bad := range []string{"", "-1", "1:", "a", "1:2:3:4", "1.2.3"} {
+		if _, err := parseTimestamp(bad); err == nil {
+			t.Errorf("parseTimestamp(%v) should fail", bad)
+		}
+	}
+}
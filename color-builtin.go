@@ -0,0 +1,153 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mattn/go-isatty"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// builtinPalette is a well-known terminal color scheme, as 0xRRGGBB values.
+type builtinPalette struct {
+	fg, bg uint32
+	base   [16]uint32
+}
+
+var builtinPalettes = map[string]builtinPalette{
+	"xterm": {0xe5e5e5, 0x000000, [16]uint32{
+		0x000000, 0xcd0000, 0x00cd00, 0xcdcd00, 0x0000ee, 0xcd00cd, 0x00cdcd, 0xe5e5e5,
+		0x7f7f7f, 0xff0000, 0x00ff00, 0xffff00, 0x5c5cff, 0xff00ff, 0x00ffff, 0xffffff,
+	}},
+	"vga": {0xaaaaaa, 0x000000, [16]uint32{
+		0x000000, 0xaa0000, 0x00aa00, 0xaa5500, 0x0000aa, 0xaa00aa, 0x00aaaa, 0xaaaaaa,
+		0x555555, 0xff5555, 0x55ff55, 0xffff55, 0x5555ff, 0xff55ff, 0x55ffff, 0xffffff,
+	}},
+	"solarized-dark": {0x839496, 0x002b36, [16]uint32{
+		0x073642, 0xdc322f, 0x859900, 0xb58900, 0x268bd2, 0xd33682, 0x2aa198, 0xeee8d5,
+		0x002b36, 0xcb4b16, 0x586e75, 0x657b83, 0x839496, 0x6c71c4, 0x93a1a1, 0xfdf6e3,
+	}},
+	"solarized-light": {0x657b83, 0xfdf6e3, [16]uint32{
+		0x073642, 0xdc322f, 0x859900, 0xb58900, 0x268bd2, 0xd33682, 0x2aa198, 0xeee8d5,
+		0x002b36, 0xcb4b16, 0x586e75, 0x657b83, 0x839496, 0x6c71c4, 0x93a1a1, 0xfdf6e3,
+	}},
+	"tango": {0xd3d7cf, 0x2e3436, [16]uint32{
+		0x2e3436, 0xcc0000, 0x4e9a06, 0xc4a000, 0x3465a4, 0x75507b, 0x06989a, 0xd3d7cf,
+		0x555753, 0xef2929, 0x8ae234, 0xfce94f, 0x729fcf, 0xad7fa8, 0x34e2e2, 0xeeeeec,
+	}},
+	"gruvbox-dark": {0xebdbb2, 0x282828, [16]uint32{
+		0x282828, 0xcc241d, 0x98971a, 0xd79921, 0x458588, 0xb16286, 0x689d6a, 0xa89984,
+		0x928374, 0xfb4934, 0xb8bb26, 0xfabd2f, 0x83a598, 0xd3869b, 0x8ec07c, 0xebdbb2,
+	}},
+	"gruvbox-light": {0x3c3836, 0xfbf1c7, [16]uint32{
+		0xfbf1c7, 0xcc241d, 0x98971a, 0xd79921, 0x458588, 0xb16286, 0x689d6a, 0x7c6f64,
+		0x928374, 0x9d0006, 0x79740e, 0xb57614, 0x076678, 0x8f3f71, 0x427b58, 0x3c3836,
+	}},
+	"dracula": {0xf8f8f2, 0x282a36, [16]uint32{
+		0x21222c, 0xff5555, 0x50fa7b, 0xf1fa8c, 0xbd93f9, 0xff79c6, 0x8be9fd, 0xf8f8f2,
+		0x6272a4, 0xff6e6e, 0x69ff94, 0xffffa5, 0xd6acff, 0xff92df, 0xa4ffff, 0xffffff,
+	}},
+	"nord": {0xd8dee9, 0x2e3440, [16]uint32{
+		0x3b4252, 0xbf616a, 0xa3be8c, 0xebcb8b, 0x81a1c1, 0xb48ead, 0x88c0d0, 0xe5e9f0,
+		0x4c566a, 0xbf616a, 0xa3be8c, 0xebcb8b, 0x81a1c1, 0xb48ead, 0x8fbcbb, 0xeceff4,
+	}},
+	"monokai": {0xf8f8f2, 0x272822, [16]uint32{
+		0x272822, 0xf92672, 0xa6e22e, 0xf4bf75, 0x66d9ef, 0xae81ff, 0xa1efe4, 0xf8f8f2,
+		0x75715e, 0xf92672, 0xa6e22e, 0xf4bf75, 0x66d9ef, 0xae81ff, 0xa1efe4, 0xf9f8f5,
+	}},
+}
+
+// builtinPaletteAliases are other names of builtin palettes.
+var builtinPaletteAliases = map[string]string{
+	"linux": "vga",
+}
+
+func rgbFromUint32(c uint32) color.RGBA {
+	return color.RGBA{uint8(c >> 16), uint8(c >> 8), uint8(c), 255}
+}
+
+// builtinColorProfile returns the built-in color profile with the given name, ignoring case.
+func builtinColorProfile(name string) (cf colorProfile, ok bool) {
+	name = strings.ToLower(name)
+	if alias, isAlias := builtinPaletteAliases[name]; isAlias {
+		name = alias
+	}
+	p, ok := builtinPalettes[name]
+	if !ok {
+		return
+	}
+	for i, c := range p.base {
+		cf.palette[i] = rgbFromUint32(c)
+	}
+	cf.fill256()
+	cf.fg = rgbFromUint32(p.fg)
+	cf.bg = rgbFromUint32(p.bg)
+	return
+}
+
+func builtinColorProfileNames() []string {
+	names := make([]string, 0, len(builtinPalettes)+len(builtinPaletteAliases))
+	for name := range builtinPalettes {
+		names = append(names, name)
+	}
+	for name := range builtinPaletteAliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadColorProfile loads the color profile given with -c, which is either the name of a built-in profile,
+// a PNG or JPEG screenshot of the get-color-profile pattern, or a color scheme file of a terminal. Built-in
+// names are only looked up if there is no such file and the name has no path separator or extension.
+func loadColorProfile(nameOrFile string) (colorProfile, error) {
+	// A file takes precedence, so that one named like a built-in profile can still be used.
+	data, err := ioutil.ReadFile(nameOrFile)
+	if os.IsNotExist(err) && !strings.ContainsAny(nameOrFile, "/.") {
+		if cf, ok := builtinColorProfile(nameOrFile); ok {
+			return cf, nil
+		}
+		return colorProfile{}, fmt.Errorf("No built-in color profile named %v. See ts-player list-color-profiles", nameOrFile)
+	}
+	if err != nil {
+		return colorProfile{}, err
+	}
+	if isImageFile(nameOrFile, data) {
+		return processColorProfile(nameOrFile)
+	}
+	return parseColorScheme(nameOrFile, data)
+}
+
+func isImageFile(name string, data []byte) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png", ".jpg", ".jpeg":
+		return true
+	}
+	return bytes.HasPrefix(data, []byte("\x89PNG")) || bytes.HasPrefix(data, []byte("\xff\xd8\xff"))
+}
+
+// doOpListColorProfiles prints the names of the built-in color profiles, with a sample of their colors if
+// stdout is a terminal.
+func doOpListColorProfiles(opt options) {
+	showColors := isatty.IsTerminal(1)
+	for _, name := range builtinColorProfileNames() {
+		if !showColors {
+			fmt.Fprintf(os.Stdout, "%v\n", name)
+			continue
+		}
+		cf, _ := builtinColorProfile(name)
+		fmt.Fprintf(os.Stdout, "%-16v \033[38;2;%d;%d;%dm\033[48;2;%d;%d;%dm fg ", name, cf.fg.R, cf.fg.G, cf.fg.B, cf.bg.R, cf.bg.G, cf.bg.B)
+		for i := 0; i < 16; i++ {
+			c := cf.palette[i]
+			fmt.Fprintf(os.Stdout, "\033[48;2;%d;%d;%dm  ", c.R, c.G, c.B)
+		}
+		os.Stdout.WriteString("\033[0m\n")
+	}
+}
@@ -0,0 +1,66 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_loadColorProfile(t *testing.T) {
+	for _, name := range builtinColorProfileNames() {
+		cf, err := loadColorProfile(name)
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if cf.palette[16] != (color.RGBA{0, 0, 0, 255}) || cf.palette[231] != (color.RGBA{255, 255, 255, 255}) {
+			t.Errorf("%v: color cube not filled", name)
+		}
+		if cf.fg.A != 255 || cf.bg.A != 255 {
+			t.Errorf("%v: fg or bg not set", name)
+		}
+	}
+	cf, err := loadColorProfile("Solarized-Dark")
+	if err != nil || cf.bg != (color.RGBA{0x00, 0x2b, 0x36, 255}) || cf.palette[1] != (color.RGBA{0xdc, 0x32, 0x2f, 255}) {
+		t.Errorf("solarized-dark = %v, %v", cf, err)
+	}
+	if _, err := loadColorProfile("no-such-profile"); err == nil {
+		t.Errorf("expected error for unknown profile")
+	}
+	linux, _ := loadColorProfile("linux")
+	vga, _ := loadColorProfile("vga")
+	if linux != vga {
+		t.Errorf("linux is not the same as vga")
+	}
+}
+
+func Test_loadColorProfile_filePrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ts-player-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	want, _ := builtinColorProfile("solarized-light")
+	var buf bytes.Buffer
+	if err := writeXresources(&buf, &want); err != nil {
+		t.Fatal(err)
+	}
+	// a file in the current directory named like a built-in profile
+	if err := ioutil.WriteFile(filepath.Join(dir, "nord"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	cf, err := loadColorProfile("nord")
+	if err != nil || cf.bg != want.bg || cf.palette[1] != want.palette[1] {
+		t.Errorf("nord = %v, %v, expected the file to be loaded", cf.bg, err)
+	}
+}
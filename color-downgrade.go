@@ -0,0 +1,169 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// colorMode is how many colors the terminal playing a recording can show.
+type colorMode int
+
+const (
+	colorModeTruecolor colorMode = iota
+	colorMode256
+	colorMode16
+	colorModeMono
+)
+
+func parseColorMode(s string) (colorMode, bool) {
+	switch s {
+	case "truecolor", "24bit":
+		return colorModeTruecolor, true
+	case "256":
+		return colorMode256, true
+	case "16":
+		return colorMode16, true
+	case "mono":
+		return colorModeMono, true
+	}
+	return 0, false
+}
+
+// detectColorMode guesses the color capability of the terminal from the COLORTERM and TERM environment
+// variables, and the number of colors its terminfo entry gives, or -1 without one. Many terminals which can
+// show RGB colors don't say so, e.g. over SSH or in tmux, so colors are only reduced for terminals which
+// clearly have less than 256 of them. 256 colors can be asked for with --colors=.
+func detectColorMode(getenv func(string) string, terminfoColors int) colorMode {
+	switch strings.ToLower(getenv("COLORTERM")) {
+	case "truecolor", "24bit":
+		return colorModeTruecolor
+	}
+	term := getenv("TERM")
+	switch {
+	case terminfoColors >= 256:
+		return colorModeTruecolor
+	case terminfoColors >= 8:
+		return colorMode16
+	case terminfoColors >= 0:
+		return colorModeMono
+	}
+	switch {
+	case term == "linux":
+		return colorMode16
+	case term == "" || term == "dumb" || strings.HasPrefix(term, "vt") || strings.HasSuffix(term, "-mono") || strings.HasSuffix(term, "-m"):
+		return colorModeMono
+	}
+	return colorModeTruecolor
+}
+
+// colorQuantizer turns attribute codes into escape sequences for a terminal with fewer colors, by picking the
+// nearest color it has. The result for each attribute code is cached, since a recording only uses a few.
+type colorQuantizer struct {
+	mode colorMode
+	// palette gives the RGB values of indexed colors, and is what the 16 basic colors are assumed to look like.
+	palette *colorProfile
+	cache   map[uint64]string
+}
+
+func newColorQuantizer(mode colorMode, palette *colorProfile) *colorQuantizer {
+	if palette == nil {
+		cf := xtermColorProfile()
+		palette = &cf
+	}
+	return &colorQuantizer{mode: mode, palette: palette, cache: make(map[uint64]string)}
+}
+
+// colorDistance is the squared distance between two colors, weighted for how sensitive the eye is to each
+// channel.
+func colorDistance(a, b color.RGBA) int {
+	dr, dg, db := int(a.R)-int(b.R), int(a.G)-int(b.G), int(a.B)-int(b.B)
+	return 2*dr*dr + 4*dg*dg + 3*db*db
+}
+
+// nearestIndex returns the index in [from, to) of the palette color closest to c.
+func (q *colorQuantizer) nearestIndex(c color.RGBA, from, to int) int {
+	best, bestDistance := from, -1
+	for i := from; i < to; i++ {
+		if d := colorDistance(c, q.palette.palette[i]); bestDistance < 0 || d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	return best
+}
+
+// quantize returns the palette index to use for a color of the recording. Colors 16 to 255 are the same in
+// almost every terminal, while the first 16 depend on its theme, so an RGB color is only mapped to those in
+// 16 color mode.
+func (q *colorQuantizer) quantize(c color.RGBA, indexed bool) int {
+	if indexed {
+		index := int(c.B)
+		if q.mode == colorMode256 || index < 16 {
+			return index
+		}
+		c = q.palette.palette[index]
+	}
+	if q.mode == colorMode256 {
+		return q.nearestIndex(c, 16, 256)
+	}
+	return q.nearestIndex(c, 0, 16)
+}
+
+func luminance(c color.RGBA) int {
+	return 299*int(c.R) + 587*int(c.G) + 114*int(c.B)
+}
+
+// sgr returns the escape sequence setting the attributes of code, as frameCell.toOutput would on a truecolor
+// terminal.
+func (q *colorQuantizer) sgr(code uint64) string {
+	if s, ok := q.cache[code]; ok {
+		return s
+	}
+	a := unpackAttrCode(code)
+	var s strings.Builder
+	switch q.mode {
+	case colorModeMono:
+		// Without colors, keep light-on-dark and dark-on-light apart, so that selections and status bars stay
+		// visible.
+		fg, bg := a.fg, a.bg
+		if a.fgIndexed {
+			fg = q.palette.palette[a.fg.B]
+		}
+		if a.bgIndexed {
+			bg = q.palette.palette[a.bg.B]
+		}
+		if luminance(bg) > luminance(fg) {
+			s.WriteString("\033[39;49;7m")
+		} else {
+			s.WriteString("\033[39;49;27m")
+		}
+	case colorMode256:
+		fmt.Fprintf(&s, "\033[48;5;%dm\033[38;5;%dm", q.quantize(a.bg, a.bgIndexed), q.quantize(a.fg, a.fgIndexed))
+	default:
+		bg, fg := q.quantize(a.bg, a.bgIndexed), q.quantize(a.fg, a.fgIndexed)
+		// 100-107 and 90-97 are the bright colors
+		bgCode, fgCode := 40+bg, 30+fg
+		if bg >= 8 {
+			bgCode = 100 + bg - 8
+		}
+		if fg >= 8 {
+			fgCode = 90 + fg - 8
+		}
+		fmt.Fprintf(&s, "\033[%dm\033[%dm", bgCode, fgCode)
+	}
+	if a.bold {
+		s.WriteString("\033[1m")
+	} else {
+		s.WriteString("\033[22m")
+	}
+	if a.underline {
+		s.WriteString("\033[4m")
+	} else {
+		s.WriteString("\033[24m")
+	}
+	q.cache[code] = s.String()
+	return q.cache[code]
+}
@@ -0,0 +1,73 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"github.com/micromaomao/go-libvterm"
+	"image/color"
+	"testing"
+)
+
+func Test_detectColorMode(t *testing.T) {
+	tests := []struct {
+		colorterm, term string
+		colors          int
+		want            colorMode
+	}{
+		{"truecolor", "xterm-256color", 256, colorModeTruecolor},
+		{"24bit", "screen", 8, colorModeTruecolor},
+		{"", "xterm-direct", -1, colorModeTruecolor},
+		// without COLORTERM, e.g. over SSH, RGB colors are still used unless the terminal clearly can't show them
+		{"", "xterm-256color", 256, colorModeTruecolor},
+		{"", "tmux-256color", -1, colorModeTruecolor},
+		{"", "xterm", -1, colorModeTruecolor},
+		{"", "linux", -1, colorMode16},
+		{"", "vt100", -1, colorModeMono},
+		{"", "dumb", -1, colorModeMono},
+		{"", "", -1, colorModeMono},
+		{"", "kitty", 1 << 24, colorModeTruecolor},
+		{"", "linux", 8, colorMode16},
+		{"", "xterm-256color", 0, colorModeMono},
+	}
+	for _, tt := range tests {
+		env := map[string]string{"COLORTERM": tt.colorterm, "TERM": tt.term}
+		if got := detectColorMode(func(k string) string { return env[k] }, tt.colors); got != tt.want {
+			t.Errorf("COLORTERM=%v TERM=%v colors=%v: got %v, expected %v", tt.colorterm, tt.term, tt.colors, got, tt.want)
+		}
+	}
+}
+
+func Test_colorQuantizer(t *testing.T) {
+	cell := func(fg, bg vterm.VTermColor) uint64 {
+		c := frameCell{}
+		c.style.fg = fg
+		c.style.bg = bg
+		return c.attrCode(nil)
+	}
+	rgb := func(r, g, b uint8) vterm.VTermColor {
+		return vterm.NewVTermColorRGB(color.RGBA{r, g, b, 255})
+	}
+	tests := []struct {
+		mode colorMode
+		code uint64
+		want string
+	}{
+		{colorMode256, cell(rgb(0xff, 0x87, 0x00), rgb(0, 0, 0)), "\033[48;5;16m\033[38;5;208m\033[22m\033[24m"},
+		{colorMode256, cell(vterm.NewVTermColorIndexed(3), vterm.NewVTermColorIndexed(200)), "\033[48;5;200m\033[38;5;3m\033[22m\033[24m"},
+		{colorMode16, cell(rgb(0xf0, 0x10, 0x10), rgb(0x10, 0x10, 0xe0)), "\033[44m\033[91m\033[22m\033[24m"},
+		{colorMode16, cell(vterm.NewVTermColorIndexed(9), vterm.NewVTermColorIndexed(196)), "\033[101m\033[91m\033[22m\033[24m"},
+		{colorModeMono, cell(rgb(0, 0, 0), rgb(0xff, 0xff, 0xff)), "\033[39;49;7m\033[22m\033[24m"},
+		{colorModeMono, cell(vterm.NewVTermColorIndexed(7), vterm.NewVTermColorIndexed(0)), "\033[39;49;27m\033[22m\033[24m"},
+	}
+	for _, tt := range tests {
+		q := newColorQuantizer(tt.mode, nil)
+		got := q.sgr(tt.code)
+		if got != tt.want {
+			t.Errorf("mode %v, code %x: got %q, expected %q", tt.mode, tt.code, got, tt.want)
+		}
+		if q.sgr(tt.code) != got || len(q.cache) != 1 {
+			t.Errorf("mode %v, code %x: result not cached", tt.mode, tt.code)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Color profile output formats of convert-color-profile.
+const (
+	profileFormatJSON        = "json"
+	profileFormatPNG         = "png"
+	profileFormatXresources  = "xresources"
+	profileFormatITerm       = "iterm"
+	profileFormatWindowsTerm = "windows-terminal"
+)
+
+// Size of a cell of the pattern in images written by writeColorProfilePNG.
+const (
+	patternCellWidth  = 8
+	patternCellHeight = 16
+	patternMargin     = 16
+)
+
+// profileFormatFromName tells the format to write from the output file name.
+func profileFormatFromName(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return profileFormatJSON
+	case ".png":
+		return profileFormatPNG
+	case ".xresources", ".xdefaults":
+		return profileFormatXresources
+	case ".itermcolors":
+		return profileFormatITerm
+	}
+	return ""
+}
+
+func doOpConvertColorProfile(opt options) {
+	cf, err := loadColorProfile(opt.colorProfileInput)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err.Error())
+		os.Exit(1)
+	}
+	format := opt.colorProfileFormat
+	if format == "" {
+		format = profileFormatFromName(opt.colorProfileOutput)
+	}
+	out := os.Stdout
+	if opt.colorProfileOutput != "-" {
+		out, err = os.OpenFile(opt.colorProfileOutput, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			panic(fmt.Errorf("%v when opening %v for writing", err.Error(), opt.colorProfileOutput))
+		}
+		defer out.Close()
+	}
+	name := strings.TrimSuffix(filepath.Base(opt.colorProfileInput), filepath.Ext(opt.colorProfileInput))
+	switch format {
+	case profileFormatJSON:
+		err = writeColorProfileJSON(out, &cf)
+	case profileFormatPNG:
+		err = writeColorProfilePNG(out, &cf)
+	case profileFormatXresources:
+		err = writeXresources(out, &cf)
+	case profileFormatITerm:
+		err = writeITermColors(out, &cf)
+	case profileFormatWindowsTerm:
+		err = writeWindowsTerminalScheme(out, &cf, name)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// writeColorProfileJSON writes a color profile in the JSON format read by -c, with all 256 colors.
+func writeColorProfileJSON(w io.Writer, cf *colorProfile) error {
+	p := colorProfileJSON{Foreground: rgbaToHex(cf.fg), Background: rgbaToHex(cf.bg)}
+	for _, c := range cf.palette {
+		p.Palette = append(p.Palette, rgbaToHex(c))
+	}
+	buf, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(buf, '\n'))
+	return err
+}
+
+// writeXresources writes a color profile as X resources, which can be read back as a color profile.
+func writeXresources(w io.Writer, cf *colorProfile) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "*foreground: %v\n*background: %v\n", rgbaToHex(cf.fg), rgbaToHex(cf.bg))
+	for i, c := range cf.palette {
+		fmt.Fprintf(bw, "*color%d: %v\n", i, rgbaToHex(c))
+	}
+	return bw.Flush()
+}
+
+// writeITermColors writes the 16 base colors, foreground and background as an .itermcolors property list.
+func writeITermColors(w io.Writer, cf *colorProfile) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	writeColor := func(name string, c color.RGBA) {
+		fmt.Fprintf(bw, "\t<key>%v</key>\n\t<dict>\n", name)
+		fmt.Fprintf(bw, "\t\t<key>Alpha Component</key>\n\t\t<real>1</real>\n")
+		fmt.Fprintf(bw, "\t\t<key>Blue Component</key>\n\t\t<real>%.8f</real>\n", float64(c.B)/255)
+		fmt.Fprintf(bw, "\t\t<key>Color Space</key>\n\t\t<string>sRGB</string>\n")
+		fmt.Fprintf(bw, "\t\t<key>Green Component</key>\n\t\t<real>%.8f</real>\n", float64(c.G)/255)
+		fmt.Fprintf(bw, "\t\t<key>Red Component</key>\n\t\t<real>%.8f</real>\n", float64(c.R)/255)
+		bw.WriteString("\t</dict>\n")
+	}
+	for i := 0; i < 16; i++ {
+		writeColor(fmt.Sprintf("Ansi %d Color", i), cf.palette[i])
+	}
+	writeColor("Background Color", cf.bg)
+	writeColor("Foreground Color", cf.fg)
+	bw.WriteString("</dict>\n</plist>\n")
+	return bw.Flush()
+}
+
+// writeWindowsTerminalScheme writes the 16 base colors, foreground and background as a Windows Terminal
+// color scheme, to be added to the schemes list of settings.json.
+func writeWindowsTerminalScheme(w io.Writer, cf *colorProfile, name string) error {
+	type kv struct {
+		key   string
+		value string
+	}
+	entries := []kv{{"name", name}, {"foreground", rgbaToHex(cf.fg)}, {"background", rgbaToHex(cf.bg)}}
+	for i, n := range ansiColorNames {
+		if n == "magenta" {
+			n = "purple"
+		}
+		entries = append(entries, kv{n, rgbaToHex(cf.palette[i])})
+	}
+	for i, n := range ansiColorNames {
+		if n == "magenta" {
+			n = "purple"
+		}
+		entries = append(entries, kv{"bright" + strings.ToUpper(n[:1]) + n[1:], rgbaToHex(cf.palette[i+8])})
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString("{\n")
+	for i, e := range entries {
+		key, _ := json.Marshal(e.key)
+		value, _ := json.Marshal(e.value)
+		fmt.Fprintf(bw, "  %s: %s", key, value)
+		if i < len(entries)-1 {
+			bw.WriteString(",")
+		}
+		bw.WriteString("\n")
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// renderColorProfilePattern draws the pattern printed by get-color-profile with the colors of cf, as a
+// screenshot of it would look, so that the image can be read back by processColorProfile.
+func renderColorProfilePattern(cf *colorProfile) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, patternWidth*patternCellWidth+2*patternMargin, patternHeight*patternCellHeight+2*patternMargin))
+	fill := func(x, y int, c color.RGBA) {
+		for py := 0; py < patternCellHeight; py++ {
+			for px := 0; px < patternCellWidth; px++ {
+				img.SetRGBA(patternMargin+x*patternCellWidth+px, patternMargin+y*patternCellHeight+py, c)
+			}
+		}
+	}
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			img.SetRGBA(x, y, cf.bg)
+		}
+	}
+	// finder pattern: alternating along the top and bottom rows and the left and right columns
+	for x := 0; x < patternWidth; x++ {
+		top, bottom := finderBg, finderFg
+		if x%2 == 1 {
+			top, bottom = bottom, top
+		}
+		fill(x, 0, top)
+		fill(x, patternHeight-1, bottom)
+	}
+	for y := 0; y < patternHeight; y++ {
+		left, right := finderBg, finderFg
+		if y%2 == 1 {
+			left, right = right, left
+		}
+		fill(0, y, left)
+		fill(patternWidth-1, y, right)
+	}
+	for i, c := range cf.palette {
+		fill(1+i%(patternWidth-2), 1+i/(patternWidth-2), c)
+	}
+	fill(patternWidth-1, patternHeight-3, cf.fg)
+	fill(patternWidth-1, patternHeight-2, cf.bg)
+	return img
+}
+
+func writeColorProfilePNG(w io.Writer, cf *colorProfile) error {
+	return png.Encode(w, renderColorProfilePattern(cf))
+}
@@ -0,0 +1,56 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_colorProfileExport(t *testing.T) {
+	cf, _ := builtinColorProfile("solarized-light")
+	cf.palette[100] = cf.palette[3]
+	dir, err := ioutil.TempDir("", "ts-player-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		filename string
+		write    func(io.Writer, *colorProfile) error
+		only16   bool
+	}{
+		{"profile.json", writeColorProfileJSON, false},
+		{"profile.png", writeColorProfilePNG, false},
+		{"profile.Xresources", writeXresources, false},
+		{"profile.itermcolors", writeITermColors, true},
+		{"scheme.json", func(w io.Writer, cf *colorProfile) error { return writeWindowsTerminalScheme(w, cf, "test") }, true},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := tt.write(&buf, &cf); err != nil {
+			t.Fatal(err)
+		}
+		filename := filepath.Join(dir, tt.filename)
+		if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadColorProfile(filename)
+		if err != nil {
+			t.Errorf("%v: %v", tt.filename, err)
+			continue
+		}
+		want := cf
+		if tt.only16 {
+			want.fill256()
+		}
+		if got != want {
+			t.Errorf("%v: read back fg %v bg %v palette %v", tt.filename, got.fg, got.bg, got.palette[:16])
+		}
+	}
+}
@@ -0,0 +1,595 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Color scheme file formats that can be used as color profiles, in addition to PNG screenshots.
+const (
+	schemeXresources    = "Xresources"
+	schemeITerm         = "iTerm2"
+	schemeWindowsTerm   = "Windows Terminal"
+	schemeKitty         = "kitty"
+	schemeAlacrittyYAML = "Alacritty YAML"
+	schemeAlacrittyTOML = "Alacritty TOML"
+	schemeBase16        = "base16"
+	schemeJSON          = "ts-player JSON"
+	schemeUnknownFormat = ""
+)
+
+var ansiColorNames = [8]string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// base16Terminal maps the 16 terminal colors to base16 colors, the same way as base16-shell.
+var base16Terminal = [16]int{0x0, 0x8, 0xb, 0xa, 0xd, 0xe, 0xc, 0x5, 0x3, 0x8, 0xb, 0xa, 0xd, 0xe, 0xc, 0x7}
+
+var (
+	regKittyColor      = regexp.MustCompile(`(?m)^\s*(color\d+|foreground|background)\s+#?[0-9a-fA-F]{6}\s*$`)
+	regXresourcesColor = regexp.MustCompile(`(?m)^\s*[\w.*]*(color\d+|foreground|background)\s*:`)
+	regBase16          = regexp.MustCompile(`(?m)^\s*base0[0-9A-Fa-f]\s*:`)
+	regProfileJSON     = regexp.MustCompile(`"palette"\s*:\s*\[`)
+)
+
+// detectColorScheme tells the format of a color scheme file from its extension, or its content if the
+// extension doesn't tell.
+func detectColorScheme(filename string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".itermcolors":
+		return schemeITerm
+	case ".json":
+		if regProfileJSON.Match(data) {
+			return schemeJSON
+		}
+		return schemeWindowsTerm
+	case ".conf":
+		return schemeKitty
+	case ".toml":
+		return schemeAlacrittyTOML
+	case ".yml", ".yaml":
+		if regBase16.Match(data) {
+			return schemeBase16
+		}
+		return schemeAlacrittyYAML
+	case ".xresources", ".xdefaults":
+		return schemeXresources
+	}
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<?xml")) || bytes.HasPrefix(trimmed, []byte("<plist")):
+		return schemeITerm
+	case bytes.HasPrefix(trimmed, []byte("{")) && regProfileJSON.Match(data):
+		return schemeJSON
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return schemeWindowsTerm
+	case regBase16.Match(data):
+		return schemeBase16
+	case bytes.Contains(data, []byte("[colors")):
+		return schemeAlacrittyTOML
+	case bytes.Contains(data, []byte("colors:")):
+		return schemeAlacrittyYAML
+	case regKittyColor.Match(data):
+		return schemeKitty
+	case regXresourcesColor.Match(data):
+		return schemeXresources
+	}
+	return schemeUnknownFormat
+}
+
+// parseColorScheme reads a color scheme file in one of the formats detected by detectColorScheme.
+func parseColorScheme(filename string, data []byte) (cf colorProfile, err error) {
+	format := detectColorScheme(filename, data)
+	b := &schemeBuilder{}
+	switch format {
+	case schemeXresources:
+		err = b.readXresources(data)
+	case schemeITerm:
+		err = b.readITerm(data)
+	case schemeWindowsTerm:
+		err = b.readWindowsTerminal(data)
+	case schemeJSON:
+		err = b.readProfileJSON(data)
+	case schemeKitty:
+		err = b.readKitty(data)
+	case schemeAlacrittyYAML:
+		err = b.readAlacritty(parseYAMLKeys(data))
+	case schemeAlacrittyTOML:
+		err = b.readAlacritty(parseTOMLKeys(data))
+	case schemeBase16:
+		err = b.readBase16(parseYAMLKeys(data))
+	default:
+		err = fmt.Errorf("Unknown color profile format. Expected a PNG screenshot, a JSON color profile, or a Xresources, iTerm2, Windows Terminal, kitty, Alacritty or base16 color scheme")
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("%v: %v", format, err.Error())
+		return
+	}
+	cf, err = b.profile()
+	if err != nil {
+		err = fmt.Errorf("%v: %v", format, err.Error())
+	}
+	return
+}
+
+// schemeBuilder collects the colors read from a color scheme, which may not set every color.
+type schemeBuilder struct {
+	palette      [256]color.RGBA
+	set          [256]bool
+	fg, bg       color.RGBA
+	fgSet, bgSet bool
+}
+
+func (b *schemeBuilder) setColor(i int, value string) error {
+	c, err := parseSchemeColor(value)
+	if err != nil {
+		return err
+	}
+	if i < 0 || i > 255 {
+		return fmt.Errorf("color index %v out of range", i)
+	}
+	b.palette[i] = c
+	b.set[i] = true
+	return nil
+}
+
+func (b *schemeBuilder) setFg(value string) (err error) {
+	b.fg, err = parseSchemeColor(value)
+	b.fgSet = err == nil
+	return
+}
+
+func (b *schemeBuilder) setBg(value string) (err error) {
+	b.bg, err = parseSchemeColor(value)
+	b.bgSet = err == nil
+	return
+}
+
+// profile makes a colorProfile from the colors read. The 8 normal colors must be set. Bright colors that
+// aren't set are the same as the normal ones, and colors 16 to 255 are computed unless set.
+func (b *schemeBuilder) profile() (cf colorProfile, err error) {
+	for i := 0; i < 8; i++ {
+		if !b.set[i] {
+			err = fmt.Errorf("color %v (%v) not found", i, ansiColorNames[i])
+			return
+		}
+		cf.palette[i] = b.palette[i]
+		cf.palette[i+8] = b.palette[i]
+		if b.set[i+8] {
+			cf.palette[i+8] = b.palette[i+8]
+		}
+	}
+	cf.fill256()
+	for i := 16; i < 256; i++ {
+		if b.set[i] {
+			cf.palette[i] = b.palette[i]
+		}
+	}
+	cf.fg, cf.bg = cf.palette[7], cf.palette[0]
+	if b.fgSet {
+		cf.fg = b.fg
+	}
+	if b.bgSet {
+		cf.bg = b.bg
+	}
+	return
+}
+
+// parseSchemeColor parses the color formats used in color scheme files: #rrggbb, #rgb, 0xrrggbb, rrggbb
+// and X11's rgb:rr/gg/bb, optionally quoted.
+func parseSchemeColor(s string) (color.RGBA, error) {
+	s = strings.Trim(strings.TrimSpace(s), `"'`)
+	if strings.HasPrefix(s, "rgb:") {
+		parts := strings.Split(s[len("rgb:"):], "/")
+		if len(parts) != 3 {
+			return color.RGBA{}, fmt.Errorf("Invalid color %v", strconv.Quote(s))
+		}
+		var c [3]uint8
+		for i, p := range parts {
+			v, err := strconv.ParseUint(p, 16, 16)
+			if err != nil || len(p) < 1 || len(p) > 4 {
+				return color.RGBA{}, fmt.Errorf("Invalid color %v", strconv.Quote(s))
+			}
+			// scale 1 to 4 hex digits to 8 bits
+			c[i] = uint8(math.Round(float64(v) * 255 / float64(uint64(1)<<(4*uint(len(p)))-1)))
+		}
+		return color.RGBA{c[0], c[1], c[2], 255}, nil
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s = s[2:]
+	}
+	return parseHexColor(s)
+}
+
+// readXresources reads *color0: #rrggbb style resources, with any resource class or name prefix.
+// #define macros, as used by base16-xresources, are expanded.
+func (b *schemeBuilder) readXresources(data []byte) error {
+	defines := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '!' {
+			continue
+		}
+		if strings.HasPrefix(line, "#define") {
+			fields := strings.Fields(line)
+			if len(fields) >= 3 {
+				defines[fields[1]] = fields[2]
+			}
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:colon])
+		if dot := strings.LastIndexAny(name, ".*"); dot >= 0 {
+			name = name[dot+1:]
+		}
+		value := strings.TrimSpace(line[colon+1:])
+		if v, ok := defines[value]; ok {
+			value = v
+		}
+		if err := b.setNamedColor(strings.ToLower(name), value); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// setNamedColor sets foreground, background or colorN.
+func (b *schemeBuilder) setNamedColor(name, value string) error {
+	switch {
+	case name == "foreground":
+		return b.setFg(value)
+	case name == "background":
+		return b.setBg(value)
+	case strings.HasPrefix(name, "color"):
+		i, err := strconv.Atoi(name[len("color"):])
+		if err != nil {
+			return nil
+		}
+		return b.setColor(i, value)
+	}
+	return nil
+}
+
+// readKitty reads foreground, background and colorN from a kitty.conf or kitty theme.
+func (b *schemeBuilder) readKitty(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if err := b.setNamedColor(fields[0], fields[1]); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// readWindowsTerminal reads a Windows Terminal color scheme, or the first scheme in a settings.json.
+func (b *schemeBuilder) readWindowsTerminal(data []byte) error {
+	var scheme map[string]interface{}
+	if err := json.Unmarshal(data, &scheme); err != nil {
+		return err
+	}
+	if schemes, ok := scheme["schemes"].([]interface{}); ok {
+		if len(schemes) == 0 {
+			return fmt.Errorf("no schemes")
+		}
+		scheme, _ = schemes[0].(map[string]interface{})
+	}
+	value := func(key string) (string, bool) {
+		v, ok := scheme[key].(string)
+		return v, ok
+	}
+	for i, name := range ansiColorNames {
+		if name == "magenta" {
+			name = "purple"
+		}
+		if v, ok := value(name); ok {
+			if err := b.setColor(i, v); err != nil {
+				return err
+			}
+		}
+		if v, ok := value("bright" + strings.ToUpper(name[:1]) + name[1:]); ok {
+			if err := b.setColor(i+8, v); err != nil {
+				return err
+			}
+		}
+	}
+	if v, ok := value("foreground"); ok {
+		if err := b.setFg(v); err != nil {
+			return err
+		}
+	}
+	if v, ok := value("background"); ok {
+		if err := b.setBg(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// colorProfileJSON is the text format of color profiles written by convert-color-profile. palette has
+// either all 256 colors or the first 16, in which case the rest are computed.
+type colorProfileJSON struct {
+	Foreground string   `json:"foreground"`
+	Background string   `json:"background"`
+	Palette    []string `json:"palette"`
+}
+
+func (b *schemeBuilder) readProfileJSON(data []byte) error {
+	var p colorProfileJSON
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	if len(p.Palette) < 16 || len(p.Palette) > 256 {
+		return fmt.Errorf("expected 16 to 256 colors in palette, got %v", len(p.Palette))
+	}
+	for i, c := range p.Palette {
+		if err := b.setColor(i, c); err != nil {
+			return err
+		}
+	}
+	if err := b.setFg(p.Foreground); err != nil {
+		return err
+	}
+	return b.setBg(p.Background)
+}
+
+// readAlacritty reads colors.primary, colors.normal and colors.bright from an Alacritty config.
+func (b *schemeBuilder) readAlacritty(keys map[string]string) error {
+	for i, name := range ansiColorNames {
+		if v, ok := keys["colors.normal."+name]; ok {
+			if err := b.setColor(i, v); err != nil {
+				return err
+			}
+		}
+		if v, ok := keys["colors.bright."+name]; ok {
+			if err := b.setColor(i+8, v); err != nil {
+				return err
+			}
+		}
+	}
+	if v, ok := keys["colors.primary.foreground"]; ok {
+		if err := b.setFg(v); err != nil {
+			return err
+		}
+	}
+	if v, ok := keys["colors.primary.background"]; ok {
+		if err := b.setBg(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBase16 reads a base16 scheme, in either the original flat format or the newer one with a palette
+// section.
+func (b *schemeBuilder) readBase16(keys map[string]string) error {
+	var base [16]string
+	for k, v := range keys {
+		k = k[strings.LastIndexByte(k, '.')+1:]
+		if len(k) == 6 && strings.HasPrefix(k, "base0") {
+			i, err := strconv.ParseUint(k[5:], 16, 8)
+			if err == nil {
+				base[i] = v
+			}
+		}
+	}
+	for i, bi := range base16Terminal {
+		if base[bi] == "" {
+			return fmt.Errorf("base0%X not found", bi)
+		}
+		if err := b.setColor(i, base[bi]); err != nil {
+			return err
+		}
+	}
+	if err := b.setFg(base[0x5]); err != nil {
+		return err
+	}
+	return b.setBg(base[0x0])
+}
+
+// readITerm reads an .itermcolors property list.
+func (b *schemeBuilder) readITerm(data []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return fmt.Errorf("no dict found")
+		}
+		if err != nil {
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "dict" {
+			root, err := readPlistDict(d)
+			if err != nil {
+				return err
+			}
+			for name, v := range root {
+				entry, ok := v.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				c, err := itermColor(entry)
+				if err != nil {
+					return fmt.Errorf("%v: %v", name, err.Error())
+				}
+				var i int
+				switch {
+				case name == "Foreground Color":
+					b.fg, b.fgSet = c, true
+				case name == "Background Color":
+					b.bg, b.bgSet = c, true
+				case strings.HasPrefix(name, "Ansi "):
+					if _, err := fmt.Sscanf(name, "Ansi %d Color", &i); err == nil && i >= 0 && i < 16 {
+						b.palette[i] = c
+						b.set[i] = true
+					}
+				}
+			}
+			return nil
+		}
+	}
+}
+
+func itermColor(entry map[string]interface{}) (color.RGBA, error) {
+	var c [3]uint8
+	for i, name := range []string{"Red Component", "Green Component", "Blue Component"} {
+		v, ok := entry[name].(float64)
+		if !ok {
+			return color.RGBA{}, fmt.Errorf("%v missing", name)
+		}
+		c[i] = uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+	}
+	return color.RGBA{c[0], c[1], c[2], 255}, nil
+}
+
+// readPlistDict reads the content of a property list <dict>, after its start element. Only dict, real,
+// integer and string values are kept.
+func readPlistDict(d *xml.Decoder) (map[string]interface{}, error) {
+	dict := make(map[string]interface{})
+	var key string
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			return dict, nil
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "dict":
+				v, err := readPlistDict(d)
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = v
+				continue
+			}
+			var text string
+			if err := d.DecodeElement(&text, &t); err != nil {
+				return nil, err
+			}
+			switch t.Name.Local {
+			case "key":
+				key = text
+			case "real", "integer":
+				v, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = v
+			case "string":
+				dict[key] = text
+			}
+		}
+	}
+}
+
+// parseYAMLKeys reads the scalar values of a simple YAML file, as used by color schemes, into a map from
+// dotted paths like colors.primary.background to values. Lists, anchors and multi-line values are not
+// supported.
+func parseYAMLKeys(data []byte) map[string]string {
+	keys := make(map[string]string)
+	type level struct {
+		indent int
+		name   string
+	}
+	var path []level
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		content := strings.TrimSpace(stripComment(line))
+		if content == "" || content == "---" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		colon := strings.Index(content, ":")
+		if colon < 0 {
+			continue
+		}
+		for len(path) > 0 && path[len(path)-1].indent >= indent {
+			path = path[:len(path)-1]
+		}
+		name := strings.Trim(strings.TrimSpace(content[:colon]), `"'`)
+		value := strings.TrimSpace(content[colon+1:])
+		if value == "" {
+			path = append(path, level{indent, name})
+			continue
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			name = path[i].name + "." + name
+		}
+		keys[name] = strings.Trim(value, `"'`)
+	}
+	return keys
+}
+
+// parseTOMLKeys reads the string values of a simple TOML file into a map from dotted paths to values.
+func parseTOMLKeys(data []byte) map[string]string {
+	keys := make(map[string]string)
+	var section string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.Trim(line, "[] ")
+			continue
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			continue
+		}
+		name := strings.Trim(strings.TrimSpace(line[:eq]), `"'`)
+		value := strings.Trim(strings.TrimSpace(line[eq+1:]), `"'`)
+		if section != "" {
+			name = section + "." + name
+		}
+		keys[name] = value
+	}
+	return keys
+}
+
+// stripComment removes a # comment from a line, unless the # is in a quoted string or starts a color.
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			if i+7 <= len(line) && isHexColor(line[i+1:i+7]) {
+				continue
+			}
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func isHexColor(s string) bool {
+	_, err := strconv.ParseUint(s, 16, 32)
+	return err == nil
+}
@@ -0,0 +1,113 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// testSchemeColors returns the 8 normal colors of a test scheme as hex without #, with red as 112233 and
+// the others as 0000<i>0.
+func testSchemeColors() (colors [8]string) {
+	for i := range colors {
+		colors[i] = fmt.Sprintf("0000%v0", i)
+	}
+	colors[1] = "112233"
+	return
+}
+
+func Test_parseColorScheme(t *testing.T) {
+	c := testSchemeColors()
+	var xres, kitty, yaml, toml, base16 strings.Builder
+	xres.WriteString("! comment\n#define red #112233\nURxvt.font: xft:Mono\n*.foreground: rgb:aa/bb/cc\n*background: #010203\n")
+	kitty.WriteString("# theme\nfont_size 12\nforeground #aabbcc\nbackground #010203\n")
+	yaml.WriteString("colors:\n  # Default colors\n  primary:\n    background: '#010203'\n    foreground: '#aabbcc'\n  normal:\n")
+	toml.WriteString("[colors.primary]\nbackground = \"0x010203\"\nforeground = \"0xaabbcc\" # fg\n\n[colors.normal]\n")
+	base16.WriteString("scheme: \"Test\"\nauthor: \"me\"\nbase00: \"010203\"\nbase05: \"aabbcc\"\n")
+	for i, name := range ansiColorNames {
+		if i == 1 {
+			xres.WriteString("*color1: red\n")
+		} else {
+			fmt.Fprintf(&xres, "XTerm*color%v: #%v\n", i, c[i])
+		}
+		fmt.Fprintf(&kitty, "color%v  #%v\n", i, c[i])
+		fmt.Fprintf(&yaml, "    %v: '#%v'\n", name, c[i])
+		fmt.Fprintf(&toml, "%v = \"#%v\"\n", name, c[i])
+	}
+	for i, v := range []string{"03", "08", "0B", "0A", "0D", "0E", "0C", "07"} {
+		fmt.Fprintf(&base16, "base%v: \"%v\"\n", v, c[i])
+	}
+	base16.WriteString("base0F: \"ffffff\"\nbase09: \"ffffff\"\nbase01: \"ffffff\"\nbase02: \"ffffff\"\nbase04: \"ffffff\"\nbase06: \"ffffff\"\n")
+	wt := `{"name": "Test", "foreground": "#AABBCC", "background": "#010203", "black": "#000000", "red": "#112233",
+		"green": "#000020", "yellow": "#000030", "blue": "#000040", "purple": "#000050", "cyan": "#000060",
+		"white": "#000070", "brightRed": "#ff0000"}`
+	iterm := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Ansi 1 Color</key>
+	<dict>
+		<key>Blue Component</key><real>0.2</real>
+		<key>Color Space</key><string>sRGB</string>
+		<key>Green Component</key><real>0.13333333</real>
+		<key>Red Component</key><real>0.06666667</real>
+	</dict>
+	<key>Background Color</key>
+	<dict><key>Red Component</key><real>0.00392157</real><key>Green Component</key><real>0.00784314</real><key>Blue Component</key><integer>0</integer></dict>
+`
+	for _, i := range []int{0, 2, 3, 4, 5, 6, 7} {
+		iterm += fmt.Sprintf("\t<key>Ansi %v Color</key>\n\t<dict><key>Red Component</key><real>0</real><key>Green Component</key><real>0</real><key>Blue Component</key><real>%v</real></dict>\n", i, float64(i*16)/255)
+	}
+	iterm += "</dict>\n</plist>\n"
+	tests := []struct {
+		filename, data string
+		fg, bg         color.RGBA
+	}{
+		{".Xresources", xres.String(), color.RGBA{0xaa, 0xbb, 0xcc, 255}, color.RGBA{1, 2, 3, 255}},
+		{"theme.conf", kitty.String(), color.RGBA{0xaa, 0xbb, 0xcc, 255}, color.RGBA{1, 2, 3, 255}},
+		{"alacritty.yml", yaml.String(), color.RGBA{0xaa, 0xbb, 0xcc, 255}, color.RGBA{1, 2, 3, 255}},
+		{"alacritty.toml", toml.String(), color.RGBA{0xaa, 0xbb, 0xcc, 255}, color.RGBA{1, 2, 3, 255}},
+		{"test.yaml", base16.String(), color.RGBA{0xaa, 0xbb, 0xcc, 255}, color.RGBA{1, 2, 3, 255}},
+		{"scheme.json", wt, color.RGBA{0xaa, 0xbb, 0xcc, 255}, color.RGBA{1, 2, 3, 255}},
+		{"Test.itermcolors", iterm, color.RGBA{0, 0, 0x70, 255}, color.RGBA{1, 2, 0, 255}},
+	}
+	for _, tt := range tests {
+		for _, filename := range []string{tt.filename, "noext"} {
+			cf, err := parseColorScheme(filename, []byte(tt.data))
+			if err != nil {
+				t.Errorf("%v: %v", filename, err)
+				continue
+			}
+			if cf.palette[1] != (color.RGBA{0x11, 0x22, 0x33, 255}) || cf.palette[4] != (color.RGBA{0, 0, 0x40, 255}) {
+				t.Errorf("%v: palette = %v", filename, cf.palette[:16])
+			}
+			if cf.fg != tt.fg || cf.bg != tt.bg {
+				t.Errorf("%v: fg, bg = %v, %v, want %v, %v", filename, cf.fg, cf.bg, tt.fg, tt.bg)
+			}
+			if cf.palette[231] != (color.RGBA{255, 255, 255, 255}) {
+				t.Errorf("%v: color cube not filled", filename)
+			}
+		}
+	}
+}
+
+func Test_parseSchemeColor(t *testing.T) {
+	tests := []struct {
+		in   string
+		want color.RGBA
+	}{
+		{"#a0b0c0", color.RGBA{0xa0, 0xb0, 0xc0, 255}},
+		{"'#fff'", color.RGBA{255, 255, 255, 255}},
+		{"0x102030", color.RGBA{0x10, 0x20, 0x30, 255}},
+		{"rgb:ff/8/ffff", color.RGBA{255, 0x88, 255, 255}},
+	}
+	for _, tt := range tests {
+		if got, err := parseSchemeColor(tt.in); err != nil || got != tt.want {
+			t.Errorf("parseSchemeColor(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -1,11 +1,19 @@
+//go:build !js
+// +build !js
+
 package main
 
 import (
 	"fmt"
 	"github.com/mattn/go-isatty"
+	"image"
 	"image/color"
-	"image/png"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -15,9 +23,33 @@ func doOpGetColorProfile(opt options) {
 		fmt.Fprintf(os.Stderr, "Stdin and/or stdout are not terminals!\n")
 		os.Exit(1)
 	}
+	if opt.colorProfileOutput != "" {
+		doQueryColorProfile(opt)
+		return
+	}
 	doPrintPattern()
 }
 
+// doQueryColorProfile asks the terminal for its colors, and writes them to opt.colorProfileOutput.
+func doQueryColorProfile(opt options) {
+	cf, err := queryColorProfile(0, opt.queryTimeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err.Error())
+		os.Exit(1)
+	}
+	out := os.Stdout
+	if opt.colorProfileOutput != "-" {
+		out, err = os.OpenFile(opt.colorProfileOutput, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			panic(fmt.Errorf("%v when opening %v for writing", err.Error(), opt.colorProfileOutput))
+		}
+		defer out.Close()
+	}
+	if err := writeXresources(out, &cf); err != nil {
+		panic(err)
+	}
+}
+
 const patternWidth = 34
 const patternHeight = 10
 
@@ -62,7 +94,7 @@ func doPrintPattern() {
 	}
 	fmt.Fprintf(outTo, "\033[%d;%dH\033[0;7m ", startY+patternHeight-2, startX+patternWidth)
 	fmt.Fprintf(outTo, "\033[%d;%dH\033[0;27m ", startY+patternHeight-1, startX+patternWidth)
-	const msg1 = "Take a screenshot of the above pattern and save it as a png image."
+	const msg1 = "Take a screenshot of the above pattern and save it as a png or jpeg image."
 	const msg2 = "That image can then be used as a color profile."
 	const msg3 = "You don't have to be precise. Some background border is OK."
 	const msg4 = "Even a screenshot of the entire screen will be fine."
@@ -78,6 +110,26 @@ type colorProfile struct {
 	palette [256]color.RGBA
 }
 
+// xtermColorProfile returns the default xterm colors, used when a recording has indexed colors but no color
+// profile is given.
+func xtermColorProfile() colorProfile {
+	cf, _ := builtinColorProfile("xterm")
+	return cf
+}
+
+// fill256 computes the 6x6x6 color cube and the grayscale ramp (colors 16 to 255) the way xterm does. Most
+// terminals only let the first 16 colors be configured.
+func (cf *colorProfile) fill256() {
+	levels := [6]uint8{0, 95, 135, 175, 215, 255}
+	for i := 0; i < 216; i++ {
+		cf.palette[16+i] = color.RGBA{levels[i/36], levels[(i/6)%6], levels[i%6], 255}
+	}
+	for i := 0; i < 24; i++ {
+		v := uint8(8 + 10*i)
+		cf.palette[232+i] = color.RGBA{v, v, v, 255}
+	}
+}
+
 func abs(i int) int {
 	if i < 0 {
 		return -i
@@ -86,15 +138,113 @@ func abs(i int) int {
 	}
 }
 
+// finderTolerance is how far each channel of a pixel may be from a finder color for it to count as one.
+// Scaling, color management and lossy compression all shift the colors of a screenshot a bit.
+const finderTolerance = 0x60
+
+// maxSwitchGap is the most pixels allowed between a run of finderBg and a run of finderFg for them to count
+// as neighbouring cells. These are the blended pixels at cell edges in a scaled or compressed screenshot.
+const maxSwitchGap = 3
+
+// segmentTolerance is how many pixels the width of each finder segment may differ from their average, as
+// cells of a screenshot scaled by a fractional factor are not all equally wide.
+const segmentTolerance = 1.0
+
+const (
+	sign_fg_to_bg   = -1
+	sign_bg_to_fg   = 1
+	linesign_top    = sign_bg_to_fg // the first switch of the top finder pattern is from bg to fg.
+	linesign_bottom = sign_fg_to_bg
+)
+
+const (
+	pixelOther int8 = iota
+	pixelFinderBg
+	pixelFinderFg
+)
+
+func classifyPixel(c color.Color) int8 {
+	rgba := toRgba(c)
+	near := func(f color.RGBA) bool {
+		return abs(int(rgba.R)-int(f.R)) <= finderTolerance && abs(int(rgba.G)-int(f.G)) <= finderTolerance && abs(int(rgba.B)-int(f.B)) <= finderTolerance
+	}
+	if near(finderBg) {
+		return pixelFinderBg
+	}
+	if near(finderFg) {
+		return pixelFinderFg
+	}
+	return pixelOther
+}
+
+type finderSwitch struct {
+	x    float64
+	sign int8
+}
+
+// rowSwitches finds where row y of img switches between finderBg and finderFg. A switch with blended pixels
+// in between is placed in the middle of them.
+func rowSwitches(img image.Image, y int) []finderSwitch {
+	bd := img.Bounds()
+	var switches []finderSwitch
+	last := pixelOther
+	lastEnd := bd.Min.X
+	for x := bd.Min.X; x < bd.Max.X; x++ {
+		class := classifyPixel(img.At(x, y))
+		if class == pixelOther {
+			continue
+		}
+		if last != pixelOther && class != last && x-lastEnd <= maxSwitchGap {
+			var sign int8 = sign_bg_to_fg
+			if class == pixelFinderBg {
+				sign = sign_fg_to_bg
+			}
+			switches = append(switches, finderSwitch{float64(lastEnd+x) / 2, sign})
+		}
+		last = class
+		lastEnd = x + 1
+	}
+	return switches
+}
+
 type lineInfoStruct struct {
 	sign         int8
 	y            int
-	firstSwitchX int
-	segmentWidth int
+	firstSwitchX float64
+	segmentWidth float64
 }
 
 func (s lineInfoStruct) String() string {
-	return fmt.Sprintf("{sign=%v, y=%v, firstSwitchX=%v, segmentWidth=%v}", s.sign, s.y, s.firstSwitchX, s.segmentWidth)
+	return fmt.Sprintf("{sign=%v, y=%v, firstSwitchX=%.1f, segmentWidth=%.2f}", s.sign, s.y, s.firstSwitchX, s.segmentWidth)
+}
+
+// matches tells whether s and o can be rows of the same pattern.
+func (s lineInfoStruct) matches(o lineInfoStruct) bool {
+	return math.Abs(s.firstSwitchX-o.firstSwitchX) <= segmentTolerance && math.Abs(s.segmentWidth-o.segmentWidth) <= segmentTolerance/2
+}
+
+// findFinderLines finds runs of 33 alternating switches, one for each edge between the 34 cells of a top or
+// bottom finder row, with the segments between them all about equally wide. A row may have more than one
+// such run when the background next to the pattern is close to finderBg.
+func findFinderLines(switches []finderSwitch, y int) []lineInfoStruct {
+	var lines []lineInfoStruct
+	for i := 0; i+32 < len(switches); i++ {
+		width := (switches[i+32].x - switches[i].x) / 32
+		if width < 1 {
+			continue
+		}
+		ok := true
+		for j := i + 1; j <= i+32; j++ {
+			if switches[j].sign == switches[j-1].sign || math.Abs(switches[j].x-switches[j-1].x-width) > segmentTolerance {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			lines = append(lines, lineInfoStruct{sign: switches[i].sign, y: y, firstSwitchX: switches[i].x, segmentWidth: width})
+		}
+	}
+	return lines
 }
 
 type topBottomStruct struct {
@@ -106,129 +256,175 @@ func (tb topBottomStruct) contentHeight() int {
 	return tb.bottom.y - tb.top.y - 1
 }
 
-func processColorProfile(file string) (cf colorProfile, err error) {
-	if !strings.HasSuffix(file, ".png") {
-		err = fmt.Errorf("Only png file is supported")
-		return
+// patternGrid locates the cells of the pattern in a screenshot. Column -1 and 32 are the left and right
+// finder columns, and row 0 is the first row of colors.
+type patternGrid struct {
+	x0, y0                float64
+	cellWidth, cellHeight float64
+}
+
+func (tb topBottomStruct) grid() patternGrid {
+	return patternGrid{
+		x0:         (tb.top.firstSwitchX + tb.bottom.firstSwitchX) / 2,
+		y0:         float64(tb.top.y + 1),
+		cellWidth:  (tb.top.segmentWidth + tb.bottom.segmentWidth) / 2,
+		cellHeight: float64(tb.contentHeight()) / (patternHeight - 2),
+	}
+}
+
+// sampleRect returns the middle half of a cell, away from the blended edges.
+func (g patternGrid) sampleRect(col, row int) image.Rectangle {
+	cx := g.x0 + (float64(col)+0.5)*g.cellWidth
+	cy := g.y0 + (float64(row)+0.5)*g.cellHeight
+	hx, hy := g.cellWidth/4, g.cellHeight/4
+	return image.Rect(int(math.Floor(cx-hx)), int(math.Floor(cy-hy)), int(math.Floor(cx+hx))+1, int(math.Floor(cy+hy))+1)
+}
+
+// sampleColor returns the median of each channel in r, so that noise from lossy compression or a few
+// blended pixels don't change the color.
+func sampleColor(img image.Image, r image.Rectangle) color.RGBA {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return color.RGBA{}
+	}
+	var rs, gs, bs []uint8
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := toRgba(img.At(x, y))
+			rs, gs, bs = append(rs, c.R), append(gs, c.G), append(bs, c.B)
+		}
 	}
+	median := func(v []uint8) uint8 {
+		sort.Slice(v, func(i, j int) bool { return v[i] < v[j] })
+		return v[len(v)/2]
+	}
+	return color.RGBA{median(rs), median(gs), median(bs), 0xff}
+}
+
+var (
+	debugLineColor   = color.RGBA{0xff, 0xff, 0x00, 0xff}
+	debugFinderOK    = color.RGBA{0x00, 0xff, 0x00, 0xff}
+	debugFinderWrong = color.RGBA{0xff, 0x00, 0x00, 0xff}
+	debugSampleColor = color.RGBA{0x00, 0xff, 0xff, 0xff}
+)
+
+// outlineRect draws a box just outside r, leaving the sampled pixels visible.
+func outlineRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	r = r.Inset(-1)
+	for x := r.Min.X; x < r.Max.X; x++ {
+		img.SetRGBA(x, r.Min.Y, c)
+		img.SetRGBA(x, r.Max.Y-1, c)
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		img.SetRGBA(r.Min.X, y, c)
+		img.SetRGBA(r.Max.X-1, y, c)
+	}
+}
+
+// checkFinderColumns tells whether the left and right finder columns of g are where they should be.
+func checkFinderColumns(img image.Image, g patternGrid, debug *image.RGBA) bool {
+	ok := true
+	check := func(col, row int, expect int8) {
+		r := g.sampleRect(col, row)
+		matched := classifyPixel(sampleColor(img, r)) == expect
+		if debug != nil {
+			if matched {
+				outlineRect(debug, r, debugFinderOK)
+			} else {
+				outlineRect(debug, r, debugFinderWrong)
+			}
+		}
+		ok = ok && matched
+	}
+	for row := 0; row < patternHeight-2; row++ {
+		left, right := pixelFinderFg, pixelFinderBg
+		if row%2 == 1 {
+			left, right = right, left
+		}
+		check(-1, row, left)
+		// the last two rows of the right column are the default fg and bg
+		if row < patternHeight-4 {
+			check(patternWidth-2, row, right)
+		}
+	}
+	return ok
+}
+
+func decodeImageFile(file string) (img image.Image, err error) {
 	f, err := os.OpenFile(file, os.O_RDONLY, 0)
 	if err != nil {
 		return
 	}
-	img, err := png.Decode(f)
+	defer f.Close()
+	img, _, err = image.Decode(f)
+	return
+}
+
+// processColorProfile reads a color profile from a PNG or JPEG screenshot of the get-color-profile pattern.
+func processColorProfile(file string) (cf colorProfile, err error) {
+	img, err := decodeImageFile(file)
 	if err != nil {
 		return
 	}
+	return readColorPattern(img, nil)
+}
+
+// readColorPattern finds the get-color-profile pattern in img and reads the colors in it. If debug is not
+// nil, the recognized finder rows and the sampled cells are marked on it.
+func readColorPattern(img image.Image, debug *image.RGBA) (cf colorProfile, err error) {
 	bd := img.Bounds()
-	const (
-		sign_fg_to_bg   = -1
-		sign_bg_to_fg   = 1
-		linesign_top    = sign_bg_to_fg // the first switch of the top finder pattern is from bg to fg.
-		linesign_bottom = sign_fg_to_bg
-	)
-	lineInfos := make([]lineInfoStruct, bd.Max.Y-bd.Min.Y)
-	signs := make([]int8, bd.Max.X-bd.Min.X)
-	switchesAt := make([]int, 0, bd.Max.X-bd.Min.X)
+	var tops, bottoms []lineInfoStruct
+	lineInfos := make([][]lineInfoStruct, bd.Dy())
 	foundAnyLines := false
 	for y := bd.Min.Y; y < bd.Max.Y; y++ {
-		lineInfos[0].sign = 0
-		switchesAt = switchesAt[0:0]
-		for x := bd.Min.X + 1; x < bd.Max.X; x++ {
-			last := img.At(x-1, y)
-			now := img.At(x, y)
-			if colorEq(last, finderBg) && colorEq(now, finderFg) {
-				switchesAt = append(switchesAt, x)
-				signs[x-bd.Min.X] = sign_bg_to_fg
-			} else if colorEq(last, finderFg) && colorEq(now, finderBg) {
-				switchesAt = append(switchesAt, x)
-				signs[x-bd.Min.X] = sign_fg_to_bg
-			} else {
-				signs[x-bd.Min.X] = 0
-			}
-		}
-		if len(switchesAt) < 33 {
-			continue
-		}
-		// 33 switches in one row of finder pattern
-		currentStreak := 1
-		currentWidth := switchesAt[1] - switchesAt[0]
-		found := false
-		var firstI int
-		for i := 1; i < len(switchesAt); i++ {
-			thisWidth := switchesAt[i] - switchesAt[i-1]
-			if thisWidth == currentWidth {
-				currentStreak++
-				if currentStreak == 33 {
-					found = true
-					firstI = i - 32
-					break
+		switches := rowSwitches(img, y)
+		lines := findFinderLines(switches, y)
+		for _, l := range lines {
+			log("Finder pattern line: %v", l)
+			if debug != nil {
+				for _, s := range switches {
+					if s.x >= l.firstSwitchX && s.x <= l.firstSwitchX+32.5*l.segmentWidth {
+						debug.SetRGBA(int(s.x), y, debugLineColor)
+					}
 				}
-			} else {
-				currentStreak = 1
-				currentWidth = thisWidth
-				i = i - 1
 			}
 		}
-		if !found {
-			continue
-		}
-		firstSwitchSign := signs[switchesAt[firstI]-bd.Min.X]
-		lineInfos[y-bd.Min.Y] = lineInfoStruct{
-			sign:         firstSwitchSign,
-			y:            y - bd.Min.Y,
-			firstSwitchX: switchesAt[firstI] - bd.Min.X,
-			segmentWidth: currentWidth,
-		}
-		log("Finder pattern line: %v", lineInfos[y-bd.Min.Y])
-		foundAnyLines = true
+		lineInfos[y-bd.Min.Y] = lines
+		foundAnyLines = foundAnyLines || len(lines) > 0
 	}
 	if !foundAnyLines {
 		err = fmt.Errorf("No pattern recognized")
 		return
 	}
-	tops := make([]lineInfoStruct, 0, len(lineInfos))
-	bottoms := make([]lineInfoStruct, 0, len(lineInfos))
-	var lastIs int8
-	for y := 0; y < len(lineInfos); y++ {
-		current := lineInfos[y]
-		if current.sign == linesign_top {
-			if lastIs == 0 {
-				tops = append(tops, current)
-			} else if lastIs == linesign_top {
-				lastTop := tops[len(tops)-1]
-				if lastTop.segmentWidth == current.segmentWidth && lastTop.firstSwitchX == current.firstSwitchX {
-					tops[len(tops)-1] = current
-				} else {
-					tops = append(tops, current)
-				}
+	// A finder row of the pattern is many pixels tall. Only the line closest to the colors is kept for each.
+	addToBand := func(band []lineInfoStruct, l lineInfoStruct, prevY int) []lineInfoStruct {
+		for i, b := range band {
+			if b.y == prevY && b.matches(l) {
+				band[i] = l
+				return band
 			}
 		}
-		lastIs = current.sign
-	}
-	lastIs = 0
-	for y := len(lineInfos) - 1; y >= 0; y-- {
-		current := lineInfos[y]
-		if current.sign == linesign_bottom {
-			if lastIs == 0 {
-				bottoms = append(bottoms, current)
-			} else if lastIs == linesign_bottom {
-				lastBottom := bottoms[len(bottoms)-1]
-				if lastBottom.segmentWidth == current.segmentWidth && lastBottom.firstSwitchX == current.firstSwitchX {
-					bottoms[len(bottoms)-1] = current
-				} else {
-					bottoms = append(bottoms, current)
-				}
+		return append(band, l)
+	}
+	for i, lines := range lineInfos {
+		for _, l := range lines {
+			if l.sign == linesign_top {
+				tops = addToBand(tops, l, bd.Min.Y+i-1)
+			}
+		}
+	}
+	for i := len(lineInfos) - 1; i >= 0; i-- {
+		for _, l := range lineInfos[i] {
+			if l.sign == linesign_bottom {
+				bottoms = addToBand(bottoms, l, bd.Min.Y+i+1)
 			}
 		}
-		lastIs = current.sign
 	}
 	log("tops=%v, bottoms=%v", tops, bottoms)
 	topBottomPairs := make([]topBottomStruct, 0, len(tops))
-	for currentTopI := 0; currentTopI < len(tops); currentTopI++ {
-		t := tops[currentTopI]
-		for i := 0; i < len(bottoms); i++ {
-			b := bottoms[i]
-			if b.segmentWidth == t.segmentWidth && b.firstSwitchX == t.firstSwitchX {
+	for _, t := range tops {
+		for _, b := range bottoms {
+			if b.y > t.y && b.matches(t) {
 				topBottomPairs = append(topBottomPairs, topBottomStruct{top: t, bottom: b})
 			}
 		}
@@ -245,82 +441,29 @@ func processColorProfile(file string) (cf colorProfile, err error) {
 		if tbPair.contentHeight() < patternHeight-2 {
 			continue
 		}
-		rightMostLeftFinderX := tbPair.top.firstSwitchX - 1
-		leftFinderY := tbPair.top.y + 1
-		if !colorEq(img.At(rightMostLeftFinderX+bd.Min.X, leftFinderY+bd.Min.Y), finderFg) {
+		g := tbPair.grid()
+		if !checkFinderColumns(img, g, debug) {
 			continue
 		}
-		if !colorEq(img.At(rightMostLeftFinderX+bd.Min.X, leftFinderY-1+bd.Min.Y), finderBg) {
-			continue
-		}
-		var segmentHeight int
-		for segmentHeight = 0; segmentHeight < tbPair.contentHeight(); segmentHeight++ {
-			if colorEq(img.At(rightMostLeftFinderX+bd.Min.X, leftFinderY+segmentHeight+bd.Min.Y), finderBg) {
-				break
+		log("Confirmed cell size %.2fx%.2f, starting to read out data...", g.cellWidth, g.cellHeight)
+		sample := func(col, row int) color.RGBA {
+			r := g.sampleRect(col, row)
+			if debug != nil {
+				outlineRect(debug, r, debugSampleColor)
 			}
+			return sampleColor(img, r)
 		}
-		if segmentHeight*(patternHeight-2) != tbPair.contentHeight() {
-			continue
+		for i := range cf.palette {
+			cf.palette[i] = sample(i%(patternWidth-2), i/(patternWidth-2))
 		}
-		wrong := false
-		for yd := 0; yd < tbPair.contentHeight(); yd++ {
-			colExpectFg := (yd/segmentHeight)%2 == 0
-			colExpect := finderFg
-			colExpectInverse := finderBg
-			if !colExpectFg {
-				colExpect = finderBg
-				colExpectInverse = finderFg
-			}
-			// left
-			if !colorEq(img.At(rightMostLeftFinderX+bd.Min.X, leftFinderY+yd+bd.Min.Y), colExpect) {
-				wrong = true
-				break
-			}
-			// right
-			if yd >= 6*segmentHeight {
-				continue
-			}
-			if !colorEq(img.At(rightMostLeftFinderX+1+(tbPair.top.segmentWidth*32)+bd.Min.X, leftFinderY+yd+bd.Min.Y), colExpectInverse) {
-				wrong = true
-				break
-			}
-		}
-		if wrong {
-			continue
-		}
-		log("Confirmed segheight=%v, starting to read out data...", segmentHeight)
-		contentX := tbPair.top.firstSwitchX
-		contentY := leftFinderY
-		segmentWidth := tbPair.top.segmentWidth
-		i := 0
-		for row := 0; row < 8; row++ {
-			for col := 0; col < 32; col++ {
-				x := contentX + col*segmentWidth
-				y := contentY + row*segmentHeight
-				col := img.At(x+segmentWidth/2+bd.Min.X, y+segmentHeight/2+bd.Min.Y)
-				cf.palette[i] = toRgba(col)
-				i++
-			}
-		}
-		fg := img.At(contentX+32*segmentWidth+segmentWidth/2+bd.Min.X, contentY+6*segmentHeight+segmentHeight/2+bd.Min.X)
-		bg := img.At(contentX+32*segmentWidth+segmentWidth/2+bd.Min.X, contentY+7*segmentHeight+segmentHeight/2+bd.Min.X)
-		cf.fg = toRgba(fg)
-		cf.bg = toRgba(bg)
+		cf.fg = sample(patternWidth-2, patternHeight-4)
+		cf.bg = sample(patternWidth-2, patternHeight-3)
 		return
 	}
 	err = fmt.Errorf("No pattern found")
 	return
 }
 
-func colorEq(a, b color.Color) bool {
-	a1, a2, a3, _ := a.RGBA()
-	b1, b2, b3, _ := b.RGBA()
-	c1, c2, c3 := int(a1)-int(b1), int(a2)-int(b2), int(a3)-int(b3)
-	c1, c2, c3 = abs(c1), abs(c2), abs(c3)
-	const thres = 0xffff - 0xfefe
-	return c1 < thres && c2 < thres && c3 < thres
-}
-
 func toRgba(c color.Color) color.RGBA {
 	cRGBA, ok := c.(color.RGBA)
 	if ok {
@@ -346,7 +489,13 @@ func rgbaToHex(col color.RGBA) string {
 }
 
 func doOpCheckColorProfile(opt options) {
-	profile, err := processColorProfile(opt.colorProfileInput)
+	var profile colorProfile
+	var err error
+	if opt.colorProfileDebugImage != "" {
+		profile, err = checkColorProfileImage(opt.colorProfileInput, opt.colorProfileDebugImage)
+	} else {
+		profile, err = loadColorProfile(opt.colorProfileInput)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v.\n", err.Error())
 		os.Exit(1)
@@ -358,3 +507,18 @@ func doOpCheckColorProfile(opt options) {
 	}
 	os.Stdout.WriteString("...\n")
 }
+
+// checkColorProfileImage reads a screenshot like processColorProfile, and writes a copy of it to debugFile
+// with the recognized finder rows marked in yellow, the finder cells checked in green (or red if they don't
+// match), and the cells where colors are sampled in cyan.
+func checkColorProfileImage(file string, debugFile string) (colorProfile, error) {
+	img, err := decodeImageFile(file)
+	if err != nil {
+		return colorProfile{}, err
+	}
+	debug := image.NewRGBA(img.Bounds())
+	draw.Draw(debug, debug.Rect, img, debug.Rect.Min, draw.Src)
+	cf, err := readColorPattern(img, debug)
+	writeImage(debug, debugFile)
+	return cf, err
+}
@@ -0,0 +1,99 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// scaleBilinear resizes img by a fractional factor, blending the pixels at cell edges like a zoomed
+// screenshot would.
+func scaleBilinear(img *image.RGBA, factor float64) *image.RGBA {
+	bd := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, int(float64(bd.Dx())*factor), int(float64(bd.Dy())*factor)))
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			sx, sy := (float64(x)+0.5)/factor-0.5, (float64(y)+0.5)/factor-0.5
+			x0, y0 := int(sx), int(sy)
+			fx, fy := sx-float64(x0), sy-float64(y0)
+			x1, y1 := x0+1, y0+1
+			if x1 >= bd.Dx() {
+				x1 = x0
+			}
+			if y1 >= bd.Dy() {
+				y1 = y0
+			}
+			a, b, c, d := img.RGBAAt(x0, y0), img.RGBAAt(x1, y0), img.RGBAAt(x0, y1), img.RGBAAt(x1, y1)
+			mix := func(a, b, c, d uint8) uint8 {
+				top := float64(a)*(1-fx) + float64(b)*fx
+				bottom := float64(c)*(1-fx) + float64(d)*fx
+				return uint8(top*(1-fy) + bottom*fy + 0.5)
+			}
+			out.SetRGBA(x, y, color.RGBA{mix(a.R, b.R, c.R, d.R), mix(a.G, b.G, c.G, d.G), mix(a.B, b.B, c.B, d.B), 0xff})
+		}
+	}
+	return out
+}
+
+func colorClose(a, b color.RGBA, tolerance int) bool {
+	return abs(int(a.R)-int(b.R)) <= tolerance && abs(int(a.G)-int(b.G)) <= tolerance && abs(int(a.B)-int(b.B)) <= tolerance
+}
+
+func Test_readColorPattern(t *testing.T) {
+	cf := xtermColorProfile()
+	tests := []struct {
+		name      string
+		factor    float64
+		jpeg      bool
+		tolerance int
+	}{
+		{"exact", 1, false, 0},
+		{"hidpi", 2, false, 0},
+		{"fractional zoom", 1.37, false, 8},
+		{"shrunk", 0.8, false, 16},
+		{"jpeg", 1.25, true, 24},
+	}
+	for _, tt := range tests {
+		img := renderColorProfilePattern(&cf)
+		if tt.factor != 1 {
+			img = scaleBilinear(img, tt.factor)
+		}
+		var in image.Image = img
+		if tt.jpeg {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 75}); err != nil {
+				t.Fatal(err)
+			}
+			var err error
+			if in, err = jpeg.Decode(&buf); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := readColorPattern(in, nil)
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		if !colorClose(got.fg, cf.fg, tt.tolerance) || !colorClose(got.bg, cf.bg, tt.tolerance) {
+			t.Errorf("%v: fg %v bg %v, expected %v %v", tt.name, got.fg, got.bg, cf.fg, cf.bg)
+		}
+		for i := range cf.palette {
+			if !colorClose(got.palette[i], cf.palette[i], tt.tolerance) {
+				t.Errorf("%v: color %v is %v, expected %v", tt.name, i, got.palette[i], cf.palette[i])
+				break
+			}
+		}
+	}
+}
+
+func Test_readColorPatternNotFound(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	if _, err := readColorPattern(img, nil); err == nil {
+		t.Errorf("expected an error for an image without the pattern")
+	}
+}
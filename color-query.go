@@ -0,0 +1,114 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// queryColors asks the terminal for its 256 palette colors (OSC 4), foreground (OSC 10) and background
+// (OSC 11). The primary device attributes request (DA1) at the end is answered by almost every terminal, so
+// its reply tells that all the color queries the terminal understands have been answered.
+func queryColors() []byte {
+	var q bytes.Buffer
+	for i := 0; i < 256; i++ {
+		fmt.Fprintf(&q, "\033]4;%d;?\007", i)
+	}
+	q.WriteString("\033]10;?\007\033]11;?\007\033[c")
+	return q.Bytes()
+}
+
+// queryColorProfile sends color queries to the terminal on fd, and makes a color profile from the replies.
+// It waits for at most timeout for the replies.
+func queryColorProfile(fd uintptr, timeout time.Duration) (colorProfile, error) {
+	attr := termSetRawFd(fd)
+	defer termRestoreFd(fd, attr)
+	termSetReadTimeout(fd, 1)
+	if _, err := syscall.Write(int(fd), queryColors()); err != nil {
+		return colorProfile{}, err
+	}
+	deadline := time.Now().Add(timeout)
+	var replies []byte
+	buf := make([]byte, 4096)
+	b := &schemeBuilder{}
+	for time.Now().Before(deadline) {
+		n, err := syscall.Read(int(fd), buf)
+		if err != nil && err != syscall.EINTR && err != syscall.EAGAIN {
+			return colorProfile{}, err
+		}
+		if n <= 0 {
+			continue
+		}
+		replies = append(replies, buf[:n]...)
+		if parseColorReplies(replies, b) {
+			break
+		}
+	}
+	parseColorReplies(replies, b)
+	if !b.set[0] && !b.fgSet && !b.bgSet {
+		return colorProfile{}, fmt.Errorf("The terminal did not answer color queries. Take a screenshot of get-color-profile instead")
+	}
+	return b.profile()
+}
+
+// parseColorReplies reads the replies to queryColors into b, and returns whether the DA1 reply, which comes
+// last, has been received. data may end in the middle of a reply.
+func parseColorReplies(data []byte, b *schemeBuilder) (done bool) {
+	for len(data) > 0 {
+		esc := bytes.IndexByte(data, 0x1b)
+		if esc < 0 || esc+1 >= len(data) {
+			return
+		}
+		data = data[esc+1:]
+		switch data[0] {
+		case '[':
+			// DA1 reply: CSI ? ... c
+			end := bytes.IndexFunc(data[1:], func(r rune) bool { return r >= 0x40 && r <= 0x7e })
+			if end < 0 {
+				return
+			}
+			if data[1+end] == 'c' && len(data) > 1 && data[1] == '?' {
+				done = true
+			}
+			data = data[1+end+1:]
+		case ']':
+			end := bytes.IndexAny(data, "\007\033")
+			if end < 0 {
+				return
+			}
+			b.setOSCColor(string(data[1:end]))
+			data = data[end:]
+		}
+	}
+	return
+}
+
+// setOSCColor sets a color from an OSC 4, 10 or 11 reply like 4;1;rgb:cdcd/0000/0000.
+func (b *schemeBuilder) setOSCColor(reply string) {
+	params := strings.Split(reply, ";")
+	value := params[len(params)-1]
+	if strings.HasPrefix(value, "rgba:") {
+		// some terminals add an alpha component
+		parts := strings.Split(value[len("rgba:"):], "/")
+		if len(parts) != 4 {
+			return
+		}
+		value = "rgb:" + strings.Join(parts[:3], "/")
+	}
+	switch {
+	case params[0] == "4" && len(params) == 3:
+		if i, err := strconv.Atoi(params[1]); err == nil {
+			b.setColor(i, value)
+		}
+	case params[0] == "10" && len(params) == 2:
+		b.setFg(value)
+	case params[0] == "11" && len(params) == 2:
+		b.setBg(value)
+	}
+}
@@ -0,0 +1,129 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/term/termios"
+	"image/color"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var regOSCQuery = regexp.MustCompile(`\x1b\](4;\d+|10|11);\?\x07|\x1b\[c`)
+
+// fakeTerminal answers color queries written to the slave side of a pty with the colors of cf, answering
+// palette queries only up to maxIndex, and DA1 only if answerDA is set.
+func fakeTerminal(master *os.File, cf colorProfile, maxIndex int, answerDA bool) {
+	var pending []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := master.Read(buf)
+		if err != nil {
+			return
+		}
+		pending = append(pending, buf[:n]...)
+		for {
+			loc := regOSCQuery.FindIndex(pending)
+			if loc == nil {
+				break
+			}
+			query := string(pending[loc[0]:loc[1]])
+			pending = pending[loc[1]:]
+			rgb := func(c color.RGBA) string {
+				return fmt.Sprintf("rgb:%02x%02x/%02x%02x/%02x%02x", c.R, c.R, c.G, c.G, c.B, c.B)
+			}
+			var reply string
+			var i int
+			switch {
+			case query == "\x1b[c":
+				if answerDA {
+					reply = "\x1b[?62;22c"
+				}
+			case query == "\x1b]10;?\x07":
+				reply = "\x1b]10;" + rgb(cf.fg) + "\x1b\\"
+			case query == "\x1b]11;?\x07":
+				reply = "\x1b]11;" + rgb(cf.bg) + "\x1b\\"
+			default:
+				fmt.Sscanf(query, "\x1b]4;%d;?\x07", &i)
+				if i <= maxIndex {
+					reply = fmt.Sprintf("\x1b]4;%d;%v\x07", i, rgb(cf.palette[i]))
+				}
+			}
+			master.Write([]byte(reply))
+		}
+	}
+}
+
+func Test_queryColorProfile(t *testing.T) {
+	want, _ := builtinColorProfile("gruvbox-dark")
+	want.palette[200] = color.RGBA{1, 2, 3, 255}
+	for _, tt := range []struct {
+		maxIndex int
+		answerDA bool
+	}{{255, true}, {15, true}, {255, false}} {
+		master, slave, err := termios.Pty()
+		if err != nil {
+			t.Skip(err)
+		}
+		go fakeTerminal(master, want, tt.maxIndex, tt.answerDA)
+		start := time.Now()
+		got, err := queryColorProfile(slave.Fd(), 500*time.Millisecond)
+		elapsed := time.Since(start)
+		master.Close()
+		slave.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := want
+		if tt.maxIndex < 255 {
+			expected.fill256()
+		}
+		if got != expected {
+			t.Errorf("%+v: got fg %v bg %v palette %v", tt, got.fg, got.bg, got.palette[:16])
+		}
+		// the profile is saved as X resources
+		var saved bytes.Buffer
+		writeXresources(&saved, &got)
+		if loaded, err := parseColorScheme("profile.Xresources", saved.Bytes()); err != nil || loaded != got {
+			t.Errorf("%+v: saved profile reads back as %v, %v", tt, loaded.palette[:16], err)
+		}
+		if tt.answerDA && elapsed > 400*time.Millisecond {
+			t.Errorf("%+v: took %v even though DA1 was answered", tt, elapsed)
+		}
+	}
+}
+
+func Test_queryColorProfile_noAnswer(t *testing.T) {
+	master, slave, err := termios.Pty()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer master.Close()
+	defer slave.Close()
+	go fakeTerminal(master, colorProfile{}, -1, true)
+	if _, err := queryColorProfile(slave.Fd(), 200*time.Millisecond); err == nil {
+		t.Errorf("expected an error from a terminal without color queries")
+	}
+}
+
+func Test_parseColorReplies(t *testing.T) {
+	replies := []byte("\x1b]4;1;rgb:ffff/0000/8080\x07\x1b]11;rgba:0101/0202/0303/ffff\x1b\\\x1b[?1;2c")
+	for i := 0; i <= len(replies); i++ {
+		b := &schemeBuilder{}
+		done := parseColorReplies(replies[:i], b)
+		if done != (i == len(replies)) {
+			t.Errorf("done = %v after %q", done, replies[:i])
+		}
+		if i == len(replies) && (b.palette[1] != (color.RGBA{255, 0, 0x80, 255}) || b.bg != (color.RGBA{1, 2, 3, 255})) {
+			t.Errorf("palette[1] = %v, bg = %v", b.palette[1], b.bg)
+		}
+	}
+	if parseColorReplies(bytes.Repeat([]byte("x"), 10), &schemeBuilder{}) {
+		t.Errorf("done without reply")
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"image/color"
+	"io"
+)
+
+// itsReader reads the header, index and frames of an .its file. It only needs an io.ReaderAt and has no
+// cgo dependency, so that the same code can read recordings in the browser.
+type itsReader struct {
+	frameSize   sizeStruct
+	index       *ITSIndex
+	lastFrameId uint64
+	compressed  bool
+	ddict       *zstdDDict
+	file        io.ReaderAt
+	size        int64
+	title       string
+}
+
+type sizeStruct struct {
+	rows, cols int
+}
+
+type frame struct {
+	index    uint64
+	time     float64
+	duration float64
+	data     []byte
+}
+
+const FileMagic = "\x01ITS-PROTO3"
+
+const (
+	cellAttrcodeBold           uint64 = 1
+	cellAttrcodeUnderline      uint64 = 2
+	cellAttrcodeFgIndexedColor uint64 = 1 << (8 * 7)
+	cellAttrcodeBgIndexedColor uint64 = 1 << (8*7 + 1)
+)
+
+// cellAttrs is an unpacked attribute code. For indexed colors, the index is stored in the B component.
+type cellAttrs struct {
+	fg, bg               color.RGBA
+	fgIndexed, bgIndexed bool
+	bold, underline      bool
+}
+
+func unpackAttrCode(code uint64) (a cellAttrs) {
+	a.bold = code&cellAttrcodeBold > 0
+	a.underline = code&cellAttrcodeUnderline > 0
+	a.fgIndexed = code&cellAttrcodeFgIndexedColor > 0
+	a.bgIndexed = code&cellAttrcodeBgIndexedColor > 0
+	code >>= 8
+	a.bg.B = uint8(code % 256)
+	code >>= 8
+	a.bg.G = uint8(code % 256)
+	code >>= 8
+	a.bg.R = uint8(code % 256)
+	code >>= 8
+	a.fg.B = uint8(code % 256)
+	code >>= 8
+	a.fg.G = uint8(code % 256)
+	code >>= 8
+	a.fg.R = uint8(code % 256)
+	a.fg.A = 255
+	a.bg.A = 255
+	return
+}
+
+// openITS reads the header and index of a recording of the given size.
+func openITS(r io.ReaderAt, size int64) (*itsReader, error) {
+	magicBuffer := make([]byte, len(FileMagic))
+	n, err := r.ReadAt(magicBuffer, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n != len(FileMagic) || bytes.Compare(magicBuffer, []byte(FileMagic)) != 0 {
+		return nil, errors.New("Not a its file: magic wrong.")
+	}
+	offset := int64(len(FileMagic))
+	var headerLenBuf [4]byte
+	_, err = r.ReadAt(headerLenBuf[:], offset)
+	if err != nil {
+		return nil, err
+	}
+	offset += 4
+	headerLen := binary.BigEndian.Uint32(headerLenBuf[:])
+	if headerLen > 10000 || headerLen < 1 {
+		return nil, errors.New("Invalid headerLen")
+	}
+	headerBuff := make([]byte, headerLen)
+	n, err = r.ReadAt(headerBuff, offset)
+	if uint32(n) < headerLen {
+		return nil, errors.New("Permature EOF")
+	}
+	header := &ITSHeader{}
+	err = proto.Unmarshal(headerBuff, header)
+	if err != nil {
+		return nil, err
+	}
+	if header.GetVersion() != 1 {
+		return nil, errors.New("Invalid file version. Please update this player.")
+	}
+
+	d := &itsReader{}
+	d.frameSize.rows = int(header.GetRows())
+	d.frameSize.cols = int(header.GetCols())
+	d.title = header.GetTitle()
+	if d.frameSize.rows*d.frameSize.cols <= 0 {
+		return nil, errors.New("Invalid dimension")
+	}
+	switch header.GetCompressionMode() {
+	case ITSHeader_COMPRESSION_ZSTD:
+		d.compressed = true
+		compressedDictBuf := header.GetCompressionDict()
+		if len(compressedDictBuf) > 0 {
+			dictBuf, err := zstdDecompress(nil, compressedDictBuf)
+			if err != nil {
+				return nil, err
+			}
+			d.ddict, err = zstdNewDDict(dictBuf)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			d.ddict = nil
+		}
+	case ITSHeader_COMPRESSION_NONE:
+		d.compressed = false
+	default:
+		return nil, errors.New("Unknown compression mode")
+	}
+	d.index, err = readIndex(r, size, header.GetIndexOffset(), d.compressed)
+	if err != nil {
+		return nil, err
+	}
+	if d.index.GetCount() <= 0 || len(d.index.GetFrames()) <= 0 {
+		return nil, errors.New("Empty index")
+	}
+	if d.index.GetCount() != uint64(len(d.index.GetFrames())) {
+		return nil, errors.New("Wrong index count")
+	}
+	d.lastFrameId = d.index.GetCount() - 1
+	d.file = r
+	d.size = size
+	return d, nil
+}
+
+// readIndex reads the index stored at indexOffset, which is compressed (without dict) if compressed is true.
+func readIndex(r io.ReaderAt, size int64, indexOffset uint64, compressed bool) (*ITSIndex, error) {
+	if indexOffset <= 12 {
+		return nil, errors.New("Invalid indexOffset")
+	}
+	index := &ITSIndex{}
+	err := readSection(r, size, indexOffset, compressed, index)
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// readSection reads a message stored after its length at offset, like the index.
+func readSection(r io.ReaderAt, size int64, offset uint64, compressed bool, msg proto.Message) error {
+	var lenBuf [8]byte
+	_, err := r.ReadAt(lenBuf[:], int64(offset))
+	if err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint64(lenBuf[:])
+	if offset+length > uint64(size)+10000 {
+		return errors.New("Invalid section length")
+	}
+	buf := make([]byte, length)
+	n, err := r.ReadAt(buf, int64(offset)+8)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if uint64(n) < length {
+		return errors.New("Permature EOF")
+	}
+	if compressed {
+		buf, err = zstdDecompress(nil, buf)
+		if err != nil {
+			return err
+		}
+	}
+	return proto.Unmarshal(buf, msg)
+}
+
+func (d *itsReader) searchForFrame(time float64) (frameId uint64, indexEntry *ITSIndex_FrameIndex) {
+	frames := d.index.GetFrames()
+	if frames[0].GetTimeOffset()+0.0001 >= time {
+		return 0, frames[0]
+	}
+	if frames[len(frames)-1].GetTimeOffset()-0.0001 < time {
+		return uint64(len(frames) - 1), frames[len(frames)-1]
+	}
+	var i, j int
+	j = len(frames)
+	for {
+		if i >= j || i >= len(frames) {
+			if j < 0 {
+				j = 0
+			}
+			if j >= len(frames) {
+				j = len(frames) - 1
+			}
+			if j < i {
+				return uint64(j), frames[j]
+			} else {
+				return uint64(i), frames[i]
+			}
+		}
+		mid := (i + j) / 2
+		foundTime := frames[mid].GetTimeOffset()
+		foundNextTime := foundTime
+		if mid < len(frames)-1 {
+			foundNextTime = frames[mid+1].GetTimeOffset()
+		}
+		if foundTime-0.0001 <= time && time < foundNextTime {
+			return uint64(mid), frames[mid]
+		}
+		if foundTime < time {
+			i = mid + 1
+		} else {
+			j = mid - 1
+		}
+	}
+}
+
+func (d *itsReader) frameIdLookup(frameId uint64) (byteOffset uint64, timeOffset float64) {
+	indexEntry := d.index.GetFrames()[frameId]
+	return indexEntry.GetByteOffset(), indexEntry.GetTimeOffset()
+}
+
+func (d *itsReader) readFrameBytesFromOffset(byteOffset uint64) (buf []byte, nextOffset uint64, err error) {
+	var lenBuf [4]byte
+	_, err = d.file.ReadAt(lenBuf[:], int64(byteOffset))
+	if err != nil {
+		return
+	}
+	frameByteLen := binary.BigEndian.Uint32(lenBuf[:])
+	if frameByteLen > 1024*1024*50 {
+		// gaurd against DNS, arbitrary value.
+		err = fmt.Errorf("invalid frame byteLength near %x", byteOffset)
+		return
+	}
+	buf = make([]byte, frameByteLen)
+	n, err := d.file.ReadAt(buf, int64(byteOffset)+4)
+	if uint32(n) == frameByteLen {
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	nextOffset = byteOffset + 4 + uint64(frameByteLen)
+	if d.compressed {
+		if d.ddict != nil {
+			buf, err = zstdDecompressDict(nil, buf, d.ddict)
+			if err != nil {
+				return
+			}
+		} else {
+			buf, err = zstdDecompress(nil, buf)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (d *itsReader) readFrameStructFromOffset(byteOffset uint64) (frameStruct *ITSFrame, nextOffset uint64, err error) {
+	var buf []byte
+	buf, nextOffset, err = d.readFrameBytesFromOffset(byteOffset)
+	if err != nil {
+		return
+	}
+	frameStruct = &ITSFrame{}
+	err = proto.Unmarshal(buf, frameStruct)
+	if err != nil {
+		return
+	}
+	return
+}
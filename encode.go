@@ -1,3 +1,6 @@
+//go:build !js
+// +build !js
+
 package main
 
 import (
@@ -16,13 +19,20 @@ import (
 	"strings"
 )
 
+// The default foreground and background of recordings made without a color profile, which are stored as RGB
+// unlike the other colors.
+var (
+	noProfileDefaultFg = color.RGBA{0, 0, 0, 255}
+	noProfileDefaultBg = color.RGBA{255, 255, 255, 255}
+)
+
 func doOpEncode(opt options) {
-	fScript, err := os.OpenFile(opt.script, os.O_RDONLY, 0)
+	fScript, err := openEncodeInput(opt.script)
 	if err != nil {
 		panic(fmt.Errorf("%v when opening %v", err.Error(), opt.script))
 	}
 	defer fScript.Close()
-	fTiming, err := os.OpenFile(opt.timing, os.O_RDONLY, 0)
+	fTiming, err := openEncodeInput(opt.timing)
 	if err != nil {
 		panic(fmt.Errorf("%v when opening %v", err.Error(), opt.timing))
 	}
@@ -44,9 +54,10 @@ func doOpEncode(opt options) {
 	e.t = vt
 	e.size.rows = opt.bufferSize.rows
 	e.size.cols = opt.bufferSize.cols
+	e.title = opt.title
 
 	if opt.colorProfileInput != "" {
-		cf, err := processColorProfile(opt.colorProfileInput)
+		cf, err := loadColorProfile(opt.colorProfileInput)
 		if err != nil {
 			panic(err)
 		} else {
@@ -54,6 +65,11 @@ func doOpEncode(opt options) {
 		}
 	}
 
+	if opt.stream || !isSeekable(fScript) || !isSeekable(fTiming) {
+		doStreamEncode(opt, e, bTiming, fScript, fOut)
+		return
+	}
+
 	os.Stderr.WriteString("Determining total time and frame number...\n")
 	fTiming.Seek(0, os.SEEK_SET)
 	bTiming.Reset(fTiming)
@@ -101,11 +117,14 @@ func doOpEncode(opt options) {
 	fScript.Seek(0, os.SEEK_SET)
 	e.resetVT()
 	e.initOutputFile(fOut)
+	p := e.newFramePipeline(opt.jobs)
 	tsEncodeFramesPass(float64(opt.fps), bTiming, fScript, func(f *frame, bytesRead uint64) {
 		fContent := e.inputToFrameContent(f.data)
-		e.writeFrame(f, fContent)
+		e.markers.scan(f.data, f.time)
+		p.writeFrame(f, fContent)
 		fmt.Fprintf(os.Stderr, "\r\033[1A\033[2KEncoding frame %v of %v, t=%vs of %vs read=%v of %v\n", f.index, totalFrames, math.Round((f.time+f.duration)*10)/10, totalDuration, humanize.Bytes(bytesRead), totalBytesRead)
 	})
+	p.close()
 
 	stat, err := fScript.Stat()
 	if err == nil {
@@ -115,6 +134,110 @@ func doOpEncode(opt options) {
 	fOut.Close()
 }
 
+// openEncodeInput opens a script or timing file for reading, with "-" meaning stdin.
+func openEncodeInput(file string) (*os.File, error) {
+	if file == "-" {
+		return os.Stdin, nil
+	}
+	return os.OpenFile(file, os.O_RDONLY, 0)
+}
+
+func isSeekable(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode().IsRegular()
+}
+
+// doStreamEncode encodes in a single pass, so that the input can be a pipe. Instead of sampling the whole
+// recording, the compression dict is trained from the first opt.dictFrames frames, which are held back
+// until the dict is built. With opt.dictFrames = 0 frames are written without dict, which can be fixed
+// later with optimize.
+func doStreamEncode(opt options, e *encoderState, bTiming *bufio.Reader, fScript *os.File, fOut *os.File) {
+	os.Stderr.WriteString("Encoding in a single pass...\n")
+	var totalBytes uint64
+	if isSeekable(fScript) {
+		stat, err := fScript.Stat()
+		if err == nil {
+			totalBytes = uint64(stat.Size())
+		}
+	}
+	progress := func(bytesRead uint64) string {
+		if totalBytes == 0 {
+			return fmt.Sprintf("read=%v", humanize.Bytes(bytesRead))
+		}
+		return fmt.Sprintf("read=%v of %v (%v%%)", humanize.Bytes(bytesRead), humanize.Bytes(totalBytes), math.Floor(float64(bytesRead)/float64(totalBytes)*1000)/10)
+	}
+
+	type heldFrame struct {
+		info frame
+		buf  []byte
+		text []string
+	}
+	held := make([]heldFrame, 0, opt.dictFrames)
+	var p *framePipeline
+	writeHeld := func() {
+		if len(held) > 0 {
+			dictSamples := make([][]byte, 0, len(held))
+			for _, h := range held {
+				dictSamples = append(dictSamples, h.buf)
+			}
+			os.Stderr.WriteString("\r\033[1A\033[2KBuilding compression dict...\n")
+			e.dict = gozstd.BuildDict(dictSamples, len(dictSamples)*20)
+			var err error
+			e.cdict, err = gozstd.NewCDict(e.dict)
+			if err != nil {
+				panic(err)
+			}
+		}
+		e.initOutputFile(fOut)
+		p = e.newFramePipeline(opt.jobs)
+		for i := range held {
+			p.writeFrameBytes(&held[i].info, held[i].buf, held[i].text)
+		}
+		held = nil
+	}
+	dictDone := opt.dictFrames <= 0
+	if dictDone {
+		e.initOutputFile(fOut)
+		p = e.newFramePipeline(opt.jobs)
+	}
+
+	e.resetVT()
+	var lastBytesRead uint64
+	tsEncodeFramesPass(float64(opt.fps), bTiming, fScript, func(f *frame, bytesRead uint64) {
+		lastBytesRead = bytesRead
+		fContent := e.inputToFrameContent(f.data)
+		e.markers.scan(f.data, f.time)
+		if !dictDone {
+			buf, err := proto.Marshal(e.getFrameStruct(f, fContent))
+			if err != nil {
+				panic(err)
+			}
+			info := *f
+			info.data = nil
+			held = append(held, heldFrame{info, buf, frameText(fContent, &e.size)})
+			if len(held) >= opt.dictFrames {
+				writeHeld()
+				dictDone = true
+			}
+			fmt.Fprintf(os.Stderr, "\r\033[1A\033[2KCollecting frames for compression dict (%v of %v), t=%vs %v\n", len(held), opt.dictFrames, math.Round((f.time+f.duration)*10)/10, progress(bytesRead))
+			return
+		}
+		p.writeFrame(f, fContent)
+		fmt.Fprintf(os.Stderr, "\r\033[1A\033[2KEncoding frame %v, t=%vs %v\n", f.index, math.Round((f.time+f.duration)*10)/10, progress(bytesRead))
+	})
+	if !dictDone {
+		// Input ended before enough frames were collected.
+		writeHeld()
+	}
+	p.close()
+	fmt.Fprintf(os.Stderr, "\r\033[1A\033[2KFinalizing... read=%v\n", humanize.Bytes(lastBytesRead))
+	e.finalize()
+	fOut.Close()
+}
+
 func (e *encoderState) resetVT() {
 	e.t.SetUTF8(true)
 	vtScr := e.t.ObtainScreen()
@@ -123,7 +246,7 @@ func (e *encoderState) resetVT() {
 	tState := e.t.ObtainState()
 	cp := e.translateColor
 	if cp == nil {
-		tState.SetDefaultColors(vterm.NewVTermColorRGB(color.RGBA{0, 0, 0, 255}), vterm.NewVTermColorRGB(color.RGBA{255, 255, 255, 255}))
+		tState.SetDefaultColors(vterm.NewVTermColorRGB(noProfileDefaultFg), vterm.NewVTermColorRGB(noProfileDefaultBg))
 	} else {
 		tState.SetDefaultColors(vterm.NewVTermColorRGB(cp.fg), vterm.NewVTermColorRGB(cp.bg))
 	}
@@ -137,10 +260,11 @@ func (e *encoderState) resetVT() {
 
 type frameCallback func(f *frame, bytesRead uint64)
 
-func tsEncodeFramesPass(fps float64, bTiming *bufio.Reader, fScript *os.File, cb frameCallback) {
-	// figure out the byte offset of the first \n. The first line of the script file is to be ignored.
-	scriptLineProb := bufio.NewReaderSize(fScript, 10000)
-	firstLine, err := scriptLineProb.ReadBytes('\n')
+func tsEncodeFramesPass(fps float64, bTiming *bufio.Reader, fScript io.Reader, cb frameCallback) {
+	// The first line of the script file is to be ignored. The script is read strictly sequentially so that
+	// it can be a pipe.
+	bScript := bufio.NewReaderSize(fScript, 1000000)
+	firstLine, err := bScript.ReadBytes('\n')
 	if err != nil && err != io.EOF {
 		panic(err)
 	}
@@ -172,7 +296,10 @@ func tsEncodeFramesPass(fps float64, bTiming *bufio.Reader, fScript *os.File, cb
 		flen += uint64(step)
 		if fsec >= spf {
 			buf := make([]byte, flen)
-			n, err := fScript.ReadAt(buf, int64(offset+startFromByteOffset))
+			n, err := io.ReadFull(bScript, buf)
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
 			if err != nil && err != io.EOF {
 				panic(err)
 			}
@@ -190,10 +317,6 @@ func tsEncodeFramesPass(fps float64, bTiming *bufio.Reader, fScript *os.File, cb
 	}
 }
 
-type sizeStruct struct {
-	rows, cols int
-}
-
 type encoderState struct {
 	t                    *vterm.VTerm
 	perviousFrameContent frameContent
@@ -202,6 +325,9 @@ type encoderState struct {
 	dict                 []byte
 	cdict                *gozstd.CDict
 	translateColor       *colorProfile
+	title                string
+	markers              markerScanner
+	text                 textIndexer
 
 	fileHeader       *ITSHeader
 	headerOffset     uint64
@@ -211,14 +337,6 @@ type encoderState struct {
 	index            *ITSIndex
 }
 
-const FileMagic = "\x01ITS-PROTO3"
-
-type frame struct {
-	index    uint64
-	time     float64
-	duration float64
-	data     []byte
-}
 type frameContent []frameCell
 type frameCell struct {
 	chars []rune
@@ -230,13 +348,6 @@ type frameCell struct {
 	}
 }
 
-const (
-	cellAttrcodeBold           uint64 = 1
-	cellAttrcodeUnderline      uint64 = 2
-	cellAttrcodeFgIndexedColor uint64 = 1 << (8 * 7)
-	cellAttrcodeBgIndexedColor uint64 = 1 << (8*7 + 1)
-)
-
 func (c *frameCell) styleFromAttrs(attrs *vterm.Attrs, bg, fg vterm.VTermColor) {
 	if attrs.Blink > 0 || attrs.Bold > 0 || attrs.Italic > 0 {
 		c.style.bold = true
@@ -304,49 +415,25 @@ func (c *frameCell) attrCode(translateColor *colorProfile) uint64 {
 }
 
 func (c *frameCell) fromAttrCode(code uint64, translateColor *colorProfile) {
-	if code&cellAttrcodeBold > 0 {
-		c.style.bold = true
-	}
-	if code&cellAttrcodeUnderline > 0 {
-		c.style.underline = true
-	}
-	var fgIndexed, bgIndexed bool
-	if code&cellAttrcodeFgIndexedColor > 0 {
-		fgIndexed = true
-	}
-	if code&cellAttrcodeBgIndexedColor > 0 {
-		bgIndexed = true
-	}
-	code >>= 8
-	bB := uint8(code % 256)
-	bIndex := bB
-	code >>= 8
-	bG := uint8(code % 256)
-	code >>= 8
-	bR := uint8(code % 256)
-	code >>= 8
-	fB := uint8(code % 256)
-	fIndex := fB
-	code >>= 8
-	fG := uint8(code % 256)
-	code >>= 8
-	fR := uint8(code % 256)
-	if !fgIndexed {
-		c.style.fg = vterm.NewVTermColorRGB(color.RGBA{R: fR, G: fG, B: fB, A: 255})
+	a := unpackAttrCode(code)
+	c.style.bold = a.bold
+	c.style.underline = a.underline
+	if !a.fgIndexed {
+		c.style.fg = vterm.NewVTermColorRGB(a.fg)
 	} else {
 		if translateColor != nil {
-			c.style.fg = vterm.NewVTermColorRGB(translateColor.palette[fIndex])
+			c.style.fg = vterm.NewVTermColorRGB(translateColor.palette[a.fg.B])
 		} else {
-			c.style.fg = vterm.NewVTermColorIndexed(fIndex)
+			c.style.fg = vterm.NewVTermColorIndexed(a.fg.B)
 		}
 	}
-	if !bgIndexed {
-		c.style.bg = vterm.NewVTermColorRGB(color.RGBA{R: bR, G: bG, B: bB, A: 255})
+	if !a.bgIndexed {
+		c.style.bg = vterm.NewVTermColorRGB(a.bg)
 	} else {
 		if translateColor != nil {
-			c.style.bg = vterm.NewVTermColorRGB(translateColor.palette[bIndex])
+			c.style.bg = vterm.NewVTermColorRGB(translateColor.palette[a.bg.B])
 		} else {
-			c.style.bg = vterm.NewVTermColorIndexed(bIndex)
+			c.style.bg = vterm.NewVTermColorIndexed(a.bg.B)
 		}
 	}
 }
@@ -405,6 +492,17 @@ func (f *frameContent) setCellAt(row, col int, cell frameCell, size *sizeStruct)
 	[]frameCell(*f)[index] = cell
 }
 
+// blankStyleOf returns the style of empty cells, assumed to be that of the bottom-right cell, which is almost
+// never written to.
+func blankStyleOf(content frameContent, size *sizeStruct) uint64 {
+	return content.getCellAt(size.rows-1, size.cols-1, size).attrCode(nil)
+}
+
+// isBlankCell tells whether cell only contains spaces and has blankStyle, i.e. looks like an empty cell.
+func isBlankCell(cell *frameCell, blankStyle uint64) bool {
+	return strings.TrimSpace(string(cell.chars)) == "" && cell.attrCode(nil) == blankStyle
+}
+
 func (e *encoderState) inputToFrameContent(input []byte) frameContent {
 	return e.inputToFrameContentSize(input, e.size)
 }
@@ -466,6 +564,7 @@ func (e *encoderState) initOutputFile(fOutput *os.File) {
 	e.fileHeader.Rows = uint32(e.size.rows)
 	e.fileHeader.Cols = uint32(e.size.cols)
 	e.fileHeader.CompressionMode = ITSHeader_COMPRESSION_ZSTD
+	e.fileHeader.Title = e.title
 	if e.dict != nil {
 		compressedDict := gozstd.Compress(nil, e.dict)
 		e.fileHeader.CompressionDict = compressedDict
@@ -526,23 +625,39 @@ func (e *encoderState) getFrameStruct(fi *frame, ct frameContent) *ITSFrame {
 }
 
 func (e *encoderState) writeFrame(frameInfo *frame, currentFrameContent frameContent) {
-	e.index.Count++
-	indexFrame := &ITSIndex_FrameIndex{}
-	indexFrame.TimeOffset = frameInfo.time
-	indexFrame.ByteOffset = e.offset
-	e.index.Frames = append(e.index.Frames, indexFrame)
+	e.writeCompressedFrame(frameInfo, e.compressFrame(frameInfo, currentFrameContent), frameText(currentFrameContent, &e.size))
+}
+
+// writeFrameBytes compresses and writes an already marshaled ITSFrame, whose frameText is text.
+func (e *encoderState) writeFrameBytes(frameInfo *frame, buf []byte, text []string) {
+	e.writeCompressedFrame(frameInfo, e.compressFrameBytes(buf), text)
+}
 
+// compressFrame does not touch any mutable encoder state, and so can be called concurrently.
+func (e *encoderState) compressFrame(frameInfo *frame, currentFrameContent frameContent) []byte {
 	frameStruct := e.getFrameStruct(frameInfo, currentFrameContent)
 	buf, err := proto.Marshal(frameStruct)
 	if err != nil {
 		panic(err)
 	}
-	var compressedBuf []byte
+	return e.compressFrameBytes(buf)
+}
+
+func (e *encoderState) compressFrameBytes(buf []byte) []byte {
 	if e.cdict != nil {
-		compressedBuf = gozstd.CompressDict(nil, buf, e.cdict)
+		return gozstd.CompressDict(nil, buf, e.cdict)
 	} else {
-		compressedBuf = gozstd.Compress(nil, buf)
+		return gozstd.Compress(nil, buf)
 	}
+}
+
+func (e *encoderState) writeCompressedFrame(frameInfo *frame, compressedBuf []byte, text []string) {
+	e.text.add(e.index.Count, text)
+	e.index.Count++
+	indexFrame := &ITSIndex_FrameIndex{}
+	indexFrame.TimeOffset = frameInfo.time
+	indexFrame.ByteOffset = e.offset
+	e.index.Frames = append(e.index.Frames, indexFrame)
 
 	length := uint32(len(compressedBuf))
 	binary.Write(e.fOutput, binary.BigEndian, length)
@@ -552,6 +667,11 @@ func (e *encoderState) writeFrame(frameInfo *frame, currentFrameContent frameCon
 }
 
 func (e *encoderState) finalize() {
+	if len(e.text.runs) > 0 {
+		e.index.TextIndexOffset = e.offset
+		e.fOutput.Seek(int64(e.offset), os.SEEK_SET)
+		e.offset += e.writeSection(&ITSTextIndex{Lines: e.text.lines, Runs: e.text.runs})
+	}
 	indexOffset := e.offset
 
 	e.fileHeader.IndexOffset = indexOffset
@@ -568,12 +688,19 @@ func (e *encoderState) finalize() {
 	e.fOutput.Write(headerBuf)
 
 	e.fOutput.Seek(int64(indexOffset), os.SEEK_SET)
-	indexBuf, err := proto.Marshal(e.index)
+	e.index.Markers = e.markers.markers
+	e.writeSection(e.index)
+	e.fOutput.Close()
+}
+
+// writeSection writes msg compressed (without dict) after its length, and returns the number of bytes written.
+func (e *encoderState) writeSection(msg proto.Message) uint64 {
+	buf, err := proto.Marshal(msg)
 	if err != nil {
 		panic(err)
 	}
-	compressedIndex := gozstd.Compress(nil, indexBuf)
-	binary.Write(e.fOutput, binary.BigEndian, uint64(len(compressedIndex)))
-	e.fOutput.Write(compressedIndex)
-	e.fOutput.Close()
+	compressed := gozstd.Compress(nil, buf)
+	binary.Write(e.fOutput, binary.BigEndian, uint64(len(compressed)))
+	e.fOutput.Write(compressed)
+	return 8 + uint64(len(compressed))
 }
@@ -1,10 +1,19 @@
+//go:build !js
+// +build !js
+
 package main
 
 import (
+	"bufio"
+	"fmt"
 	"github.com/micromaomao/go-libvterm"
 	"image/color"
+	"io/ioutil"
+	"math"
 	"math/rand"
+	"os"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -36,7 +45,7 @@ func doAttrCodeTest(fs frameCell, t *testing.T) {
 	code := fs.attrCode(nil)
 	t.Run(strconv.FormatUint(code, 16), func(t *testing.T) {
 		nfs := frameCell{}
-		nfs.fromAttrCode(code)
+		nfs.fromAttrCode(code, nil)
 		if nfs.style != fs.style {
 			t.Errorf("Expected %v, got %v", fs, nfs)
 		}
@@ -78,3 +87,89 @@ func uint32ToColor(i uint32) color.RGBA {
 	r := uint8(i % 256)
 	return color.RGBA{R: r, G: g, B: b, A: 255}
 }
+
+// writeTestScript writes chunks as a script(1) recording, one chunk every 0.1s, and returns the script and
+// timing file names.
+func writeTestScript(t testing.TB, chunks []string) (string, string) {
+	var script, timing strings.Builder
+	script.WriteString("Script started\n")
+	for _, c := range chunks {
+		script.WriteString(c)
+		fmt.Fprintf(&timing, "0.1 %v\n", len(c))
+	}
+	var names []string
+	for _, content := range []string{script.String(), timing.String()} {
+		f, err := ioutil.TempFile("", "ts-player-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(content)
+		f.Close()
+		names = append(names, f.Name())
+	}
+	return names[0], names[1]
+}
+
+func Test_doStreamEncode(t *testing.T) {
+	chunks := []string{"$ ", "ls\r\n", "a.txt\r\n$ ", "\033]1337;SetMark\007", "echo hi\r\n", "hi\r\n$ ", "exit\r\n"}
+	scriptFile, timingFile := writeTestScript(t, chunks)
+	defer os.Remove(scriptFile)
+	defer os.Remove(timingFile)
+	size := sizeStruct{rows: 4, cols: 20}
+
+	// what each frame should look like, from a terminal of its own
+	ref := &encoderState{t: vterm.New(size.rows, size.cols), size: size}
+	defer ref.t.Close()
+	ref.resetVT()
+	fTiming, _ := os.Open(timingFile)
+	defer fTiming.Close()
+	fScript, _ := os.Open(scriptFile)
+	defer fScript.Close()
+	var want []frameContent
+	tsEncodeFramesPass(10, bufio.NewReader(fTiming), fScript, func(f *frame, bytesRead uint64) {
+		want = append(want, ref.inputToFrameContent(f.data))
+	})
+	if len(want) != len(chunks) {
+		t.Fatalf("expected %v frames from the script, got %v", len(chunks), len(want))
+	}
+
+	// without dict, with the dict built in the middle of the recording, and with the input ending before
+	for _, dictFrames := range []int{0, 3, 100} {
+		t.Run(strconv.Itoa(dictFrames), func(t *testing.T) {
+			out := scriptFile + ".its"
+			defer os.Remove(out)
+			doOpEncode(options{script: scriptFile, timing: timingFile, itsOutput: out, bufferSize: size,
+				fps: 10, stream: true, dictFrames: dictFrames, jobs: 2})
+
+			d := initPlayer(options{itsInput: out})
+			if d.index.GetCount() != uint64(len(want)) {
+				t.Fatalf("Expected %v frames, got %v", len(want), d.index.GetCount())
+			}
+			if hasDict := d.ddict != nil; hasDict != (dictFrames > 0) {
+				t.Errorf("file has a dict: %v", hasDict)
+			}
+			for i := uint64(0); i < d.index.GetCount(); i++ {
+				byteOffset, _ := d.frameIdLookup(i)
+				finfo, content, err, _ := d.readFrameFromOffset(byteOffset)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if finfo.index != i || math.Abs(finfo.time-float64(i)/10) > 1e-9 {
+					t.Errorf("Frame %v: got frameId %v at t=%v", i, finfo.index, finfo.time)
+				}
+				for j := range content {
+					if !content[j].equalsTo(&want[i][j]) {
+						t.Errorf("Frame %v: content differs at cell %v", i, j)
+						break
+					}
+				}
+			}
+			if markers := d.index.GetMarkers(); len(markers) != 1 || math.Abs(markers[0].GetTimeOffset()-0.3) > 1e-9 {
+				t.Errorf("markers = %v", markers)
+			}
+			if d.index.GetTextIndexOffset() == 0 {
+				t.Errorf("no text index written")
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// fontSet holds the primary font faces and the fonts consulted, in order, for runes the primary font
+// doesn't have. Cell metrics always come from the primary medium face.
+//
+// Faces are not safe for concurrent use, and neither is faceFor, so callers must serialize access.
+type fontSet struct {
+	medium, bold         font.Face
+	mediumFont, boldFont *truetype.Font
+	fallbacks            []*fallbackFont
+	size, dpi            float64
+
+	// Fonts found by asking fc-match for one covering a rune, after fallbacks is exhausted.
+	systemFallback bool
+	byRune         map[rune]*fallbackFont
+	byFile         map[string]*fallbackFont
+}
+
+type fallbackFont struct {
+	font *truetype.Font
+	face font.Face
+}
+
+// findFont returns the file of the best match for a fontconfig pattern.
+func findFont(pattern string) (string, error) {
+	var proc = exec.Command("fc-match", "-f", "%{file}\n", pattern)
+	var outBuffer = bytes.NewBuffer(make([]byte, 0, 10000))
+	proc.Stdin = nil
+	proc.Stdout = outBuffer
+	proc.Stderr = os.Stderr
+	if err := proc.Run(); err != nil {
+		return "", fmt.Errorf("%v when running fc-match", err.Error())
+	}
+	outStr := strings.TrimSpace(outBuffer.String())
+	if outStr == "" {
+		return "", fmt.Errorf("No fonts found for %v", pattern)
+	}
+	return outStr, nil
+}
+
+func parseFontFile(fontFile string) (*truetype.Font, error) {
+	fontBuf, err := ioutil.ReadFile(fontFile)
+	if err != nil {
+		return nil, err
+	}
+	fnt, err := truetype.Parse(fontBuf)
+	if err != nil {
+		return nil, fmt.Errorf("%v when parsing %v", err.Error(), fontFile)
+	}
+	return fnt, nil
+}
+
+func newFontFace(fnt *truetype.Font, fontSizePoints, dpi float64) font.Face {
+	return truetype.NewFace(fnt, &truetype.Options{
+		Size:    fontSizePoints,
+		DPI:     dpi,
+		Hinting: font.HintingNone,
+	})
+}
+
+// isFontFile tells whether a --fallback-font argument is a path rather than a family name.
+func isFontFile(arg string) bool {
+	switch strings.ToLower(filepath.Ext(arg)) {
+	case ".ttf", ".otf":
+		return true
+	}
+	return strings.ContainsRune(arg, os.PathSeparator)
+}
+
+// loadFonts finds the fonts specified in opt. It panics if the primary font can't be loaded, but only
+// warns about fallback fonts.
+func loadFonts(opt options) *fontSet {
+	mediumFile := opt.fontFile
+	boldFile := opt.boldFontFile
+	var err error
+	if mediumFile == "" {
+		mediumFile, err = findFont(opt.fontFamily + ":fontformat=TrueType:spacing=mono:weight=medium")
+		if err != nil {
+			panic(fmt.Errorf("%v. Use --font-file to specify a font directly", err.Error()))
+		}
+	}
+	if boldFile == "" {
+		if opt.fontFile != "" {
+			boldFile = mediumFile
+		} else if boldFile, err = findFont(opt.fontFamily + ":fontformat=TrueType:spacing=mono:weight=bold"); err != nil {
+			boldFile = mediumFile
+		}
+	}
+	log("Using font %v for medium and %v for bold", mediumFile, boldFile)
+	mediumFont, err := parseFontFile(mediumFile)
+	if err != nil {
+		panic(err)
+	}
+	boldFont, err := parseFontFile(boldFile)
+	if err != nil {
+		panic(err)
+	}
+	fs := newFontSet(mediumFont, boldFont, opt.fontSize, opt.dpi)
+	for _, fb := range opt.fallbackFonts {
+		fontFile := fb
+		if !isFontFile(fb) {
+			fontFile, err = findFont(fb + ":fontformat=TrueType")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Ignoring fallback font %v: %v\n", fb, err.Error())
+				continue
+			}
+		}
+		if f := fs.loadFallback(fontFile); f != nil {
+			fs.fallbacks = append(fs.fallbacks, f)
+		} else {
+			fmt.Fprintf(os.Stderr, "Ignoring fallback font %v\n", fb)
+		}
+	}
+	_, err = exec.LookPath("fc-match")
+	fs.systemFallback = err == nil
+	return fs
+}
+
+func newFontSet(mediumFont, boldFont *truetype.Font, fontSizePoints, dpi float64) *fontSet {
+	return &fontSet{
+		medium:     newFontFace(mediumFont, fontSizePoints, dpi),
+		bold:       newFontFace(boldFont, fontSizePoints, dpi),
+		mediumFont: mediumFont,
+		boldFont:   boldFont,
+		size:       fontSizePoints,
+		dpi:        dpi,
+		byRune:     make(map[rune]*fallbackFont),
+		byFile:     make(map[string]*fallbackFont),
+	}
+}
+
+// loadFallback loads a font file, or returns nil if it can't be used.
+func (fs *fontSet) loadFallback(fontFile string) *fallbackFont {
+	if f, ok := fs.byFile[fontFile]; ok {
+		return f
+	}
+	fnt, err := parseFontFile(fontFile)
+	var f *fallbackFont
+	if err != nil {
+		log("%v", err)
+	} else {
+		f = &fallbackFont{font: fnt, face: newFontFace(fnt, fs.size, fs.dpi)}
+	}
+	fs.byFile[fontFile] = f
+	return f
+}
+
+// faceFor returns the face to draw r with: the primary face if it has r, otherwise the first fallback that
+// does, otherwise a font from fc-match, or the primary face if nothing has it.
+func (fs *fontSet) faceFor(r rune, bold bool) font.Face {
+	primary, primaryFont := fs.medium, fs.mediumFont
+	if bold {
+		primary, primaryFont = fs.bold, fs.boldFont
+	}
+	if r < 0x80 || primaryFont.Index(r) != 0 {
+		return primary
+	}
+	for _, f := range fs.fallbacks {
+		if f.font.Index(r) != 0 {
+			return f.face
+		}
+	}
+	if !fs.systemFallback {
+		return primary
+	}
+	f, ok := fs.byRune[r]
+	if !ok {
+		fontFile, err := findFont(fmt.Sprintf(":fontformat=TrueType:charset=%x", r))
+		if err == nil {
+			f = fs.loadFallback(fontFile)
+		}
+		if f != nil && f.font.Index(r) == 0 {
+			f = nil
+		}
+		fs.byRune[r] = f
+	}
+	if f == nil {
+		return primary
+	}
+	return f.face
+}
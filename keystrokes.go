@@ -0,0 +1,146 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// A caption is cleared after this many seconds without input, and keys separated by a longer pause
+	// start a new caption.
+	keystrokeCaptionTimeout = 2
+	keystrokeCaptionMaxLen  = 40
+)
+
+var csiKeyNames = map[byte]string{'A': "↑", 'B': "↓", 'C': "→", 'D': "←", 'H': "Home", 'F': "End", 'Z': "⇤"}
+var csiTildeKeyNames = map[string]string{"1": "Home", "2": "Ins", "3": "Del", "4": "End", "5": "PgUp", "6": "PgDn"}
+var csiModifierNames = map[string]string{"2": "Shift-", "3": "Alt-", "5": "Ctrl-", "6": "Ctrl-Shift-"}
+
+// describeKeys splits keyboard input into the keys pressed, as shown in the keystroke overlay. Printable
+// characters are returned as they are, and other keys as symbols or names like ⏎ or Ctrl-C.
+func describeKeys(data []byte) []string {
+	keys := make([]string, 0, len(data))
+	for len(data) > 0 {
+		b := data[0]
+		switch {
+		case b == 0x1b:
+			if len(data) >= 3 && (data[1] == '[' || data[1] == 'O') {
+				// CSI or SS3: parameters, then a final byte
+				end := 2
+				for end < len(data) && (data[end] < 0x40 || data[end] > 0x7e) {
+					end++
+				}
+				if end == len(data) {
+					keys = append(keys, "Esc")
+					data = data[1:]
+					continue
+				}
+				if name := describeCSIKey(string(data[2:end]), data[end]); name != "" {
+					keys = append(keys, name)
+				}
+				data = data[end+1:]
+				continue
+			}
+			if len(data) >= 2 && data[1] != 0x1b {
+				// Alt sends Esc before the key
+				if r, size := utf8.DecodeRune(data[1:]); r >= 0x20 && r != 0x7f && r != utf8.RuneError {
+					keys = append(keys, "Alt-"+string(r))
+					data = data[1+size:]
+				} else {
+					keys = append(keys, "Alt-"+describeKeys(data[1:2])[0])
+					data = data[2:]
+				}
+				continue
+			}
+			keys = append(keys, "Esc")
+		case b == '\r' || b == '\n':
+			keys = append(keys, "⏎")
+		case b == '\t':
+			keys = append(keys, "⇥")
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, "⌫")
+		case b == 0:
+			keys = append(keys, "Ctrl-Space")
+		case b < 0x20:
+			keys = append(keys, "Ctrl-"+string(rune('A'+b-1)))
+		default:
+			r, size := utf8.DecodeRune(data)
+			if r == utf8.RuneError {
+				size = 1
+			} else {
+				keys = append(keys, string(r))
+			}
+			data = data[size:]
+			continue
+		}
+		data = data[1:]
+	}
+	return keys
+}
+
+// keysLength returns the number of characters of the keys in ev, as written in a caption.
+func keysLength(ev *ITSIndex_InputEvent) (n int) {
+	for _, key := range describeKeys(ev.GetData()) {
+		n += utf8.RuneCountInString(key)
+	}
+	return
+}
+
+func describeCSIKey(params string, final byte) string {
+	modifier := ""
+	if i := strings.IndexByte(params, ';'); i >= 0 {
+		modifier = csiModifierNames[params[i+1:]]
+		params = params[:i]
+	}
+	if final == '~' {
+		if name, ok := csiTildeKeyNames[params]; ok {
+			return modifier + name
+		}
+		// e.g. bracketed paste markers
+		return ""
+	}
+	if name, ok := csiKeyNames[final]; ok {
+		return modifier + name
+	}
+	return ""
+}
+
+// keystrokeCaption returns the caption to show at time t: the keys typed since the last pause, if any were
+// typed in the last keystrokeCaptionTimeout seconds.
+func keystrokeCaption(input []*ITSIndex_InputEvent, t float64) string {
+	end := sort.Search(len(input), func(i int) bool {
+		return input[i].GetTimeOffset() > t
+	})
+	if end == 0 || t-input[end-1].GetTimeOffset() > keystrokeCaptionTimeout {
+		return ""
+	}
+	// Only as many keys as fit in the caption are needed, so that this doesn't get slower as typing goes on.
+	// Spaces are added between some keys, so counting only the keys collects enough of them.
+	start := end - 1
+	length := keysLength(input[start])
+	for start > 0 && length <= keystrokeCaptionMaxLen && input[start].GetTimeOffset()-input[start-1].GetTimeOffset() <= keystrokeCaptionTimeout {
+		start--
+		length += keysLength(input[start])
+	}
+	var caption strings.Builder
+	lastWasText := true
+	for _, ev := range input[start:end] {
+		for _, key := range describeKeys(ev.GetData()) {
+			isText := utf8.RuneCountInString(key) == 1 && !strings.ContainsAny(key, "⏎⇥⌫↑↓←→⇤")
+			if caption.Len() > 0 && (!isText || !lastWasText) {
+				caption.WriteByte(' ')
+			}
+			caption.WriteString(key)
+			lastWasText = isText
+		}
+	}
+	runes := []rune(caption.String())
+	if len(runes) > keystrokeCaptionMaxLen {
+		return "…" + string(runes[len(runes)-keystrokeCaptionMaxLen+1:])
+	}
+	return string(runes)
+}
@@ -0,0 +1,68 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_describeKeys(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"ls\r", []string{"l", "s", "⏎"}},
+		{"\x03", []string{"Ctrl-C"}},
+		{"ab\x7f", []string{"a", "b", "⌫"}},
+		{"\x1b[A\x1bOB\x1b[1;5C", []string{"↑", "↓", "Ctrl-→"}},
+		{"\x1b[3~\x1b[5;2~", []string{"Del", "Shift-PgUp"}},
+		{"\x1bb\x1b\x7f", []string{"Alt-b", "Alt-⌫"}},
+		{"\x1b", []string{"Esc"}},
+		{"\x1b\x1b", []string{"Esc", "Esc"}},
+		{"中\t", []string{"中", "⇥"}},
+		{"\x1b[200~x\x1b[201~", []string{"x"}},
+	}
+	for _, tt := range tests {
+		if got := describeKeys([]byte(tt.in)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("describeKeys(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func Test_keystrokeCaption(t *testing.T) {
+	input := []*ITSIndex_InputEvent{
+		{TimeOffset: 1, Data: []byte("git")},
+		{TimeOffset: 1.5, Data: []byte(" st")},
+		{TimeOffset: 2, Data: []byte("\r")},
+		{TimeOffset: 10, Data: []byte("\x03")},
+	}
+	tests := []struct {
+		t    float64
+		want string
+	}{
+		{0.5, ""},
+		{1.2, "git"},
+		{2.5, "git st ⏎"},
+		{4.5, ""},
+		{10, "Ctrl-C"},
+	}
+	for _, tt := range tests {
+		if got := keystrokeCaption(input, tt.t); got != tt.want {
+			t.Errorf("keystrokeCaption at %v = %q, want %q", tt.t, got, tt.want)
+		}
+	}
+
+	// a long burst of typing only shows its end
+	input = nil
+	for i := 0; i < 1000; i++ {
+		input = append(input, &ITSIndex_InputEvent{TimeOffset: float64(i) / 10, Data: []byte("ab\r")})
+	}
+	typed := []rune(strings.Repeat(" ab ⏎", keystrokeCaptionMaxLen))
+	want := "…" + string(typed[len(typed)-keystrokeCaptionMaxLen+1:])
+	if got := keystrokeCaption(input, 100); got != want {
+		t.Errorf("keystrokeCaption of a long burst = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,191 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Longer OSC sequences are not shell integration marks, and are skipped without being buffered. It leaves
+// room for the command line sent with OSC 133;E.
+const markerMaxOSCLen = 4096
+
+// Final bytes of CSI sequences which move the cursor, or insert or delete characters, as line editors do when
+// redrawing the command line. Erasing to the end of the line (K) is left out, since it is also printed after
+// a command line which wasn't edited.
+const markerEditingCSI = "ABCDEFGHf`@PX"
+
+const (
+	markerScanText = iota
+	markerScanEsc
+	markerScanCSI
+	markerScanOSC
+	markerScanOSCEsc
+)
+
+// markerScanner finds shell integration marks in terminal output: OSC 133 (FinalTerm) prompt and command
+// marks, as emitted by the shell integration scripts of iTerm2, VS Code, WezTerm and others, and iTerm2's
+// OSC 1337;SetMark bookmarks. Escape sequences may be split across calls to scan.
+type markerScanner struct {
+	state     int
+	osc       []byte
+	oscLong   bool
+	inCommand bool
+	// text printed between 133;B and 133;C, which is the command line typed at the prompt, unless it was
+	// edited with anything other than backspace.
+	command []byte
+	edited  bool
+	afterCR bool
+	// the command line sent by the shell with OSC 133;E or 633;E, which is used instead of command if set
+	explicitCommand    string
+	hasExplicitCommand bool
+	markers            []*ITSIndex_Marker
+}
+
+// scan looks for marks in data, which is shown in the frame at time t.
+func (s *markerScanner) scan(data []byte, t float64) {
+	for _, b := range data {
+		switch s.state {
+		case markerScanText:
+			switch {
+			case b == 0x1b:
+				s.state = markerScanEsc
+			case !s.inCommand:
+			case b == '\b':
+				if len(s.command) > 0 {
+					_, size := utf8.DecodeLastRune(s.command)
+					s.command = s.command[:len(s.command)-size]
+				}
+			case b == '\r':
+				s.afterCR = true
+			case b == '\n':
+				s.afterCR = false
+			case b >= 0x20 && b != 0x7f:
+				// going back to the start of the line and printing over it is a redraw
+				if s.afterCR {
+					s.edited = true
+				}
+				s.command = append(s.command, b)
+			}
+		case markerScanEsc:
+			switch {
+			case b == '[':
+				s.state = markerScanCSI
+			case b == ']':
+				s.startOSC()
+			case b >= 0x20 && b <= 0x2f:
+				// intermediate byte, e.g. ESC ( B
+			default:
+				s.state = markerScanText
+			}
+		case markerScanCSI:
+			if b >= 0x40 && b <= 0x7e {
+				s.state = markerScanText
+				if s.inCommand && strings.IndexByte(markerEditingCSI, b) >= 0 {
+					s.edited = true
+				}
+			}
+		case markerScanOSC:
+			switch b {
+			case 0x07:
+				s.endOSC(t)
+			case 0x1b:
+				s.state = markerScanOSCEsc
+			default:
+				if len(s.osc) < markerMaxOSCLen {
+					s.osc = append(s.osc, b)
+				} else {
+					s.oscLong = true
+				}
+			}
+		case markerScanOSCEsc:
+			// ESC \ terminates the sequence. Anything else after ESC aborts it.
+			if b == '\\' {
+				s.endOSC(t)
+			} else {
+				s.state = markerScanText
+			}
+		}
+	}
+}
+
+func (s *markerScanner) startOSC() {
+	s.state = markerScanOSC
+	s.osc = s.osc[:0]
+	s.oscLong = false
+}
+
+func (s *markerScanner) endOSC(t float64) {
+	s.state = markerScanText
+	if s.oscLong {
+		return
+	}
+	params := strings.Split(string(s.osc), ";")
+	switch {
+	// 633 is the VS Code variant of 133
+	case (params[0] == "133" || params[0] == "633") && len(params) > 1:
+		switch params[1] {
+		case "A":
+			s.inCommand = false
+			s.hasExplicitCommand = false
+			s.addMarker(t, ITSIndex_Marker_MARKERTYPE_PROMPT, "")
+		case "B":
+			s.inCommand = true
+			s.command = s.command[:0]
+			s.edited = false
+			s.afterCR = false
+		case "E":
+			if params[0] == "633" {
+				// ; is escaped, and an optional nonce can follow
+				if len(params) > 2 {
+					s.explicitCommand = unescapeVSCodeCommand(params[2])
+				}
+			} else {
+				s.explicitCommand = strings.Join(params[2:], ";")
+			}
+			s.hasExplicitCommand = true
+		case "C":
+			var label string
+			if s.hasExplicitCommand {
+				label = strings.TrimSpace(s.explicitCommand)
+			} else if s.inCommand && !s.edited {
+				label = strings.TrimSpace(string(bytes.ToValidUTF8(s.command, nil)))
+			}
+			s.inCommand = false
+			s.hasExplicitCommand = false
+			s.addMarker(t, ITSIndex_Marker_MARKERTYPE_COMMAND, label)
+		}
+	case params[0] == "1337" && len(params) > 1 && params[1] == "SetMark":
+		s.addMarker(t, ITSIndex_Marker_MARKERTYPE_BOOKMARK, "")
+	}
+}
+
+// unescapeVSCodeCommand decodes the command line of OSC 633;E, in which \\ is a backslash and \xAB is the
+// byte AB in hex.
+func unescapeVSCodeCommand(s string) string {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && s[i+1] == '\\' {
+			out = append(out, '\\')
+			i++
+			continue
+		}
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if v, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				out = append(out, byte(v))
+				i += 3
+				continue
+			}
+		}
+		out = append(out, s[i])
+	}
+	return string(bytes.ToValidUTF8(out, nil))
+}
+
+func (s *markerScanner) addMarker(t float64, markerType ITSIndex_Marker_MarkerType, label string) {
+	s.markers = append(s.markers, &ITSIndex_Marker{TimeOffset: t, Type: markerType, Label: label})
+}
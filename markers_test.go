@@ -0,0 +1,63 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"testing"
+)
+
+func Test_markerScanner(t *testing.T) {
+	output := "\x1b]133;A\x07\x1b[1;32m$\x1b[0m \x1b]133;B\x07gti\b\b\bgit status\x1b[K\r\n\x1b]133;C\x1b\\On branch master\r\n" +
+		"\x1b]1337;SetMark\x07\x1b]0;a title\x07\x1b]133;D;0\x07\x1b]133;A\x07$ \x1b]133;B\x07\r\n\x1b]133;C\x07"
+	chunks := []int{3, 12, 40, 41, 80, 100}
+	s := markerScanner{}
+	last := 0
+	for i, end := range append(chunks, len(output)) {
+		s.scan([]byte(output[last:end]), float64(i))
+		last = end
+	}
+	want := []struct {
+		t          float64
+		markerType ITSIndex_Marker_MarkerType
+		label      string
+	}{
+		{1, ITSIndex_Marker_MARKERTYPE_PROMPT, ""},
+		{4, ITSIndex_Marker_MARKERTYPE_COMMAND, "git status"},
+		{5, ITSIndex_Marker_MARKERTYPE_BOOKMARK, ""},
+		{6, ITSIndex_Marker_MARKERTYPE_PROMPT, ""},
+		{6, ITSIndex_Marker_MARKERTYPE_COMMAND, ""},
+	}
+	if len(s.markers) != len(want) {
+		t.Fatalf("got %v markers, want %v: %v", len(s.markers), len(want), s.markers)
+	}
+	for i, w := range want {
+		m := s.markers[i]
+		if m.GetTimeOffset() != w.t || m.GetType() != w.markerType || m.GetLabel() != w.label {
+			t.Errorf("marker %v = %v, want %+v", i, m, w)
+		}
+	}
+}
+
+func Test_markerScanner_commandLabel(t *testing.T) {
+	tests := []struct {
+		output string
+		label  string
+	}{
+		{"\x1b]133;B\x07ls -l\x1b[K\r\n\x1b]133;C\x07", "ls -l"},
+		// edited by moving the cursor back and inserting, which can't be followed without a terminal
+		{"\x1b]133;B\x07ls -l\x1b[3D\x1b[1@a\r\n\x1b]133;C\x07", ""},
+		// redrawn from the start of the line
+		{"\x1b]133;B\x07gti\r$ git\r\n\x1b]133;C\x07", ""},
+		// the command line sent by the shell is used instead
+		{"\x1b]133;B\x07gti\r$ git\x1b]133;E;git log; echo\x07\r\n\x1b]133;C\x07", "git log; echo"},
+		{"\x1b]633;B\x07x\x1b[D\x1b]633;E;echo a\\x3bb \\\\ c;nonce\x07\x1b]633;C\x07", "echo a;b \\ c"},
+	}
+	for _, tt := range tests {
+		s := markerScanner{}
+		s.scan([]byte(tt.output), 0)
+		if len(s.markers) != 1 || s.markers[0].GetLabel() != tt.label {
+			t.Errorf("%q: got %v, want the label %q", tt.output, s.markers, tt.label)
+		}
+	}
+}
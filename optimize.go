@@ -1,3 +1,6 @@
+//go:build !js
+// +build !js
+
 package main
 
 import (
@@ -12,6 +15,13 @@ import (
 )
 
 func doOpOptimize(opt options) {
+	reencodeITS(opt, nil, nil)
+}
+
+// reencodeITS rewrites opt.itsInput to opt.itsOutput, compressed with a dictionary built from its frames. If
+// translateColor is not nil, indexed colors are written as RGB from it. mapCell, if not nil, is called on
+// every cell before the frame is written.
+func reencodeITS(opt options, translateColor *colorProfile, mapCell func(c *frameCell)) {
 	fIts, err := os.OpenFile(opt.itsInput, os.O_RDONLY, 0)
 	if err != nil {
 		panic(err)
@@ -83,36 +93,16 @@ func doOpOptimize(opt options) {
 	}
 
 	// pass one: count frames
-	firstOffset := header.GetFirstFrameOffset()
 	stat, err := fIts.Stat()
 	var fileSize uint64
 	if err == nil {
 		fileSize = uint64(stat.Size())
 	}
-	nextOffset := firstOffset
-	inputFrameIndex := &ITSIndex{}
-	inputFrameIndex.Count = 0
-	inputFrameIndex.Frames = make([]*ITSIndex_FrameIndex, 0, 1000)
-	for {
-		var err error
-		var frameStruct *ITSFrame
-		thisOffset := nextOffset
-		if thisOffset >= header.IndexOffset {
-			break
-		}
-		frameStruct, nextOffset, err = d.readFrameStructFromOffset(nextOffset)
-		if err != nil {
-			break
-		}
-		inputFrameIndex.Count++
-		fIndexEntry := &ITSIndex_FrameIndex{}
-		fIndexEntry.TimeOffset = frameStruct.GetTimeOffset()
-		fIndexEntry.ByteOffset = thisOffset
-		inputFrameIndex.Frames = append(inputFrameIndex.Frames, fIndexEntry)
-		if inputFrameIndex.Count%10 == 0 {
-			fmt.Fprintf(os.Stderr, "\r\033[2KIndexing frames... (%v / %v)", humanize.Bytes(firstOffset+thisOffset), humanize.Bytes(fileSize))
-		}
+	var oldIndex *ITSIndex
+	if header.GetIndexOffset() != 1<<63 {
+		oldIndex, _ = readIndex(fIts, int64(fileSize), header.GetIndexOffset(), d.compressed)
 	}
+	inputFrameIndex := listFrames(d, header, oldIndex, fileSize)
 	fmt.Fprintf(os.Stderr, "\r\033[2KThere are %v frames.\n", inputFrameIndex.Count)
 	d.index = inputFrameIndex
 	const numSamples = 1000
@@ -141,18 +131,24 @@ func doOpOptimize(opt options) {
 	fOut.Seek(0, os.SEEK_SET)
 	e.t = nil
 	e.size = d.frameSize
+	e.title = header.GetTitle()
 	e.dict = dict
+	e.translateColor = translateColor
 	e.cdict, err = gozstd.NewCDict(dict)
 	if err != nil {
 		panic(err)
 	}
 	e.initOutputFile(fOut)
+	// keep the input track and markers, which are only stored in the index
+	e.index.Input = oldIndex.GetInput()
+	e.markers.markers = oldIndex.GetMarkers()
 	lastIndexEntry := inputFrameIndex.Frames[len(inputFrameIndex.Frames)-1]
 	lastFrame, _, err := d.readFrameStructFromOffset(lastIndexEntry.ByteOffset)
 	if err != nil {
 		panic(err)
 	}
 	totalTime := math.Round((lastFrame.GetTimeOffset()+lastFrame.GetDuration())*10) / 10
+	p := e.newFramePipeline(opt.jobs)
 	for i := uint64(0); i < inputFrameIndex.Count; i++ {
 		bOff := inputFrameIndex.Frames[i].ByteOffset
 		finfo, content, err, _ := d.readFrameFromOffset(bOff)
@@ -160,12 +156,60 @@ func doOpOptimize(opt options) {
 			fmt.Fprintf(os.Stderr, "\nError reading frame %v\n", i)
 			continue
 		}
-		e.writeFrame(&finfo, content)
+		if mapCell != nil {
+			for j := range content {
+				mapCell(&content[j])
+			}
+		}
+		p.writeFrame(&finfo, content)
 		if i%5 == 0 {
 			fmt.Fprintf(os.Stderr, "\r\033[2KWritting frame %v / %v, t=%vs / %vs", i, inputFrameIndex.Count, math.Round(finfo.time*10)/10, totalTime)
 		}
 	}
+	p.close()
 	fmt.Fprintf(os.Stderr, "\r\033[2KWrote %v frames, finalizing file...\n", inputFrameIndex.Count)
 	e.finalize()
 	fOut.Close()
 }
+
+// listFrames returns where each frame of the file opened in d is. The list stored in oldIndex is used if
+// there is one, otherwise frames are read one after another until the text index or the index.
+func listFrames(d *decoderState, header *ITSHeader, oldIndex *ITSIndex, fileSize uint64) *ITSIndex {
+	firstOffset := header.GetFirstFrameOffset()
+	// frames end where the text index, if any, or the index starts
+	framesEnd := header.GetIndexOffset()
+	if oldIndex.GetTextIndexOffset() > 0 && oldIndex.GetTextIndexOffset() < framesEnd {
+		framesEnd = oldIndex.GetTextIndexOffset()
+	}
+	nextOffset := firstOffset
+	inputFrameIndex := &ITSIndex{}
+	inputFrameIndex.Count = 0
+	inputFrameIndex.Frames = make([]*ITSIndex_FrameIndex, 0, 1000)
+	if len(oldIndex.GetFrames()) > 0 {
+		// a finished recording already lists its frames
+		inputFrameIndex.Count = uint64(len(oldIndex.GetFrames()))
+		inputFrameIndex.Frames = oldIndex.GetFrames()
+		nextOffset = framesEnd
+	}
+	for {
+		var err error
+		var frameStruct *ITSFrame
+		thisOffset := nextOffset
+		if thisOffset >= framesEnd {
+			break
+		}
+		frameStruct, nextOffset, err = d.readFrameStructFromOffset(nextOffset)
+		if err != nil {
+			break
+		}
+		inputFrameIndex.Count++
+		fIndexEntry := &ITSIndex_FrameIndex{}
+		fIndexEntry.TimeOffset = frameStruct.GetTimeOffset()
+		fIndexEntry.ByteOffset = thisOffset
+		inputFrameIndex.Frames = append(inputFrameIndex.Frames, fIndexEntry)
+		if inputFrameIndex.Count%10 == 0 {
+			fmt.Fprintf(os.Stderr, "\r\033[2KIndexing frames... (%v / %v)", humanize.Bytes(firstOffset+thisOffset), humanize.Bytes(fileSize))
+		}
+	}
+	return inputFrameIndex
+}
@@ -0,0 +1,62 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_reencodeITS_textIndex(t *testing.T) {
+	e, path := newTestEncoder(t, 4, 10)
+	defer os.Remove(path)
+	const nbFrames = 5
+	contents := make([]frameContent, nbFrames)
+	for i := 0; i < nbFrames; i++ {
+		contents[i] = randFrameContent(e, int64(i))
+		e.writeFrame(&frame{index: uint64(i), time: float64(i), duration: 1}, contents[i])
+	}
+	e.finalize()
+	in := initPlayer(options{itsInput: path})
+	if in.index.GetTextIndexOffset() == 0 {
+		t.Fatalf("expected the input to have a text index")
+	}
+	// from the stored list, or by reading frames up to the text index
+	for _, oldIndex := range []*ITSIndex{in.index, {TextIndexOffset: in.index.GetTextIndexOffset()}} {
+		if got := listFrames(in, e.fileHeader, oldIndex, 0); got.Count != nbFrames || len(got.Frames) != nbFrames {
+			t.Errorf("listFrames found %v frames, expected %v", got.Count, nbFrames)
+		}
+	}
+
+	out := path + ".optimized"
+	defer os.Remove(out)
+	reencodeITS(options{itsInput: path, itsOutput: out, jobs: 2}, nil, nil)
+
+	d := initPlayer(options{itsInput: out})
+	if d.index.GetCount() != nbFrames {
+		t.Fatalf("Expected %v frames, got %v", nbFrames, d.index.GetCount())
+	}
+	for i := uint64(0); i < nbFrames; i++ {
+		byteOffset, _ := d.frameIdLookup(i)
+		finfo, content, err, _ := d.readFrameFromOffset(byteOffset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if finfo.time != float64(i) || finfo.duration != 1 {
+			t.Errorf("Frame %v: got t=%v, duration %v", i, finfo.time, finfo.duration)
+		}
+		for j := range content {
+			if !content[j].equalsTo(&contents[i][j]) {
+				t.Errorf("Frame %v: content differs at cell %v", i, j)
+				break
+			}
+		}
+	}
+	d.loadTextIndex()
+	want := frameText(contents[nbFrames-1], &d.frameSize)
+	if text, _ := d.textAt(nbFrames - 1); d.textIndex == nil || !reflect.DeepEqual(text, want) {
+		t.Errorf("text index of the optimized file gives %q, expected %q", text, want)
+	}
+}
@@ -0,0 +1,87 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"runtime"
+)
+
+// framePipeline compresses frames on multiple goroutines while keeping the output file and index in order.
+// Turning terminal output into frameContent has to stay sequential since it goes through the single vterm,
+// but building the ITSFrame, marshaling and compressing is independent for each frame.
+type framePipeline struct {
+	e       *encoderState
+	jobs    chan *pipelineJob
+	ordered chan *pipelineJob
+	done    chan struct{}
+}
+
+type pipelineJob struct {
+	info    frame
+	content frameContent
+	buf     []byte // already marshaled frame, used instead of content if not nil
+	text    []string
+	result  chan []byte
+}
+
+// newFramePipeline starts a pipeline writing to e, which must already have its output file initialized. No
+// other frames should be written to e until close returns.
+func (e *encoderState) newFramePipeline(workers int) *framePipeline {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	p := &framePipeline{}
+	p.e = e
+	p.jobs = make(chan *pipelineJob, workers)
+	// bounds the number of frames in flight, since each frameContent can be quite large.
+	p.ordered = make(chan *pipelineJob, workers*2)
+	p.done = make(chan struct{})
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	go p.writer()
+	return p
+}
+
+func (p *framePipeline) worker() {
+	for job := range p.jobs {
+		if job.buf != nil {
+			job.result <- p.e.compressFrameBytes(job.buf)
+		} else {
+			// text is read by the writer after it receives the result
+			job.text = frameText(job.content, &p.e.size)
+			job.result <- p.e.compressFrame(&job.info, job.content)
+		}
+	}
+}
+
+func (p *framePipeline) writer() {
+	for job := range p.ordered {
+		compressed := <-job.result
+		p.e.writeCompressedFrame(&job.info, compressed, job.text)
+	}
+	close(p.done)
+}
+
+func (p *framePipeline) submit(job *pipelineJob) {
+	job.info.data = nil
+	job.result = make(chan []byte, 1)
+	p.ordered <- job
+	p.jobs <- job
+}
+
+func (p *framePipeline) writeFrame(frameInfo *frame, content frameContent) {
+	p.submit(&pipelineJob{info: *frameInfo, content: content})
+}
+
+func (p *framePipeline) writeFrameBytes(frameInfo *frame, buf []byte, text []string) {
+	p.submit(&pipelineJob{info: *frameInfo, buf: buf, text: text})
+}
+
+// close waits for all submitted frames to be written.
+func (p *framePipeline) close() {
+	close(p.jobs)
+	close(p.ordered)
+	<-p.done
+}
@@ -0,0 +1,135 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"github.com/micromaomao/go-libvterm"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func randFrameContent(e *encoderState, seed int64) frameContent {
+	r := rand.New(rand.NewSource(seed))
+	fc := e.newFrameContent()
+	for i := range fc {
+		fc[i].chars = []rune{rune('a' + r.Intn(26))}
+		fc[i].style.fg = vterm.NewVTermColorIndexed(uint8(r.Intn(16)))
+		fc[i].style.bg = vterm.NewVTermColorRGB(randColor())
+	}
+	return fc
+}
+
+func newTestEncoder(t testing.TB, rows, cols int) (*encoderState, string) {
+	f, err := ioutil.TempFile("", "ts-player-test-*.its")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &encoderState{}
+	e.size = sizeStruct{rows: rows, cols: cols}
+	e.initOutputFile(f)
+	return e, f.Name()
+}
+
+// testFrame returns content with lines written in white on black, see testCell.
+func testFrame(size sizeStruct, lines ...string) frameContent {
+	fc := make(frameContent, size.rows*size.cols)
+	for row := 0; row < size.rows; row++ {
+		var line []rune
+		if row < len(lines) {
+			line = []rune(lines[row])
+		}
+		for col := 0; col < size.cols; col++ {
+			c := ' '
+			if col < len(line) {
+				c = line[col]
+			}
+			fc.setCellAt(row, col, testCell(c), &size)
+		}
+	}
+	return fc
+}
+
+// writeTestRecording writes a recording where frame i starts at i seconds and lasts one second, and returns
+// its file name.
+func writeTestRecording(t testing.TB, size sizeStruct, contents ...frameContent) string {
+	e, path := newTestEncoder(t, size.rows, size.cols)
+	for i, content := range contents {
+		e.writeFrame(&frame{index: uint64(i), time: float64(i), duration: 1}, content)
+	}
+	e.finalize()
+	return path
+}
+
+func Test_framePipeline_order(t *testing.T) {
+	e, path := newTestEncoder(t, 10, 20)
+	defer os.Remove(path)
+	const nbFrames = 50
+	contents := make([]frameContent, nbFrames)
+	p := e.newFramePipeline(4)
+	for i := 0; i < nbFrames; i++ {
+		contents[i] = randFrameContent(e, int64(i))
+		p.writeFrame(&frame{index: uint64(i), time: float64(i) / 10, duration: 0.1}, contents[i])
+	}
+	p.close()
+	e.finalize()
+
+	d := initPlayer(options{itsInput: path})
+	if d.index.GetCount() != nbFrames {
+		t.Fatalf("Expected %v frames, got %v", nbFrames, d.index.GetCount())
+	}
+	for i := uint64(0); i < nbFrames; i++ {
+		byteOffset, timeOffset := d.frameIdLookup(i)
+		finfo, content, err, _ := d.readFrameFromOffset(byteOffset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if finfo.index != i || finfo.time != timeOffset {
+			t.Errorf("Frame %v: got frameId %v at t=%v, index says t=%v", i, finfo.index, finfo.time, timeOffset)
+		}
+		for j := range content {
+			if !content[j].equalsTo(&contents[i][j]) {
+				t.Errorf("Frame %v: content differs at cell %v", i, j)
+				break
+			}
+		}
+	}
+}
+
+func benchmarkEncode(b *testing.B, workers int) {
+	e, path := newTestEncoder(b, 60, 160)
+	defer os.Remove(path)
+	contents := make([]frameContent, 16)
+	for i := range contents {
+		contents[i] = randFrameContent(e, int64(i))
+	}
+	b.ResetTimer()
+	if workers == 0 {
+		for i := 0; i < b.N; i++ {
+			e.writeFrame(&frame{index: uint64(i)}, contents[i%len(contents)])
+		}
+	} else {
+		p := e.newFramePipeline(workers)
+		for i := 0; i < b.N; i++ {
+			p.writeFrame(&frame{index: uint64(i)}, contents[i%len(contents)])
+		}
+		p.close()
+	}
+	b.StopTimer()
+	e.finalize()
+}
+
+func Benchmark_encode_sequential(b *testing.B) {
+	benchmarkEncode(b, 0)
+}
+
+func Benchmark_encode_pipeline(b *testing.B) {
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(strconv.Itoa(workers), func(b *testing.B) {
+			benchmarkEncode(b, workers)
+		})
+	}
+}
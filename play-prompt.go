@@ -0,0 +1,92 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	seekPromptLabel   = "go to [[hh:]mm:]ss, N% or #frame"
+	searchPromptLabel = "search"
+)
+
+// handlePromptKey edits the prompt opened by g or /. Enter seeks to or searches for what was typed, and Escape
+// or Ctrl-C closes the prompt.
+func (d *decoderState) handlePromptKey(key byte) {
+	switch key {
+	case '\r', '\n':
+		if d.promptKind == '/' {
+			// an empty pattern searches for the last one again
+			if len(d.prompt) > 0 {
+				d.search = newSearchPattern(string(d.prompt))
+			}
+			d.promptOpen = false
+			if d.search != nil {
+				d.startSearch(false)
+			}
+			return
+		}
+		frameId, err := d.parseSeekTarget(string(d.prompt))
+		if err != nil {
+			d.promptError = err.Error()
+			return
+		}
+		d.renderingFrameId = frameId
+		d.promptOpen = false
+		d.showControlBarFor(time.Second)
+	case 0x1b, 0x03, 0x07:
+		d.promptOpen = false
+	case 0x7f, 0x08:
+		if len(d.prompt) == 0 {
+			d.promptOpen = false
+			return
+		}
+		d.prompt = d.prompt[:len(d.prompt)-1]
+		d.promptError = ""
+	case 0x15:
+		// Ctrl-U
+		d.prompt = d.prompt[:0]
+		d.promptError = ""
+	default:
+		if key >= 0x20 && key < 0x7f && len(d.prompt) < 32 {
+			d.prompt = append(d.prompt, rune(key))
+			d.promptError = ""
+		}
+	}
+}
+
+// parseSeekTarget finds the frame for what was typed at the g prompt: a time like 1:02:30, a percentage of
+// the recording like 50%, or a frame number like #1200.
+func (d *decoderState) parseSeekTarget(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "#") {
+		frameId, err := strconv.ParseUint(s[1:], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid frame number")
+		}
+		if frameId > d.lastFrameId {
+			return 0, fmt.Errorf("The last frame is #%v", d.lastFrameId)
+		}
+		return frameId, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(s[:len(s)-1]), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return 0, fmt.Errorf("Invalid percentage")
+		}
+		_, totalTime := d.frameIdLookup(d.lastFrameId)
+		frameId, _ := d.searchForFrame(totalTime * percent / 100)
+		return frameId, nil
+	}
+	t, err := parseTimestamp(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time")
+	}
+	frameId, _ := d.searchForFrame(t)
+	return frameId, nil
+}
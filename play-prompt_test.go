@@ -0,0 +1,91 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// newTestPlayer returns a player for a recording with one frame per second, from 0 to 200s.
+func newTestPlayer() *decoderState {
+	d := &decoderState{}
+	d.index = &ITSIndex{}
+	d.index.Count = 201
+	for i := uint64(0); i < d.index.Count; i++ {
+		d.index.Frames = append(d.index.Frames, &ITSIndex_FrameIndex{ByteOffset: i, TimeOffset: float64(i)})
+	}
+	d.lastFrameId = d.index.Count - 1
+	d.seekStep = 5
+	d.speed = 1
+	d.updateSignal = sync.NewCond(&sync.Mutex{})
+	return d
+}
+
+func (d *decoderState) typeKeys(keys string) {
+	for i := 0; i < len(keys); i++ {
+		d.handleKey(keys[i])
+	}
+}
+
+func Test_decoderState_parseSeekTarget(t *testing.T) {
+	d := newTestPlayer()
+	tests := []struct {
+		input string
+		want  uint64
+		err   bool
+	}{
+		{"90", 90, false},
+		{"1:30", 90, false},
+		{"0:02:10.5", 130, false},
+		{"50%", 100, false},
+		{" 100% ", 200, false},
+		{"#42", 42, false},
+		{"#201", 0, true},
+		{"150%", 0, true},
+		{"#x", 0, true},
+		{"1:xx", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := d.parseSeekTarget(tt.input)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseSeekTarget(%q) = %v, %v", tt.input, got, err)
+		}
+	}
+}
+
+func Test_decoderState_handleKey(t *testing.T) {
+	d := newTestPlayer()
+	steps := []struct {
+		keys string
+		want uint64
+	}{
+		{"l", 5},
+		{"10l", 55},
+		{"2j", 45},
+		{"3.", 48},
+		{"12,", 36},
+		{"0", 0},
+		{"g1:02\r", 62},
+		{"g#7\r", 7},
+		{"$", 200},
+		{"^", 0},
+		{"g50\x7f\x7f5%\r", 10},
+		// an invalid target keeps the prompt open, and Escape closes it without seeking
+		{"gabc\r", 10},
+		{"\x1bl", 15},
+		// counts stop growing at maxCount
+		{"99999999999999999999,", 0},
+		{"99999999999999999999.", 200},
+	}
+	for _, s := range steps {
+		d.typeKeys(s.keys)
+		if d.renderingFrameId != s.want {
+			t.Errorf("after %q: at frame %v, expected %v", s.keys, d.renderingFrameId, s.want)
+		}
+	}
+	if d.promptOpen || d.count != 0 {
+		t.Errorf("prompt or count left over")
+	}
+}
@@ -1,15 +1,14 @@
+//go:build !js
+// +build !js
+
 package main
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
-	"github.com/golang/protobuf/proto"
 	"github.com/mattn/go-isatty"
 	"github.com/micromaomao/go-libvterm"
-	"github.com/valyala/gozstd"
 	"image/color"
 	"io"
 	"math"
@@ -21,13 +20,11 @@ import (
 )
 
 type decoderState struct {
-	frameSize      sizeStruct
-	index          *ITSIndex
-	lastFrameId    uint64
-	compressed     bool
-	ddict          *gozstd.DDict
-	file           *os.File
+	itsReader
 	translateColor *colorProfile
+	// term and colors are only set for play. colors is nil if the terminal can show RGB colors.
+	term   termCaps
+	colors *colorQuantizer
 
 	renderingFrameId     uint64
 	updateSignal         *sync.Cond
@@ -39,9 +36,42 @@ type decoderState struct {
 
 	exiting bool
 
+	// seekStep is how far j and l seek, in seconds.
+	seekStep float64
+	// speed is changed with + and -. idleLimit, if not 0, is the longest a frame stays on screen, in
+	// recording time.
+	speed     float64
+	idleLimit float64
+	// count is the number typed before a command, like the 10 in 10l.
+	count int
+	// prompt is what has been typed after g or /, which is promptKind, shown in the control bar while promptOpen.
+	promptOpen  bool
+	promptKind  byte
+	prompt      []rune
+	promptError string
+
+	// search is the last pattern searched for, highlighted on screen. searchGen is increased to cancel the
+	// search running in the background, if searching.
+	search        *searchPattern
+	searchGen     int
+	searching     bool
+	searchMessage string
+	// textIndex is only read by a search, and is nil if the file has none.
+	textIndex     *ITSTextIndex
+	textIndexOnce sync.Once
+
 	updateTimer *time.Timer
 }
 
+const (
+	minPlaySpeed = 0.25
+	maxPlaySpeed = 16
+	// how many frames loadFramesThread keeps decoded ahead at normal speed
+	prefetchFrames = 10
+	// the largest count that can be typed before a command
+	maxCount = 9999
+)
+
 type frameToRender struct {
 	frameId      uint64
 	frameContent frameContent
@@ -54,13 +84,17 @@ func doOpPlay(opt options) {
 		os.Exit(1)
 	}
 	d := initPlayer(opt)
+	d.initTerminal(opt)
+	d.seekStep = opt.seekStep
+	d.speed = opt.speed
+	d.idleLimit = opt.maxIdle
 	if isatty.IsTerminal(2) {
 		os.Stderr.Close()
 	}
 	initTtyAttr := termSetRaw()
-	fmt.Fprintf(os.Stdout, "\033[1049h")
+	os.Stdout.WriteString(d.term.enterAltScreen)
 	go d.uiThread()
-	signalChannel := make(chan os.Signal)
+	signalChannel := make(chan os.Signal, 1)
 	go func() {
 		for {
 			sig := <-signalChannel
@@ -89,7 +123,7 @@ func doOpPlay(opt options) {
 		d.updateSignal.L.Unlock()
 		time.Sleep(20 * time.Millisecond)
 	}
-	fmt.Fprintf(os.Stdout, "\033[1049l")
+	os.Stdout.WriteString(d.term.exitAltScreen)
 	termRestore(initTtyAttr)
 }
 
@@ -98,107 +132,17 @@ func initPlayer(opt options) *decoderState {
 	if err != nil {
 		panic(err)
 	}
-	magicBuffer := make([]byte, len(FileMagic))
-	n, err := fIts.Read(magicBuffer)
+	fileStat, err := fIts.Stat()
 	if err != nil {
 		panic(err)
 	}
-	if n != len(FileMagic) || bytes.Compare(magicBuffer, []byte(FileMagic)) != 0 {
-		panic("Not a its file: magic wrong.")
-	}
-	var headerLen uint32
-	binary.Read(fIts, binary.BigEndian, &headerLen)
-	if headerLen > 10000 || headerLen < 1 {
-		panic("Invalid headerLen")
-	}
-	headerBuff := make([]byte, headerLen)
-	n, err = fIts.Read(headerBuff)
-	if uint32(n) < headerLen || err == io.EOF {
-		panic("Permature EOF")
-	}
-	header := &ITSHeader{}
-	err = proto.Unmarshal(headerBuff, header)
+	r, err := openITS(fIts, fileStat.Size())
 	if err != nil {
 		panic(err)
 	}
-	if header.GetVersion() != 1 {
-		panic("Invalid file version. Please update this player.")
-	}
 
 	d := &decoderState{}
-	d.frameSize.rows = int(header.GetRows())
-	d.frameSize.cols = int(header.GetCols())
-	if d.frameSize.rows*d.frameSize.cols <= 0 {
-		panic("Invalid dimension")
-	}
-	indexOffset := header.GetIndexOffset()
-	if indexOffset <= 12 {
-		panic("Invalid indexOffset")
-	}
-	_, err = fIts.Seek(int64(indexOffset), os.SEEK_SET)
-	if err != nil {
-		panic(err)
-	}
-	var indexLen uint64
-	err = binary.Read(fIts, binary.BigEndian, &indexLen)
-	if err != nil {
-		panic(err)
-	}
-	fileStat, err := fIts.Stat()
-	if err != nil {
-		panic(err)
-	}
-	fileLen := uint64(fileStat.Size())
-	if indexOffset+indexLen > fileLen+10000 {
-		panic("Invalid indexLen")
-	}
-	indexBuf := make([]byte, indexLen)
-	n, err = fIts.Read(indexBuf)
-	if err != nil && err != io.EOF {
-		panic(err)
-	}
-	if uint64(n) < indexLen {
-		panic("Permature EOF")
-	}
-	switch header.GetCompressionMode() {
-	case ITSHeader_COMPRESSION_ZSTD:
-		d.compressed = true
-		compressedDictBuf := header.GetCompressionDict()
-		if len(compressedDictBuf) > 0 {
-			dictBuf, err := gozstd.Decompress(nil, compressedDictBuf)
-			if err != nil {
-				panic(err)
-			}
-			d.ddict, err = gozstd.NewDDict(dictBuf)
-			if err != nil {
-				panic(err)
-			}
-		} else {
-			d.ddict = nil
-		}
-
-		indexBuf, err = gozstd.Decompress(nil, indexBuf)
-		if err != nil {
-			panic(err)
-		}
-	case ITSHeader_COMPRESSION_NONE:
-		d.compressed = false
-	default:
-		panic("Unknown compression mode")
-	}
-	d.index = &ITSIndex{}
-	err = proto.Unmarshal(indexBuf, d.index)
-	if err != nil {
-		panic(err)
-	}
-	if d.index.GetCount() <= 0 || len(d.index.GetFrames()) <= 0 {
-		panic("Empty index")
-	}
-	if d.index.GetCount() != uint64(len(d.index.GetFrames())) {
-		panic("Wrong index count")
-	}
-	d.lastFrameId = d.index.GetCount() - 1
-	d.file = fIts
+	d.itsReader = *r
 	d.renderingFrameId = 0
 	d.renderCache = make(map[uint64]frameToRender)
 	d.renderCacheLock = &sync.Mutex{}
@@ -208,7 +152,7 @@ func initPlayer(opt options) *decoderState {
 	d.paused = false
 
 	if opt.colorProfileInput != "" {
-		cf, err := processColorProfile(opt.colorProfileInput)
+		cf, err := loadColorProfile(opt.colorProfileInput)
 		if err != nil {
 			panic(err)
 		}
@@ -219,52 +163,18 @@ func initPlayer(opt options) *decoderState {
 	return d
 }
 
-func (d *decoderState) searchForFrame(time float64) (frameId uint64, indexEntry *ITSIndex_FrameIndex) {
-	frames := d.index.GetFrames()
-	if frames[0].GetTimeOffset()+0.0001 >= time {
-		return 0, frames[0]
-	}
-	if frames[len(frames)-1].GetTimeOffset()-0.0001 < time {
-		return uint64(len(frames) - 1), frames[len(frames)-1]
+// initTerminal sets up the control sequences and colors for the terminal the player is running in.
+func (d *decoderState) initTerminal(opt options) {
+	d.term = loadTermCaps(os.Getenv("TERM"), os.Getenv)
+	mode := detectColorMode(os.Getenv, d.term.colors)
+	if opt.colorMode != "" {
+		mode, _ = parseColorMode(opt.colorMode)
 	}
-	var i, j int
-	j = len(frames)
-	for {
-		if i >= j || i >= len(frames) {
-			if j < 0 {
-				j = 0
-			}
-			if j >= len(frames) {
-				j = len(frames) - 1
-			}
-			if j < i {
-				return uint64(j), frames[j]
-			} else {
-				return uint64(i), frames[i]
-			}
-		}
-		mid := (i + j) / 2
-		foundTime := frames[mid].GetTimeOffset()
-		foundNextTime := foundTime
-		if mid < len(frames)-1 {
-			foundNextTime = frames[mid+1].GetTimeOffset()
-		}
-		if foundTime-0.0001 <= time && time < foundNextTime {
-			return uint64(mid), frames[mid]
-		}
-		if foundTime < time {
-			i = mid + 1
-		} else {
-			j = mid - 1
-		}
+	if mode != colorModeTruecolor {
+		d.colors = newColorQuantizer(mode, d.translateColor)
 	}
 }
 
-func (d *decoderState) frameIdLookup(frameId uint64) (byteOffset uint64, timeOffset float64) {
-	indexEntry := d.index.GetFrames()[frameId]
-	return indexEntry.GetByteOffset(), indexEntry.GetTimeOffset()
-}
-
 func (d *decoderState) readFrameFromOffset(byteOffset uint64) (frameInfo frame, content frameContent, err error, nextOffset uint64) {
 	var frameStruct *ITSFrame
 	frameStruct, nextOffset, err = d.readFrameStructFromOffset(byteOffset)
@@ -287,54 +197,6 @@ func (d *decoderState) readFrameFromOffset(byteOffset uint64) (frameInfo frame,
 	return
 }
 
-func (d *decoderState) readFrameBytesFromOffset(byteOffset uint64) (buf []byte, nextOffset uint64, err error) {
-	_, err = d.file.Seek(int64(byteOffset), os.SEEK_SET)
-	if err != nil {
-		return
-	}
-	var frameByteLen uint32
-	err = binary.Read(d.file, binary.BigEndian, &frameByteLen)
-	if err != nil {
-		return
-	}
-	if frameByteLen > 1024*1024*50 {
-		// gaurd against DNS, arbitrary value.
-		err = fmt.Errorf("invalid frame byteLength near %x", byteOffset)
-		return
-	}
-	buf = make([]byte, frameByteLen)
-	d.file.Read(buf)
-	nextOffset = byteOffset + 4 + uint64(frameByteLen)
-	if d.compressed {
-		if d.ddict != nil {
-			buf, err = gozstd.DecompressDict(nil, buf, d.ddict)
-			if err != nil {
-				return
-			}
-		} else {
-			buf, err = gozstd.Decompress(nil, buf)
-			if err != nil {
-				return
-			}
-		}
-	}
-	return
-}
-
-func (d *decoderState) readFrameStructFromOffset(byteOffset uint64) (frameStruct *ITSFrame, nextOffset uint64, err error) {
-	var buf []byte
-	buf, nextOffset, err = d.readFrameBytesFromOffset(byteOffset)
-	if err != nil {
-		return
-	}
-	frameStruct = &ITSFrame{}
-	err = proto.Unmarshal(buf, frameStruct)
-	if err != nil {
-		return
-	}
-	return
-}
-
 func (d *decoderState) decodeFrameStruct(frameStruct *ITSFrame) (frameInfo frame, content frameContent, err error) {
 	if frameStruct.GetType() != ITSFrame_FRAMETYPE_K {
 		err = errors.New("Unrecognized frame type")
@@ -359,6 +221,46 @@ func (d *decoderState) decodeFrameStruct(frameStruct *ITSFrame) (frameInfo frame
 	return
 }
 
+// scanFrames decodes every frame in order, and returns the smallest size containing everything that was
+// ever shown. A cell counts as blank if it has the same style as the bottom-right cell of the first frame
+// and only contains spaces.
+func (d *decoderState) scanFrames(cb func(finfo *frame, content frameContent)) (extent sizeStruct) {
+	var blankStyle uint64
+	for i := uint64(0); i <= d.lastFrameId; i++ {
+		byteOffset, _ := d.frameIdLookup(i)
+		finfo, content, err, _ := d.readFrameFromOffset(byteOffset)
+		if err != nil {
+			panic(err)
+		}
+		if i == 0 {
+			blankStyle = blankStyleOf(content, &d.frameSize)
+		}
+		d.growExtent(content, blankStyle, &extent)
+		if cb != nil {
+			cb(&finfo, content)
+		}
+	}
+	return
+}
+
+// growExtent enlarges extent so that it contains every cell of content which isn't a space in blankStyle.
+func (d *decoderState) growExtent(content frameContent, blankStyle uint64, extent *sizeStruct) {
+	for row := 0; row < d.frameSize.rows; row++ {
+		for col := d.frameSize.cols - 1; col >= extent.cols || (row >= extent.rows && col >= 0); col-- {
+			cell := content.getCellAt(row, col, &d.frameSize)
+			if !isBlankCell(cell, blankStyle) {
+				if col+1 > extent.cols {
+					extent.cols = col + 1
+				}
+				if row+1 > extent.rows {
+					extent.rows = row + 1
+				}
+				break
+			}
+		}
+	}
+}
+
 func (c *frameCell) equalsTo(c2 *frameCell) bool {
 	if string(c.chars) != string(c2.chars) {
 		return false
@@ -369,9 +271,21 @@ func (c *frameCell) equalsTo(c2 *frameCell) bool {
 	return true
 }
 
-func (d *decoderState) renderFrameContent(perv, next frameContent, out io.Writer, dx, dy, dw, dh int, frameSize sizeStruct) {
+// renderFrameContent draws next, only redrawing the cells which changed from perv if it is not nil. Matches of
+// search, if not nil, are highlighted.
+func (d *decoderState) renderFrameContent(perv, next frameContent, out io.Writer, dx, dy, dw, dh int, frameSize sizeStruct, search *searchPattern) {
+	var pervHighlight, nextHighlight []bool
+	if search != nil {
+		nextHighlight = search.highlight(next, &frameSize)
+		if perv != nil {
+			pervHighlight = search.highlight(perv, &frameSize)
+		}
+	}
+	highlighted := func(mask []bool, row, col int) bool {
+		return mask != nil && mask[row*frameSize.cols+col]
+	}
 	var cursorRow, cursorCol int
-	fmt.Fprintf(out, "\033[%v;%vH", dy+1, dx+1)
+	io.WriteString(out, d.term.moveTo(dy, dx))
 	var lastAttr uint64
 	for row := 0; row < frameSize.rows && row < dh; row++ {
 		if row+dy < 0 {
@@ -381,18 +295,27 @@ func (d *decoderState) renderFrameContent(perv, next frameContent, out io.Writer
 			if col+dx < 0 {
 				continue
 			}
-			if perv != nil && perv.getCellAt(row, col, &frameSize).equalsTo(next.getCellAt(row, col, &frameSize)) {
+			hl := highlighted(nextHighlight, row, col)
+			if perv != nil && hl == highlighted(pervHighlight, row, col) && perv.getCellAt(row, col, &frameSize).equalsTo(next.getCellAt(row, col, &frameSize)) {
 				continue
 			}
 			if cursorRow != row || cursorCol != col {
-				fmt.Fprintf(out, "\033[%d;%dH", row+dy+1, col+dx+1)
+				io.WriteString(out, d.term.moveTo(row+dy, col+dx))
 				cursorRow = row
 				cursorCol = col
 			}
 			cell := next.getCellAt(row, col, &frameSize)
+			if hl {
+				restyled := *cell
+				restyled.style.fg = vterm.NewVTermColorRGB(color.RGBA{0, 0, 0, 255})
+				restyled.style.bg = vterm.NewVTermColorRGB(color.RGBA{255, 215, 0, 255})
+				cell = &restyled
+			}
 			if row != 0 && col != 0 && cell.attrCode(nil) == lastAttr {
 				// no need to output attr
 				out.Write([]byte(string(cell.chars)))
+			} else if d.colors != nil {
+				out.Write([]byte(d.colors.sgr(cell.attrCode(nil)) + string(cell.chars)))
 			} else {
 				out.Write([]byte(cell.toOutput(d.translateColor)))
 			}
@@ -404,11 +327,14 @@ func (d *decoderState) renderFrameContent(perv, next frameContent, out io.Writer
 func (d *decoderState) uiThread() {
 	var lastFrameRendered *frameToRender = nil
 	var lastFrameStaysBefore time.Time = time.Now()
+	// the speed lastFrameStaysBefore was worked out for
+	var lastFrameSpeed float64
 	var perviousSize sizeStruct
 	renderTo := os.Stdout
 	firstRender := true
 	controlBarShowedLastFrame := false
 	var lastControlBarFC frameContent = nil
+	var lastSearch *searchPattern
 	for {
 		needsForcedRedraw := false
 		if !firstRender {
@@ -434,6 +360,20 @@ func (d *decoderState) uiThread() {
 			d.nextTimeForceRedraw = false
 			needsForcedRedraw = true
 		}
+		search := d.search
+		if search != lastSearch {
+			lastSearch = search
+			needsForcedRedraw = true
+		}
+		if lastFrameRendered != nil && d.speed != lastFrameSpeed {
+			// the part of the frame not shown yet plays at the new speed
+			if remaining := time.Until(lastFrameStaysBefore); remaining > 0 {
+				remaining = time.Duration(float64(remaining) * lastFrameSpeed / d.speed)
+				lastFrameStaysBefore = time.Now().Add(remaining)
+				d.updateWithin(remaining)
+			}
+			lastFrameSpeed = d.speed
+		}
 		if lastFrameRendered != nil {
 			if d.renderingFrameId == lastFrameRendered.frameId && (time.Now().After(lastFrameStaysBefore) && !d.paused && d.lastFrameId > d.renderingFrameId) {
 				d.renderingFrameId++
@@ -443,7 +383,7 @@ func (d *decoderState) uiThread() {
 		}
 		currentRenderingFrameId := d.renderingFrameId
 		showingControlBar := false
-		if d.paused {
+		if d.paused || d.promptOpen || d.searching {
 			showingControlBar = true
 		} else if d.showControlBarBefore != nil && d.showControlBarBefore.After(time.Now()) {
 			showingControlBar = true
@@ -465,35 +405,37 @@ func (d *decoderState) uiThread() {
 					if lastFrameRendered != nil && lastFrameStaysBefore.Before(time.Now().Add(-100*time.Millisecond)) {
 						log("lagging on frame %v (%v)!", lastFrameRendered.frameId, time.Now().Sub(lastFrameStaysBefore))
 					}
-					fmt.Fprintf(renderTo, "\033[1;1H")
 					if pervFrameContent == nil {
-						fmt.Fprintf(renderTo, "\033[J")
+						renderTo.WriteString(d.term.clearScreen)
 					}
-					d.renderFrameContent(pervFrameContent, frameToDraw.frameContent, renderTo, 0, 0, termSz.cols, termSz.rows, d.frameSize)
+					d.renderFrameContent(pervFrameContent, frameToDraw.frameContent, renderTo, 0, 0, termSz.cols, termSz.rows, d.frameSize, search)
 					lastControlBarFC = nil
 					lastFrameRendered = &frameToDraw
-					nextFrameWithin := time.Duration(frameToDraw.duration*1000) * time.Millisecond
-					lastFrameStaysBefore = time.Now().Add(nextFrameWithin)
 					d.updateSignal.L.Lock()
+					nextFrameWithin := d.screenTime(frameToDraw.duration)
+					lastFrameStaysBefore = time.Now().Add(nextFrameWithin)
+					lastFrameSpeed = d.speed
 					d.updateWithin(nextFrameWithin)
 					d.updateSignal.L.Unlock()
 				}
 			} else {
 				if lastFrameRendered == nil && needsForcedRedraw {
-					fmt.Fprintf(renderTo, "\033[1;1H\033[J\033[%v;%vH\033[0;4;1mRendering...\033[0m\033[%v;%vH", termSz.rows/4, termSz.cols/2-6, termSz.rows/4+2, termSz.cols/2)
+					renderTo.WriteString(d.term.clearScreen + d.term.moveTo(termSz.rows/4-1, termSz.cols/2-7))
+					renderTo.WriteString(d.term.resetAttrs + d.term.underline + d.term.bold + "Rendering..." + d.term.resetAttrs)
+					renderTo.WriteString(d.term.moveTo(termSz.rows/4+1, termSz.cols/2-1))
 					lastControlBarFC = nil
 					d.updateSignal.L.Lock()
 					d.updateWithin(100 * time.Millisecond)
 					d.updateSignal.L.Unlock()
 				} else if lastFrameRendered != nil && needsForcedRedraw {
-					fmt.Fprintf(renderTo, "\033[1;1H\033[J")
-					d.renderFrameContent(nil, lastFrameRendered.frameContent, renderTo, 0, 0, termSz.cols, termSz.rows, d.frameSize)
+					renderTo.WriteString(d.term.clearScreen)
+					d.renderFrameContent(nil, lastFrameRendered.frameContent, renderTo, 0, 0, termSz.cols, termSz.rows, d.frameSize, search)
 					lastControlBarFC = nil
 				}
 			}
 		} else if needsForcedRedraw {
-			fmt.Fprintf(renderTo, "\033[1;1H\033[J")
-			d.renderFrameContent(nil, lastFrameRendered.frameContent, renderTo, 0, 0, termSz.cols, termSz.rows, d.frameSize)
+			renderTo.WriteString(d.term.clearScreen)
+			d.renderFrameContent(nil, lastFrameRendered.frameContent, renderTo, 0, 0, termSz.cols, termSz.rows, d.frameSize, search)
 			lastControlBarFC = nil
 		}
 		if showingControlBar {
@@ -520,6 +462,27 @@ func (d *decoderState) uiThread() {
 			} else {
 				leftText = fmt.Sprintf(" playing at frame %v (%vs/%vs)", currentRenderingFrameId, math.Floor(currentTimeOffset*10)/10, uint64(totalTime))
 			}
+			if d.speed != 1 {
+				leftText += fmt.Sprintf(" at %vx", d.speed)
+			}
+			if d.searching {
+				leftText = fmt.Sprintf(" searching for %v...", d.search.text)
+			} else if d.searchMessage != "" {
+				leftText = " " + d.searchMessage
+			}
+			// the prompt replaces the status text, with a cursor after the input
+			promptCursor := -1
+			if d.promptOpen {
+				label := seekPromptLabel
+				if d.promptKind == '/' {
+					label = searchPromptLabel
+				}
+				if d.promptError != "" {
+					label = d.promptError
+				}
+				leftText = fmt.Sprintf(" %v: %v", label, string(d.prompt))
+				promptCursor = len(leftText)
+			}
 			d.updateSignal.L.Unlock()
 			controlBarFc := make(frameContent, w*h)
 			for i := 0; i < len(controlBarFc); i++ {
@@ -533,6 +496,9 @@ func (d *decoderState) uiThread() {
 					if x < len(leftText) {
 						controlBarFc[i].chars = []rune{rune(leftText[x])}
 					}
+					if x == promptCursor {
+						controlBarFc[i].style.fg, controlBarFc[i].style.bg = controlBarFc[i].style.bg, controlBarFc[i].style.fg
+					}
 				} else {
 					// first row, current x = i
 					if i < xThreshold {
@@ -542,7 +508,7 @@ func (d *decoderState) uiThread() {
 					}
 				}
 			}
-			d.renderFrameContent(lastControlBarFC, controlBarFc, renderTo, x, y, w, h, sz)
+			d.renderFrameContent(lastControlBarFC, controlBarFc, renderTo, x, y, w, h, sz, nil)
 			lastControlBarFC = controlBarFc
 		} else {
 			lastControlBarFC = nil
@@ -564,70 +530,118 @@ func (d *decoderState) inputReaderThread() {
 		if err != nil {
 			panic(err)
 		}
-		if leader == '\x03' || leader == 'q' {
-			d.updateSignal.L.Lock()
-			d.exiting = true
-			d.updateSignal.Broadcast()
-			d.updateSignal.L.Unlock()
+		d.updateSignal.L.Lock()
+		d.handleKey(leader)
+		d.updateSignal.Broadcast()
+		exiting := d.exiting
+		d.updateSignal.L.Unlock()
+		if exiting {
 			return
-		} else if leader == 12 {
-			d.updateSignal.L.Lock()
-			d.nextTimeForceRedraw = true
-			d.updateSignal.Broadcast()
-			d.updateSignal.L.Unlock()
-		} else if leader == ' ' || leader == 'k' {
-			d.updateSignal.L.Lock()
-			if d.paused {
-				before := time.Now().Add(time.Second)
-				d.showControlBarBefore = &before
-			}
-			d.paused = !d.paused
-			d.updateSignal.Broadcast()
-			d.updateSignal.L.Unlock()
-		} else if leader == ',' || leader == '.' {
-			d.updateSignal.L.Lock()
-			d.paused = true
-			if leader == ',' && d.renderingFrameId > 0 {
-				// perv frame
-				d.renderingFrameId--
-			} else if leader == '.' && d.renderingFrameId < d.lastFrameId {
-				d.renderingFrameId++
-			}
-			d.updateSignal.Broadcast()
-			d.updateSignal.L.Unlock()
-		} else if leader == 'j' || leader == 'l' {
-			d.updateSignal.L.Lock()
-			_, currentTimeOffset := d.frameIdLookup(d.renderingFrameId)
-			if leader == 'j' {
-				currentTimeOffset -= 5
-			} else if leader == 'l' {
-				currentTimeOffset += 5
+		}
+	}
+}
+
+// handleKey acts on a key pressed in the player. It must be called with d.updateSignal.L locked.
+func (d *decoderState) handleKey(leader byte) {
+	if d.promptOpen {
+		d.handlePromptKey(leader)
+		return
+	}
+	d.searchMessage = ""
+	if leader == 0x1b && d.searching {
+		// cancels the search
+		d.searchGen++
+		d.searching = false
+		return
+	}
+	// A number typed before a command repeats it, like 10l. A 0 on its own goes to the start.
+	if leader >= '1' && leader <= '9' || leader == '0' && d.count > 0 {
+		d.count = d.count*10 + int(leader-'0')
+		if d.count > maxCount {
+			d.count = maxCount
+		}
+		return
+	}
+	count := d.count
+	d.count = 0
+	if count == 0 {
+		count = 1
+	}
+	if leader == '\x03' || leader == 'q' {
+		d.exiting = true
+	} else if leader == 12 {
+		d.nextTimeForceRedraw = true
+	} else if leader == ' ' || leader == 'k' {
+		if d.paused {
+			d.showControlBarFor(time.Second)
+		}
+		d.paused = !d.paused
+	} else if leader == ',' || leader == '.' {
+		d.paused = true
+		if leader == ',' {
+			// perv frame
+			if d.renderingFrameId > uint64(count) {
+				d.renderingFrameId -= uint64(count)
+			} else {
+				d.renderingFrameId = 0
 			}
-			nextFrameId, _ := d.searchForFrame(currentTimeOffset)
-			if d.renderingFrameId != nextFrameId {
-				d.renderingFrameId = nextFrameId
+		} else if leader == '.' {
+			d.renderingFrameId += uint64(count)
+			if d.renderingFrameId > d.lastFrameId {
+				d.renderingFrameId = d.lastFrameId
 			}
-			before := time.Now().Add(time.Second)
-			d.showControlBarBefore = &before
-			d.updateSignal.Broadcast()
-			d.updateSignal.L.Unlock()
-		} else if leader == '$' {
-			d.updateSignal.L.Lock()
-			d.renderingFrameId = d.lastFrameId
-			d.paused = true
-			d.updateSignal.Broadcast()
-			d.updateSignal.L.Unlock()
-		} else if leader == '^' || leader == '0' {
-			d.updateSignal.L.Lock()
-			d.renderingFrameId = 0
-			d.updateSignal.Broadcast()
-			d.updateSignal.L.Unlock()
 		}
+	} else if leader == 'j' || leader == 'l' {
+		_, currentTimeOffset := d.frameIdLookup(d.renderingFrameId)
+		if leader == 'j' {
+			currentTimeOffset -= d.seekStep * float64(count)
+		} else if leader == 'l' {
+			currentTimeOffset += d.seekStep * float64(count)
+		}
+		nextFrameId, _ := d.searchForFrame(currentTimeOffset)
+		if d.renderingFrameId != nextFrameId {
+			d.renderingFrameId = nextFrameId
+		}
+		d.showControlBarFor(time.Second)
+	} else if leader == '+' || leader == '=' || leader == '-' {
+		// each step doubles or halves the speed, and it takes 6 to go from one end to the other
+		steps := math.Min(float64(count), 6)
+		if leader == '-' {
+			d.speed = math.Max(d.speed/math.Pow(2, steps), minPlaySpeed)
+		} else {
+			d.speed = math.Min(d.speed*math.Pow(2, steps), maxPlaySpeed)
+		}
+		d.showControlBarFor(time.Second)
+	} else if leader == '$' {
+		d.renderingFrameId = d.lastFrameId
+		d.paused = true
+	} else if leader == '^' || leader == '0' {
+		d.renderingFrameId = 0
+	} else if leader == 'g' || leader == '/' {
+		d.promptOpen = true
+		d.promptKind = leader
+		d.prompt = d.prompt[:0]
+		d.promptError = ""
+	} else if (leader == 'n' || leader == 'N') && d.search != nil {
+		d.startSearch(leader == 'N')
+	}
+}
+
+func (d *decoderState) showControlBarFor(duration time.Duration) {
+	before := time.Now().Add(duration)
+	d.showControlBarBefore = &before
+}
+
+// screenTime is how long a frame lasting duration in the recording stays on screen. It must be called with
+// d.updateSignal.L locked.
+func (d *decoderState) screenTime(duration float64) time.Duration {
+	if d.idleLimit > 0 && duration > d.idleLimit {
+		duration = d.idleLimit
 	}
+	return time.Duration(duration / d.speed * float64(time.Second))
 }
 
 func (d *decoderState) loadFramesThread() {
-	var frameCacheSize uint64 = 10
 	for {
 		d.updateSignal.L.Lock()
 		d.updateSignal.Wait()
@@ -635,6 +649,8 @@ func (d *decoderState) loadFramesThread() {
 			d.updateSignal.L.Unlock()
 			return
 		}
+		// frames go by faster when sped up, so more of them are loaded ahead to keep up
+		frameCacheSize := uint64(math.Ceil(prefetchFrames * math.Max(d.speed, 1)))
 		updated := false
 		currentFrameId := d.renderingFrameId
 		d.renderCacheLock.Lock()
@@ -1,7 +1,11 @@
+//go:build !js
+// +build !js
+
 package main
 
 import (
 	"testing"
+	"time"
 )
 
 func Test_decoderState_searchForFrame(t *testing.T) {
@@ -41,3 +45,36 @@ func Test_decoderState_searchForFrame(t *testing.T) {
 		}
 	})
 }
+
+func Test_decoderState_speed(t *testing.T) {
+	d := newTestPlayer()
+	steps := []struct {
+		keys string
+		want float64
+	}{
+		{"+", 2},
+		{"=", 4},
+		{"-", 2},
+		{"9+", 16},
+		{"3-", 2},
+		{"10-", 0.25},
+		{"999999999999999999999+", 16},
+	}
+	for _, s := range steps {
+		d.typeKeys(s.keys)
+		if d.speed != s.want {
+			t.Errorf("after %q: speed %v, expected %v", s.keys, d.speed, s.want)
+		}
+	}
+	d.speed = 4
+	if got := d.screenTime(10); got != 2500*time.Millisecond {
+		t.Errorf("screenTime(10) at 4x = %v", got)
+	}
+	d.idleLimit = 2
+	if got := d.screenTime(10); got != 500*time.Millisecond {
+		t.Errorf("screenTime(10) at 4x with an idle limit of 2s = %v", got)
+	}
+	if got := d.screenTime(1); got != 250*time.Millisecond {
+		t.Errorf("screenTime(1) at 4x with an idle limit of 2s = %v", got)
+	}
+}
@@ -0,0 +1,222 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"runtime"
+	"sync"
+)
+
+// The glyph cache is dropped when it grows past this many entries, which only happens with recordings
+// using lots of different RGB colors.
+const glyphCacheLimit = 1 << 16
+
+// No character below this is double width.
+const wideRuneMin = 0x1100
+
+// cellRasterizer draws frame content onto an image, one character cell at a time. Each distinct
+// (characters, bold, fg, bg) cell is rendered once and then copied, and rows are drawn in parallel.
+type cellRasterizer struct {
+	cellWidth, cellHeight int
+	baseOff               image.Point
+	underlineY            int
+	underlineThickness    int
+	mediumFontFace        font.Face
+	boldFontFace          font.Face
+	fonts                 *fontSet
+	drawer                font.Drawer
+
+	// Font faces are not safe for concurrent use, so glyphs are rendered with cacheLock held for writing.
+	cacheLock  sync.RWMutex
+	glyphCache map[glyphKey]*image.RGBA
+}
+
+type glyphKey struct {
+	chars     string
+	bold      bool
+	underline bool
+	fg, bg    color.RGBA
+}
+
+func newCellRasterizer(opt options) *cellRasterizer {
+	return newCellRasterizerFromFonts(loadFonts(opt))
+}
+
+func newCellRasterizerFromFonts(fonts *fontSet) *cellRasterizer {
+	r := &cellRasterizer{}
+	r.fonts = fonts
+	r.mediumFontFace = fonts.medium
+	r.boldFontFace = fonts.bold
+	const set string = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	bound, _ := font.BoundBytes(r.mediumFontFace, []byte(set))
+	log("%v", bound)
+	var width = bound.Max.X - bound.Min.X
+	var height = bound.Max.Y - bound.Min.Y
+	r.cellWidth = width.Ceil() / len(set)
+	r.cellHeight = height.Ceil()
+	r.baseOff.X = -bound.Min.X.Round()
+	r.baseOff.Y = -bound.Max.Y.Round()
+	r.underlineThickness = r.cellHeight / 16
+	if r.underlineThickness < 1 {
+		r.underlineThickness = 1
+	}
+	// just below the baseline, but within the cell
+	r.underlineY = r.cellHeight + r.baseOff.Y + r.underlineThickness
+	if r.underlineY+r.underlineThickness > r.cellHeight {
+		r.underlineY = r.cellHeight - r.underlineThickness
+	}
+	r.drawer = font.Drawer{
+		Src:  nil,
+		Face: r.mediumFontFace,
+		Dot:  fixed.Point26_6{},
+	}
+	r.glyphCache = make(map[glyphKey]*image.RGBA)
+	return r
+}
+
+func (r *cellRasterizer) cellRect(row, col int) image.Rectangle {
+	return image.Rect(col*r.cellWidth, row*r.cellHeight, (col+1)*r.cellWidth, (row+1)*r.cellHeight)
+}
+
+// glyph returns the rendered image of a cell, whose colors must already be RGB (i.e. decoded with a color
+// profile). The returned image must not be modified.
+func (r *cellRasterizer) glyph(frameCell *frameCell) *image.RGBA {
+	if !frameCell.style.bg.IsRGB() || !frameCell.style.fg.IsRGB() {
+		panic(fmt.Sprintf("No color profile provided, but the recording does not encode color. Can't convert to video."))
+	}
+	fg, bg := cellRGB(frameCell)
+	key := glyphKey{string(frameCell.chars), frameCell.style.bold, frameCell.style.underline, fg, bg}
+	r.cacheLock.RLock()
+	img, ok := r.glyphCache[key]
+	r.cacheLock.RUnlock()
+	if ok {
+		return img
+	}
+
+	r.cacheLock.Lock()
+	defer r.cacheLock.Unlock()
+	if img, ok = r.glyphCache[key]; ok {
+		return img
+	}
+	// Glyphs more than one and a half cells wide are given two cells, which is what the terminal does for
+	// them, so that CJK characters from fallback fonts aren't cut in half.
+	var width = r.cellWidth
+	var faces = make([]font.Face, len(frameCell.chars))
+	for i, c := range frameCell.chars {
+		faces[i] = r.fonts.faceFor(c, frameCell.style.bold)
+	}
+	if len(faces) > 0 && frameCell.chars[0] >= wideRuneMin {
+		if adv, ok := faces[0].GlyphAdvance(frameCell.chars[0]); ok && adv.Ceil()*2 > r.cellWidth*3 {
+			width = 2 * r.cellWidth
+		}
+	}
+	img = image.NewRGBA(image.Rect(0, 0, width, r.cellHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	r.drawer.Dst = img
+	r.drawer.Src = image.NewUniform(fg)
+	r.drawer.Dot = fixed.P(r.baseOff.X, r.cellHeight+r.baseOff.Y)
+	for i, c := range frameCell.chars {
+		r.drawer.Face = faces[i]
+		r.drawer.DrawString(string(c))
+	}
+	if key.underline {
+		underline := image.Rect(0, r.underlineY, width, r.underlineY+r.underlineThickness)
+		draw.Draw(img, underline, r.drawer.Src, image.Point{}, draw.Src)
+	}
+	if len(r.glyphCache) >= glyphCacheLimit {
+		r.glyphCache = make(map[glyphKey]*image.RGBA)
+	}
+	r.glyphCache[key] = img
+	return img
+}
+
+// isWide tells whether the cell is drawn over two cells.
+func (r *cellRasterizer) isWide(frameCell *frameCell) bool {
+	return len(frameCell.chars) > 0 && frameCell.chars[0] >= wideRuneMin && r.glyph(frameCell).Bounds().Dx() > r.cellWidth
+}
+
+// drawCell copies the rendered cell onto canvas, clipped to its bounds. Cells are positioned relative to
+// the top-left of canvas, which can be a sub-image.
+func (r *cellRasterizer) drawCell(canvas *image.RGBA, row, col int, frameCell *frameCell) {
+	glyph := r.glyph(frameCell)
+	origin := r.cellRect(row, col).Min.Add(canvas.Rect.Min)
+	cellRect := image.Rectangle{origin, origin.Add(glyph.Rect.Size())}.Intersect(canvas.Rect)
+	if cellRect.Empty() {
+		return
+	}
+	rowBytes := cellRect.Dx() * 4
+	for y := cellRect.Min.Y; y < cellRect.Max.Y; y++ {
+		dst := canvas.PixOffset(cellRect.Min.X, y)
+		src := glyph.PixOffset(cellRect.Min.X-origin.X, y-origin.Y)
+		copy(canvas.Pix[dst:dst+rowBytes], glyph.Pix[src:src+rowBytes])
+	}
+}
+
+// drawFrame draws the top-left rows x cols cells of fcontent. If perv is not nil, only cells which differ
+// from perv are drawn. Bands of rows are drawn in parallel. There is no cursor to draw, since ITSFrame doesn't
+// store its position.
+func (r *cellRasterizer) drawFrame(canvas *image.RGBA, perv, fcontent frameContent, frameSize sizeStruct, rows, cols int) {
+	if rows > frameSize.rows {
+		rows = frameSize.rows
+	}
+	if cols > frameSize.cols {
+		cols = frameSize.cols
+	}
+	bands := runtime.NumCPU()
+	if bands > rows {
+		bands = rows
+	}
+	var wg sync.WaitGroup
+	for band := 0; band < bands; band++ {
+		wg.Add(1)
+		go func(startRow, endRow int) {
+			defer wg.Done()
+			for row := startRow; row < endRow; row++ {
+				// set when the previous cell was drawn over this one in perv, but isn't anymore
+				var uncovered bool
+				for col := 0; col < cols; col++ {
+					var frameCell = fcontent.getCellAt(row, col, &frameSize)
+					var wide = col+1 < cols && r.isWide(frameCell)
+					var changed = perv == nil || uncovered || !perv.getCellAt(row, col, &frameSize).equalsTo(frameCell) ||
+						(wide && !perv.getCellAt(row, col+1, &frameSize).equalsTo(fcontent.getCellAt(row, col+1, &frameSize)))
+					uncovered = false
+					if changed {
+						r.drawCell(canvas, row, col, frameCell)
+						uncovered = !wide && perv != nil && col+1 < cols && r.isWide(perv.getCellAt(row, col, &frameSize))
+					}
+					if wide {
+						// the next cell is covered by this one
+						col++
+					}
+				}
+			}
+		}(band*rows/bands, (band+1)*rows/bands)
+	}
+	wg.Wait()
+}
+
+// appendRGB appends the pixels of canvas to buf as packed 24-bit RGB.
+func appendRGB(buf []byte, canvas *image.RGBA) []byte {
+	bd := canvas.Bounds()
+	width := bd.Dx()
+	start := len(buf)
+	buf = append(buf, make([]byte, width*bd.Dy()*3)...)
+	out := buf[start:]
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		pix := canvas.Pix[canvas.PixOffset(bd.Min.X, y):]
+		row := out[(y-bd.Min.Y)*width*3:]
+		for x := 0; x < width; x++ {
+			row[x*3] = pix[x*4]
+			row[x*3+1] = pix[x*4+1]
+			row[x*3+2] = pix[x*4+2]
+		}
+	}
+	return buf
+}
@@ -0,0 +1,173 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"github.com/golang/freetype/truetype"
+	"github.com/micromaomao/go-libvterm"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+func newTestRasterizer(t testing.TB) *cellRasterizer {
+	fonts := make([]*truetype.Font, 0, 2)
+	for _, ttf := range [][]byte{gomono.TTF, gomonobold.TTF} {
+		f, err := truetype.Parse(ttf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fonts = append(fonts, f)
+	}
+	return newCellRasterizerFromFonts(newFontSet(fonts[0], fonts[1], 11, 96))
+}
+
+// randRGBFrameContent returns content with a few different colors, with cells changed from perv with
+// probability changeRate.
+func randRGBFrameContent(r *rand.Rand, size sizeStruct, perv frameContent, changeRate float64) frameContent {
+	fc := make(frameContent, size.rows*size.cols)
+	for i := range fc {
+		if perv != nil && r.Float64() >= changeRate {
+			fc[i] = perv[i]
+			continue
+		}
+		fc[i].chars = []rune{rune('!' + r.Intn(90))}
+		fc[i].style.fg = vterm.NewVTermColorRGB(color.RGBA{uint8(r.Intn(2) * 255), 200, 200, 255})
+		fc[i].style.bg = vterm.NewVTermColorRGB(color.RGBA{0, 0, uint8(r.Intn(2) * 100), 255})
+		fc[i].style.bold = r.Intn(4) == 0
+	}
+	return fc
+}
+
+// testCell returns a cell with c in white on black.
+func testCell(c rune) frameCell {
+	fc := frameCell{chars: []rune{c}}
+	fc.style.fg = vterm.NewVTermColorRGB(color.RGBA{255, 255, 255, 255})
+	fc.style.bg = vterm.NewVTermColorRGB(color.RGBA{0, 0, 0, 255})
+	return fc
+}
+
+// cacheWideGlyph makes cell two cells wide when drawn by r, which the test fonts have no glyph for.
+func cacheWideGlyph(r *cellRasterizer, cell *frameCell) {
+	img := image.NewRGBA(image.Rect(0, 0, 2*r.cellWidth, r.cellHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	fg, bg := cellRGB(cell)
+	r.glyphCache[glyphKey{string(cell.chars), cell.style.bold, cell.style.underline, fg, bg}] = img
+}
+
+// drawFrameDirect draws every cell directly with the font, without caching.
+func drawFrameDirect(r *cellRasterizer, canvas *image.RGBA, fcontent frameContent, size sizeStruct) {
+	for row := 0; row < size.rows; row++ {
+		for col := 0; col < size.cols; col++ {
+			cell := fcontent.getCellAt(row, col, &size)
+			fg, bg := cellRGB(cell)
+			rect := r.cellRect(row, col)
+			cellImg := image.NewRGBA(rect)
+			draw.Draw(cellImg, rect, image.NewUniform(bg), image.Point{}, draw.Src)
+			face := r.mediumFontFace
+			if cell.style.bold {
+				face = r.boldFontFace
+			}
+			d := font.Drawer{Dst: cellImg, Src: image.NewUniform(fg), Face: face, Dot: fixed.P(rect.Min.X+r.baseOff.X, rect.Max.Y+r.baseOff.Y)}
+			d.DrawString(string(cell.chars))
+			draw.Draw(canvas, rect, cellImg, rect.Min, draw.Src)
+		}
+	}
+}
+
+func Test_cellRasterizer_drawFrame(t *testing.T) {
+	r := newTestRasterizer(t)
+	size := sizeStruct{rows: 13, cols: 37}
+	rnd := rand.New(rand.NewSource(1))
+	bounds := image.Rect(0, 0, size.cols*r.cellWidth, size.rows*r.cellHeight)
+	canvas := image.NewRGBA(bounds)
+	var perv frameContent
+	for i := 0; i < 5; i++ {
+		content := randRGBFrameContent(rnd, size, perv, 0.3)
+		r.drawFrame(canvas, perv, content, size, size.rows, size.cols)
+		expected := image.NewRGBA(bounds)
+		drawFrameDirect(r, expected, content, size)
+		if !bytes.Equal(canvas.Pix, expected.Pix) {
+			t.Fatalf("frame %v differs from uncached rendering", i)
+		}
+		perv = content
+	}
+
+	// a wide character replaced by a narrow one, with the cell it covered unchanged
+	size = sizeStruct{rows: 1, cols: 3}
+	bounds = image.Rect(0, 0, size.cols*r.cellWidth, size.rows*r.cellHeight)
+	wide := testCell('中')
+	cacheWideGlyph(r, &wide)
+	perv = frameContent{wide, testCell(' '), testCell('x')}
+	content := frameContent{testCell('a'), testCell(' '), testCell('x')}
+	canvas = image.NewRGBA(bounds)
+	r.drawFrame(canvas, nil, perv, size, size.rows, size.cols)
+	r.drawFrame(canvas, perv, content, size, size.rows, size.cols)
+	expected := image.NewRGBA(bounds)
+	r.drawFrame(expected, nil, content, size, size.rows, size.cols)
+	if !bytes.Equal(canvas.Pix, expected.Pix) {
+		t.Errorf("right half of a replaced wide character left on the canvas")
+	}
+}
+
+func Benchmark_cellRasterizer_drawFrame(b *testing.B) {
+	r := newTestRasterizer(b)
+	size := sizeStruct{rows: 60, cols: 160}
+	rnd := rand.New(rand.NewSource(1))
+	canvas := image.NewRGBA(image.Rect(0, 0, size.cols*r.cellWidth, size.rows*r.cellHeight))
+	contents := make([]frameContent, 20)
+	for i := range contents {
+		var perv frameContent
+		if i > 0 {
+			perv = contents[i-1]
+		}
+		contents[i] = randRGBFrameContent(rnd, size, perv, 0.1)
+	}
+	b.Run("direct", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			drawFrameDirect(r, canvas, contents[i%len(contents)], size)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		var perv frameContent
+		for i := 0; i < b.N; i++ {
+			content := contents[i%len(contents)]
+			r.drawFrame(canvas, perv, content, size, size.rows, size.cols)
+			perv = content
+		}
+	})
+	b.Run("appendRGB", func(b *testing.B) {
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			buf = appendRGB(buf[:0], canvas)
+		}
+	})
+}
+
+func Test_cellRasterizer_underline(t *testing.T) {
+	r := newTestRasterizer(t)
+	size := sizeStruct{rows: 1, cols: 2}
+	content := make(frameContent, 2)
+	for i := range content {
+		content[i].chars = []rune{' '}
+		content[i].style.fg = vterm.NewVTermColorRGB(color.RGBA{255, 255, 255, 255})
+		content[i].style.bg = vterm.NewVTermColorRGB(color.RGBA{0, 0, 0, 255})
+	}
+	content[1].style.underline = true
+	canvas := image.NewRGBA(image.Rect(0, 0, 2*r.cellWidth, r.cellHeight))
+	r.drawFrame(canvas, nil, content, size, 1, 2)
+	if c := canvas.RGBAAt(r.cellWidth/2, r.underlineY); c.R != 0 {
+		t.Errorf("cell without underline has %v at underline position", c)
+	}
+	if c := canvas.RGBAAt(r.cellWidth+r.cellWidth/2, r.underlineY); c.R != 255 {
+		t.Errorf("underlined cell has %v at underline position", c)
+	}
+}
@@ -0,0 +1,84 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"github.com/micromaomao/go-libvterm"
+	"image/color"
+	"os"
+)
+
+// recolorMap maps the colors of one color profile to the colors of another. A recording made with -c only
+// has RGB colors, so there's no telling which palette entry a color came from other than by its value.
+type recolorMap struct {
+	fg, bg map[color.RGBA]color.RGBA
+}
+
+func newRecolorMap(from, to *colorProfile) *recolorMap {
+	m := &recolorMap{make(map[color.RGBA]color.RGBA), make(map[color.RGBA]color.RGBA)}
+	// When a color appears more than once in the palette, the lowest index wins, since the basic 16 colors
+	// are used much more often than their equivalents in the 256 color cube.
+	for i := len(from.palette) - 1; i >= 0; i-- {
+		m.fg[from.palette[i]] = to.palette[i]
+		m.bg[from.palette[i]] = to.palette[i]
+	}
+	// The default colors win over the palette, as themes often reuse them as palette entries.
+	m.addDefaultColors(from.fg, from.bg, to)
+	return m
+}
+
+// newDefaultColorsMap maps the default colors of a recording made without a color profile to those of to.
+// Everything else is stored as indexed colors, which don't need mapping.
+func newDefaultColorsMap(to *colorProfile) *recolorMap {
+	m := &recolorMap{make(map[color.RGBA]color.RGBA), make(map[color.RGBA]color.RGBA)}
+	m.addDefaultColors(noProfileDefaultFg, noProfileDefaultBg, to)
+	return m
+}
+
+// addDefaultColors maps fg and bg to the default colors of to. Reverse video swaps them, so each is also
+// mapped on the other side, unless the two are the same color.
+func (m *recolorMap) addDefaultColors(fg, bg color.RGBA, to *colorProfile) {
+	m.fg[bg] = to.bg
+	m.bg[fg] = to.fg
+	m.fg[fg] = to.fg
+	m.bg[bg] = to.bg
+}
+
+func mapVTermColor(c vterm.VTermColor, m map[color.RGBA]color.RGBA) vterm.VTermColor {
+	if !c.IsRGB() {
+		return c
+	}
+	r, g, b, _ := c.GetRGB()
+	if to, ok := m[color.RGBA{r, g, b, 255}]; ok {
+		return vterm.NewVTermColorRGB(to)
+	}
+	return c
+}
+
+func (m *recolorMap) mapCell(c *frameCell) {
+	c.style.fg = mapVTermColor(c.style.fg, m.fg)
+	c.style.bg = mapVTermColor(c.style.bg, m.bg)
+}
+
+// doOpRecolor rewrites a recording with the colors of another profile. Indexed colors are resolved with the
+// profile given by -c, and if --from is given, RGB colors of that profile are changed to those of -c.
+// Otherwise the recording is assumed to have been made without -c, and its default colors are changed.
+func doOpRecolor(opt options) {
+	to, err := loadColorProfile(opt.colorProfileInput)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err.Error())
+		os.Exit(1)
+	}
+	mapCell := newDefaultColorsMap(&to).mapCell
+	if opt.recolorFrom != "" {
+		from, err := loadColorProfile(opt.recolorFrom)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err.Error())
+			os.Exit(1)
+		}
+		mapCell = newRecolorMap(&from, &to).mapCell
+	}
+	reencodeITS(opt, &to, mapCell)
+}
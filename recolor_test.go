@@ -0,0 +1,67 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"github.com/micromaomao/go-libvterm"
+	"image/color"
+	"testing"
+)
+
+func Test_recolorMap(t *testing.T) {
+	from, _ := builtinColorProfile("solarized-dark")
+	to, _ := builtinColorProfile("solarized-light")
+	m := newRecolorMap(&from, &to)
+	rgb := func(c vterm.VTermColor) color.RGBA {
+		r, g, b, _ := c.GetRGB()
+		return color.RGBA{r, g, b, 255}
+	}
+	tests := []struct {
+		name           string
+		fg, bg         vterm.VTermColor
+		wantFg, wantBg color.RGBA
+	}{
+		{"default colors", vterm.NewVTermColorRGB(from.fg), vterm.NewVTermColorRGB(from.bg), to.fg, to.bg},
+		{"reverse video", vterm.NewVTermColorRGB(from.bg), vterm.NewVTermColorRGB(from.fg), to.bg, to.fg},
+		{"palette", vterm.NewVTermColorRGB(from.palette[1]), vterm.NewVTermColorRGB(from.palette[4]), to.palette[1], to.palette[4]},
+		{"unknown color", vterm.NewVTermColorRGB(color.RGBA{1, 2, 3, 255}), vterm.NewVTermColorRGB(from.bg), color.RGBA{1, 2, 3, 255}, to.bg},
+	}
+	for _, tt := range tests {
+		c := frameCell{}
+		c.style.fg = tt.fg
+		c.style.bg = tt.bg
+		m.mapCell(&c)
+		if rgb(c.style.fg) != tt.wantFg || rgb(c.style.bg) != tt.wantBg {
+			t.Errorf("%v: got fg %v bg %v, expected %v %v", tt.name, rgb(c.style.fg), rgb(c.style.bg), tt.wantFg, tt.wantBg)
+		}
+	}
+
+	// indexed colors are left for attrCode to resolve
+	c := frameCell{}
+	c.style.fg = vterm.NewVTermColorIndexed(3)
+	c.style.bg = vterm.NewVTermColorIndexed(0)
+	m.mapCell(&c)
+	if c.style.fg.IsRGB() || c.style.bg.IsRGB() {
+		t.Errorf("indexed colors were changed")
+	}
+	if a := unpackAttrCode(c.attrCode(&to)); a.fgIndexed || a.fg != to.palette[3] || a.bg != to.palette[0] {
+		t.Errorf("attrCode resolved indexed colors to %v %v", a.fg, a.bg)
+	}
+
+	// without --from, only the default colors of a recording made without -c are changed
+	m = newDefaultColorsMap(&to)
+	c = frameCell{}
+	c.style.fg = vterm.NewVTermColorRGB(noProfileDefaultFg)
+	c.style.bg = vterm.NewVTermColorRGB(noProfileDefaultBg)
+	m.mapCell(&c)
+	if rgb(c.style.fg) != to.fg || rgb(c.style.bg) != to.bg {
+		t.Errorf("default colors mapped to %v %v, expected %v %v", rgb(c.style.fg), rgb(c.style.bg), to.fg, to.bg)
+	}
+	c.style.fg = vterm.NewVTermColorRGB(noProfileDefaultBg)
+	c.style.bg = vterm.NewVTermColorRGB(from.palette[1])
+	m.mapCell(&c)
+	if rgb(c.style.fg) != to.bg || rgb(c.style.bg) != from.palette[1] {
+		t.Errorf("reverse video and other colors mapped to %v %v", rgb(c.style.fg), rgb(c.style.bg))
+	}
+}
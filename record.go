@@ -1,3 +1,6 @@
+//go:build !js
+// +build !js
+
 package main
 
 import (
@@ -26,6 +29,7 @@ type recorderState struct {
 	termInitAttr  *syscall.Termios
 	startTime     time.Time
 	quiet         bool
+	recordInput   bool
 
 	lastFrameId     uint64
 	lastTime        time.Time
@@ -75,7 +79,7 @@ func doOpRecord(opt options) {
 	fOut.Seek(0, os.SEEK_SET)
 	var cf *colorProfile = nil
 	if opt.colorProfileInput != "" {
-		_cf, err := processColorProfile(opt.colorProfileInput)
+		_cf, err := loadColorProfile(opt.colorProfileInput)
 		if err == nil {
 			cf = &_cf
 		} else {
@@ -91,6 +95,7 @@ func doOpRecord(opt options) {
 	e.size.rows = opt.bufferSize.rows
 	e.size.cols = opt.bufferSize.cols
 	e.translateColor = cf
+	e.title = opt.title
 	e.resetVT()
 	e.dict = nil
 	e.cdict = nil
@@ -105,6 +110,7 @@ func doOpRecord(opt options) {
 		fmt.Fprintf(os.Stdout, "Recording started. Exit the shell to end.\n")
 	}
 	r.quiet = opt.quiet
+	r.recordInput = opt.recordInput
 	r.master = master
 	r.slave = slave
 	initTermSize := termGetSize()
@@ -134,7 +140,7 @@ func doOpRecord(opt options) {
 	r.process = proc
 	r.finalWorkLock = &sync.Mutex{}
 	r.frameBufferLock = &sync.Mutex{}
-	r.signalChannel = make(chan os.Signal)
+	r.signalChannel = make(chan os.Signal, 1)
 	signal.Notify(r.signalChannel, syscall.SIGWINCH, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 	go r.signalHandlerThread()
 	go r.stdinReader()
@@ -182,13 +188,22 @@ func (r *recorderState) doFinalWorkAndExit() {
 
 func (r *recorderState) stdinReader() {
 	defer r.exitWhenPanic()
+	readBuf := make([]byte, 1000000)
 	for {
-		buf := make([]byte, 1000000)
-		n, _ := os.Stdin.Read(buf)
+		n, _ := os.Stdin.Read(readBuf)
 		if n == 0 {
 			continue
 		}
-		buf = buf[0:n]
+		buf := readBuf[0:n]
+		if r.recordInput {
+			r.frameBufferLock.Lock()
+			r.encoder.index.Input = append(r.encoder.index.Input, &ITSIndex_InputEvent{
+				TimeOffset: float64(time.Now().Sub(r.startTime)) / float64(time.Second),
+				// readBuf is reused for the next read
+				Data: append([]byte(nil), buf...),
+			})
+			r.frameBufferLock.Unlock()
+		}
 		written := 0
 		for written < n {
 			nowWritten, err := r.master.Write(buf[written:])
@@ -259,6 +274,7 @@ func (r *recorderState) frameWriterThread(fps int) {
 		r.finalWorkLock.Lock()
 		termSize := termGetSize()
 		r.lastCt = r.encoder.inputToFrameContentSize(nData, termSize)
+		r.encoder.markers.scan(nData, float64(now.Sub(r.startTime))/float64(time.Second))
 		r.finalWorkLock.Unlock()
 	}
 }
@@ -0,0 +1,225 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Layout of contact sheets, in px.
+const (
+	sheetPadding     = 8
+	sheetLabelHeight = 16
+)
+
+// Fraction of the visible cells which must change before another thumbnail is taken in --changes mode.
+const sheetChangeThreshold = 0.2
+
+type screenshotFrame struct {
+	time    float64
+	content frameContent
+}
+
+func doOpScreenshot(opt options) {
+	d := initPlayer(opt)
+	if d.translateColor == nil {
+		cf := xtermColorProfile()
+		d.translateColor = &cf
+	}
+	var img image.Image
+	if opt.contactSheet > 0 {
+		img = renderContactSheet(d, opt)
+	} else {
+		frameId, _ := d.searchForFrame(opt.at)
+		f := d.readScreenshotFrame(frameId)
+		extent := opt.bufferSize
+		if !opt.bufferSizeSet {
+			extent = sizeStruct{}
+			d.growExtent(f.content, blankStyleOf(f.content, &d.frameSize), &extent)
+		}
+		img = renderScreenshot(newCellRasterizer(opt), d, []screenshotFrame{f}, extent)[0]
+	}
+	writeImage(img, opt.imageOutput)
+}
+
+func (d *decoderState) readScreenshotFrame(frameId uint64) screenshotFrame {
+	byteOffset, _ := d.frameIdLookup(frameId)
+	finfo, content, err, _ := d.readFrameFromOffset(byteOffset)
+	if err != nil {
+		panic(err)
+	}
+	return screenshotFrame{finfo.time, content}
+}
+
+func renderScreenshot(r *cellRasterizer, d *decoderState, frames []screenshotFrame, extent sizeStruct) []*image.RGBA {
+	if extent.rows == 0 || extent.cols == 0 {
+		extent = sizeStruct{1, 1}
+	}
+	imgs := make([]*image.RGBA, 0, len(frames))
+	for _, f := range frames {
+		canvas := image.NewRGBA(image.Rect(0, 0, extent.cols*r.cellWidth, extent.rows*r.cellHeight))
+		r.drawFrame(canvas, nil, f.content, d.frameSize, extent.rows, extent.cols)
+		imgs = append(imgs, canvas)
+	}
+	return imgs
+}
+
+// selectSheetFrames picks the frames to show on a contact sheet, either evenly spaced in time, or whenever
+// a significant part of the screen has changed since the last picked frame.
+func selectSheetFrames(d *decoderState, opt options) (frames []screenshotFrame, extent sizeStruct) {
+	startTime := opt.startTime
+	endTime := opt.endTime
+	if opt.duration > 0 {
+		endTime = startTime + opt.duration
+	}
+	firstFrameId, _ := d.searchForFrame(startTime)
+	lastFrameId := d.lastFrameId
+	if endTime > 0 {
+		lastFrameId, _ = d.searchForFrame(endTime)
+	}
+	blankStyle := blankStyleOf(d.readScreenshotFrame(0).content, &d.frameSize)
+	if !opt.sheetChanges {
+		firstTime := d.readScreenshotFrame(firstFrameId).time
+		lastTime := d.readScreenshotFrame(lastFrameId).time
+		var lastPicked uint64
+		for i := 0; i < opt.contactSheet; i++ {
+			t := firstTime
+			if opt.contactSheet > 1 {
+				t += (lastTime - firstTime) * float64(i) / float64(opt.contactSheet-1)
+			}
+			frameId, _ := d.searchForFrame(t)
+			if i > 0 && frameId == lastPicked {
+				continue
+			}
+			lastPicked = frameId
+			f := d.readScreenshotFrame(frameId)
+			d.growExtent(f.content, blankStyle, &extent)
+			frames = append(frames, f)
+		}
+		return
+	}
+
+	// Only the last picked frame is kept decoded, and the picked ones are read again at the end, since there
+	// can be a lot of changes in a long recording. Cells outside of the extent are blank in every frame, so
+	// comparing whole frames counts the same changes as comparing within the extent.
+	var last frameContent
+	var changedFrames []uint64
+	for i := firstFrameId; i <= lastFrameId; i++ {
+		f := d.readScreenshotFrame(i)
+		if !opt.bufferSizeSet {
+			d.growExtent(f.content, blankStyle, &extent)
+		} else {
+			extent = opt.bufferSize
+		}
+		if i%10 == 0 {
+			fmt.Fprintf(os.Stderr, "\r\033[2KScanning frame %v / %v", i, lastFrameId+1)
+		}
+		if last != nil {
+			changed := 0
+			for row := 0; row < extent.rows && row < d.frameSize.rows; row++ {
+				for col := 0; col < extent.cols && col < d.frameSize.cols; col++ {
+					if !f.content.getCellAt(row, col, &d.frameSize).equalsTo(last.getCellAt(row, col, &d.frameSize)) {
+						changed++
+					}
+				}
+			}
+			// measured against the extent of what was shown so far
+			if float64(changed) < sheetChangeThreshold*float64(extent.rows*extent.cols) {
+				continue
+			}
+		}
+		last = f.content
+		changedFrames = append(changedFrames, i)
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[2K%v frames with significant changes\n", len(changedFrames))
+	if len(changedFrames) > opt.contactSheet {
+		picked := make([]uint64, 0, opt.contactSheet)
+		for i := 0; i < opt.contactSheet; i++ {
+			j := 0
+			if opt.contactSheet > 1 {
+				j = i * (len(changedFrames) - 1) / (opt.contactSheet - 1)
+			}
+			picked = append(picked, changedFrames[j])
+		}
+		changedFrames = picked
+	}
+	for _, frameId := range changedFrames {
+		frames = append(frames, d.readScreenshotFrame(frameId))
+	}
+	return
+}
+
+func renderContactSheet(d *decoderState, opt options) image.Image {
+	frames, extent := selectSheetFrames(d, opt)
+	if opt.bufferSizeSet {
+		extent = opt.bufferSize
+	}
+	shots := renderScreenshot(newCellRasterizer(opt), d, frames, extent)
+	fullSize := shots[0].Bounds().Size()
+	thumbWidth := opt.thumbWidth
+	thumbHeight := fullSize.Y * thumbWidth / fullSize.X
+	if thumbHeight < 1 {
+		thumbHeight = 1
+	}
+	columns := opt.sheetColumns
+	if columns > len(shots) {
+		columns = len(shots)
+	}
+	rows := (len(shots) + columns - 1) / columns
+	cellW := thumbWidth + sheetPadding
+	cellH := thumbHeight + sheetLabelHeight + sheetPadding
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*cellW+sheetPadding, rows*cellH+sheetPadding))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.RGBA{0x20, 0x20, 0x20, 0xff}), image.Point{}, draw.Src)
+	drawer := font.Drawer{Dst: sheet, Src: image.NewUniform(color.RGBA{0xdd, 0xdd, 0xdd, 0xff}), Face: basicfont.Face7x13}
+	for i, shot := range shots {
+		x := sheetPadding + (i%columns)*cellW
+		y := sheetPadding + (i/columns)*cellH
+		thumbRect := image.Rect(x, y, x+thumbWidth, y+thumbHeight)
+		draw.CatmullRom.Scale(sheet, thumbRect, shot, shot.Bounds(), draw.Src, nil)
+		drawer.Dot = fixed.P(x, y+thumbHeight+sheetLabelHeight-4)
+		drawer.DrawString(formatTimestamp(frames[i].time))
+	}
+	return sheet
+}
+
+// formatTimestamp formats seconds as [h:]mm:ss.s, accepted by parseTimestamp.
+func formatTimestamp(t float64) string {
+	tenths := int(t*10 + 0.5)
+	h := tenths / 36000
+	m := tenths / 600 % 60
+	s := float64(tenths%600) / 10
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%04.1f", h, m, s)
+	}
+	return fmt.Sprintf("%d:%04.1f", m, s)
+}
+
+// writeImage writes img as JPEG if filename ends with .jpg or .jpeg, otherwise as PNG.
+func writeImage(img image.Image, filename string) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		panic(fmt.Errorf("%v when opening %v for writing", err.Error(), filename))
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jpg", ".jpeg":
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 90})
+	default:
+		err = png.Encode(f, img)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,75 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_selectSheetFrames(t *testing.T) {
+	size := sizeStruct{rows: 3, cols: 20}
+	path := writeTestRecording(t, size,
+		testFrame(size, "$ echo hello"),
+		// one cell out of 12
+		testFrame(size, "$ echo hellO"),
+		// 6 cells out of 24, since the extent grew
+		testFrame(size, "$ echo hellO", "hellO"),
+		testFrame(size, "$ echo hellO", "hellO"),
+		testFrame(size, "$"))
+	defer os.Remove(path)
+	d := initPlayer(options{itsInput: path})
+	tests := []struct {
+		name       string
+		opt        options
+		wantTimes  []float64
+		wantExtent sizeStruct
+	}{
+		{"evenly spaced", options{contactSheet: 3}, []float64{0, 2, 4}, sizeStruct{2, 12}},
+		{"more thumbnails than frames", options{contactSheet: 9}, []float64{0, 1, 2, 3, 4}, sizeStruct{2, 12}},
+		{"time range", options{contactSheet: 2, startTime: 1, endTime: 3.5}, []float64{1, 3}, sizeStruct{2, 12}},
+		{"only the first thumbnail", options{contactSheet: 2, endTime: 1.5}, []float64{0, 1}, sizeStruct{1, 12}},
+		{"changes", options{contactSheet: 9, sheetChanges: true}, []float64{0, 2, 4}, sizeStruct{2, 12}},
+		{"changes, fewer thumbnails", options{contactSheet: 2, sheetChanges: true}, []float64{0, 4}, sizeStruct{2, 12}},
+		{"changes, fixed size", options{contactSheet: 9, sheetChanges: true, bufferSize: sizeStruct{1, 12}, bufferSizeSet: true}, []float64{0, 4}, sizeStruct{1, 12}},
+	}
+	for _, tt := range tests {
+		frames, extent := selectSheetFrames(d, tt.opt)
+		var times []float64
+		for _, f := range frames {
+			times = append(times, f.time)
+		}
+		if !reflect.DeepEqual(times, tt.wantTimes) || extent != tt.wantExtent {
+			t.Errorf("%v: got frames at %v in %v, expected %v in %v", tt.name, times, extent, tt.wantTimes, tt.wantExtent)
+		}
+	}
+
+	r := newTestRasterizer(t)
+	frames, extent := selectSheetFrames(d, options{contactSheet: 3})
+	for _, img := range renderScreenshot(r, d, frames, extent) {
+		if img.Bounds().Dx() != 12*r.cellWidth || img.Bounds().Dy() != 2*r.cellHeight {
+			t.Errorf("screenshot is %v", img.Bounds())
+		}
+	}
+}
+
+func Test_formatTimestamp(t *testing.T) {
+	tests := []struct {
+		t    float64
+		want string
+	}{
+		{0, "0:00.0"},
+		{61.25, "1:01.3"},
+		{3725.04, "1:02:05.0"},
+	}
+	for _, tt := range tests {
+		if got := formatTimestamp(tt.t); got != tt.want {
+			t.Errorf("formatTimestamp(%v) = %q, want %q", tt.t, got, tt.want)
+		}
+		if back, err := parseTimestamp(tt.want); err != nil || back-tt.t > 0.05 || tt.t-back > 0.05 {
+			t.Errorf("parseTimestamp(%q) = %v, %v", tt.want, back, err)
+		}
+	}
+}
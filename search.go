@@ -0,0 +1,313 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// rowText returns the text in a row of content, and for each rune of it the column of the cell it is in.
+func rowText(content frameContent, row int, size *sizeStruct) (text []rune, cols []int) {
+	text = make([]rune, 0, size.cols)
+	cols = make([]int, 0, size.cols)
+	for col := 0; col < size.cols; col++ {
+		for _, r := range content.getCellAt(row, col, size).chars {
+			// skip what vterm puts in the cell to the right of a wide character
+			if r == 0 || !utf8.ValidRune(r) {
+				continue
+			}
+			text = append(text, r)
+			cols = append(cols, col)
+		}
+	}
+	return
+}
+
+// frameText returns the text of each row of content, with trailing spaces removed.
+func frameText(content frameContent, size *sizeStruct) []string {
+	lines := make([]string, size.rows)
+	for row := 0; row < size.rows; row++ {
+		text, _ := rowText(content, row, size)
+		lines[row] = strings.TrimRight(string(text), " ")
+	}
+	return lines
+}
+
+// textIndexer builds the ITSTextIndex as frames are written in order.
+type textIndexer struct {
+	lineIds map[string]uint32
+	lines   []string
+	runs    []*ITSTextIndex_TextRun
+}
+
+func (t *textIndexer) add(frameId uint64, text []string) {
+	if t.lineIds == nil {
+		t.lineIds = make(map[string]uint32)
+	}
+	ids := make([]uint32, len(text))
+	for i, line := range text {
+		id, ok := t.lineIds[line]
+		if !ok {
+			id = uint32(len(t.lines))
+			t.lineIds[line] = id
+			t.lines = append(t.lines, line)
+		}
+		ids[i] = id
+	}
+	if len(t.runs) > 0 {
+		last := t.runs[len(t.runs)-1].Lines
+		if len(last) == len(ids) {
+			same := true
+			for i := range ids {
+				if ids[i] != last[i] {
+					same = false
+					break
+				}
+			}
+			if same {
+				return
+			}
+		}
+	}
+	t.runs = append(t.runs, &ITSTextIndex_TextRun{FirstFrameId: frameId, Lines: ids})
+}
+
+// searchPattern is what was typed at the / prompt. Like vim's smartcase, the search ignores case unless the
+// pattern has an uppercase letter in it.
+type searchPattern struct {
+	text       string
+	runes      []rune
+	ignoreCase bool
+}
+
+func newSearchPattern(s string) *searchPattern {
+	p := &searchPattern{text: s, ignoreCase: true}
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			p.ignoreCase = false
+		}
+	}
+	p.runes = p.fold([]rune(s))
+	return p
+}
+
+func (p *searchPattern) fold(text []rune) []rune {
+	if !p.ignoreCase {
+		return text
+	}
+	folded := make([]rune, len(text))
+	for i, r := range text {
+		folded[i] = unicode.ToLower(r)
+	}
+	return folded
+}
+
+// index returns where the pattern first appears in text at or after from, or -1. text must already be folded.
+func (p *searchPattern) index(text []rune, from int) int {
+	for i := from; i+len(p.runes) <= len(text); i++ {
+		matched := true
+		for j, r := range p.runes {
+			if text[i+j] != r {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *searchPattern) matchLine(line string) bool {
+	return len(p.runes) > 0 && p.index(p.fold([]rune(line)), 0) >= 0
+}
+
+// highlight returns which cells of content are part of a match, or nil if there is none.
+func (p *searchPattern) highlight(content frameContent, size *sizeStruct) []bool {
+	if len(p.runes) == 0 {
+		return nil
+	}
+	var mask []bool
+	for row := 0; row < size.rows; row++ {
+		text, cols := rowText(content, row, size)
+		text = p.fold(text)
+		for i := p.index(text, 0); i >= 0; i = p.index(text, i+1) {
+			if mask == nil {
+				mask = make([]bool, size.rows*size.cols)
+			}
+			for j := i; j < i+len(p.runes); j++ {
+				mask[row*size.cols+cols[j]] = true
+			}
+		}
+	}
+	return mask
+}
+
+// newMatch reports whether cur, the text of a frame, has a line with the pattern in it which was not on screen
+// in prev, the frame before it. Text that only scrolled up or down is not a new match.
+func (p *searchPattern) newMatch(prev, cur []string) bool {
+	var seen map[string]bool
+	for _, line := range cur {
+		if !p.matchLine(line) {
+			continue
+		}
+		if seen == nil {
+			seen = make(map[string]bool, len(prev))
+			for _, l := range prev {
+				seen[l] = true
+			}
+		}
+		if !seen[line] {
+			return true
+		}
+	}
+	return false
+}
+
+// loadTextIndex reads the text index, if the file has one, the first time it is needed. If it can't be read,
+// frames are decoded instead.
+func (d *decoderState) loadTextIndex() {
+	d.textIndexOnce.Do(func() {
+		offset := d.index.GetTextIndexOffset()
+		if offset == 0 {
+			return
+		}
+		textIndex := &ITSTextIndex{}
+		if err := readSection(d.file, d.size, offset, d.compressed, textIndex); err != nil {
+			log("can't read the text index: %v", err)
+			return
+		}
+		d.textIndex = textIndex
+	})
+}
+
+// textAt returns the text of a frame, from the text index if loaded, or by decoding the frame.
+func (d *decoderState) textAt(frameId uint64) ([]string, error) {
+	runs := d.textIndex.GetRuns()
+	if len(runs) == 0 {
+		byteOffset, _ := d.frameIdLookup(frameId)
+		_, content, err, _ := d.readFrameFromOffset(byteOffset)
+		if err != nil {
+			return nil, err
+		}
+		return frameText(content, &d.frameSize), nil
+	}
+	i := sort.Search(len(runs), func(i int) bool { return runs[i].GetFirstFrameId() > frameId }) - 1
+	if i < 0 {
+		return nil, nil
+	}
+	textLines := d.textIndex.GetLines()
+	lines := make([]string, len(runs[i].GetLines()))
+	for j, id := range runs[i].GetLines() {
+		if int(id) < len(textLines) {
+			lines[j] = textLines[id]
+		}
+	}
+	return lines, nil
+}
+
+// findMatch looks for the first frame after from, or before it if backwards, where a new match of the pattern
+// appears, wrapping around at the end of the recording. It gives up if stop returns true.
+func (d *decoderState) findMatch(p *searchPattern, from uint64, backwards bool, stop func() bool) (frameId uint64, found, wrapped bool, err error) {
+	d.loadTextIndex()
+	count := d.lastFrameId + 1
+	// each step needs the text of two neighbouring frames, and the next step reuses one of them.
+	cache := make(map[uint64][]string, 3)
+	textAt := func(id uint64) ([]string, error) {
+		if text, ok := cache[id]; ok {
+			return text, nil
+		}
+		text, err := d.textAt(id)
+		if err != nil {
+			return nil, err
+		}
+		for k := range cache {
+			if k+1 != id && k != id+1 {
+				delete(cache, k)
+			}
+		}
+		cache[id] = text
+		return text, nil
+	}
+	for step := uint64(1); step <= count; step++ {
+		if stop() {
+			return
+		}
+		if backwards {
+			frameId = (from + count - step%count) % count
+			wrapped = wrapped || frameId >= from
+		} else {
+			frameId = (from + step) % count
+			wrapped = wrapped || frameId <= from
+		}
+		var prev, cur []string
+		if backwards {
+			cur, err = textAt(frameId)
+			if err == nil && frameId > 0 {
+				prev, err = textAt(frameId - 1)
+			}
+		} else {
+			if frameId > 0 {
+				prev, err = textAt(frameId - 1)
+			}
+			if err == nil {
+				cur, err = textAt(frameId)
+			}
+		}
+		if err != nil {
+			return
+		}
+		if p.newMatch(prev, cur) {
+			found = true
+			return
+		}
+	}
+	return
+}
+
+// startSearch looks for the next match of d.search after the current frame, or the previous one if backwards,
+// in the background, and seeks to it once found. It must be called with d.updateSignal.L locked.
+func (d *decoderState) startSearch(backwards bool) {
+	d.searchGen++
+	d.searching = true
+	d.searchMessage = ""
+	go d.searchThread(d.searchGen, d.search, d.renderingFrameId, backwards)
+}
+
+func (d *decoderState) searchThread(gen int, p *searchPattern, from uint64, backwards bool) {
+	cancelled := func() bool {
+		d.updateSignal.L.Lock()
+		defer d.updateSignal.L.Unlock()
+		return d.searchGen != gen || d.exiting
+	}
+	frameId, found, wrapped, err := d.findMatch(p, from, backwards, cancelled)
+	d.updateSignal.L.Lock()
+	defer d.updateSignal.L.Unlock()
+	if d.searchGen != gen {
+		return
+	}
+	d.searching = false
+	switch {
+	case err != nil:
+		d.searchMessage = fmt.Sprintf("Error reading frame %v: %v", frameId, err)
+	case !found:
+		d.searchMessage = fmt.Sprintf("Pattern not found: %v", p.text)
+	default:
+		d.renderingFrameId = frameId
+		d.paused = true
+		if wrapped && backwards {
+			d.searchMessage = "Search hit the start, continuing at the end"
+		} else if wrapped {
+			d.searchMessage = "Search hit the end, continuing at the start"
+		}
+	}
+	d.showControlBarFor(2 * time.Second)
+	d.updateSignal.Broadcast()
+}
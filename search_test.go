@@ -0,0 +1,121 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func textContent(size sizeStruct, lines ...string) frameContent {
+	fc := make(frameContent, size.rows*size.cols)
+	for row := 0; row < size.rows; row++ {
+		line := []rune{}
+		if row < len(lines) {
+			line = []rune(lines[row])
+		}
+		for col := 0; col < size.cols; col++ {
+			cell := frameCell{chars: []rune{' '}}
+			if col < len(line) {
+				cell.chars = []rune{line[col]}
+			}
+			fc.setCellAt(row, col, cell, &size)
+		}
+	}
+	return fc
+}
+
+func Test_searchPattern_highlight(t *testing.T) {
+	size := sizeStruct{rows: 2, cols: 12}
+	fc := textContent(size, "make: Error", "error error")
+	mask := newSearchPattern("error").highlight(fc, &size)
+	var got []string
+	for row := 0; row < size.rows; row++ {
+		var s strings.Builder
+		for col := 0; col < size.cols; col++ {
+			if mask[row*size.cols+col] {
+				s.WriteByte('^')
+			} else {
+				s.WriteByte(' ')
+			}
+		}
+		got = append(got, s.String())
+	}
+	want := []string{"      ^^^^^ ", "^^^^^ ^^^^^ "}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+	// an uppercase letter makes the search case sensitive
+	mask = newSearchPattern("Error").highlight(fc, &size)
+	if mask[size.cols] || !mask[6] {
+		t.Errorf("smartcase not applied")
+	}
+	if newSearchPattern("warning").highlight(fc, &size) != nil {
+		t.Errorf("expected no highlight without a match")
+	}
+}
+
+func Test_textIndexer(t *testing.T) {
+	var ti textIndexer
+	ti.add(0, []string{"$ ls", ""})
+	ti.add(1, []string{"$ ls", ""})
+	ti.add(2, []string{"$ ls", "a.txt"})
+	ti.add(3, []string{"a.txt", "$ ls"})
+	if !reflect.DeepEqual(ti.lines, []string{"$ ls", "", "a.txt"}) {
+		t.Errorf("lines = %q", ti.lines)
+	}
+	var runs []uint64
+	for _, r := range ti.runs {
+		runs = append(runs, r.FirstFrameId)
+	}
+	if !reflect.DeepEqual(runs, []uint64{0, 2, 3}) || !reflect.DeepEqual(ti.runs[2].Lines, []uint32{2, 0}) {
+		t.Errorf("runs = %v", ti.runs)
+	}
+}
+
+func Test_decoderState_findMatch(t *testing.T) {
+	var ti textIndexer
+	screens := [][]string{
+		{"$ make", ""},
+		{"$ make", "error: x"},
+		// scrolling doesn't make a new match
+		{"error: x", "$"},
+		{"$", ""},
+		{"$ make", ""},
+		{"$ make", "error: y"},
+		{"$ make", "error: y"},
+	}
+	for i, lines := range screens {
+		ti.add(uint64(i), lines)
+	}
+	d := &decoderState{}
+	d.index = &ITSIndex{Count: uint64(len(screens))}
+	d.textIndex = &ITSTextIndex{Lines: ti.lines, Runs: ti.runs}
+	d.lastFrameId = d.index.Count - 1
+	p := newSearchPattern("error")
+	never := func() bool { return false }
+	tests := []struct {
+		from      uint64
+		backwards bool
+		want      uint64
+		wrapped   bool
+	}{
+		{0, false, 1, false},
+		{1, false, 5, false},
+		{5, false, 1, true},
+		{5, true, 1, false},
+		{1, true, 5, true},
+		{3, true, 1, false},
+	}
+	for _, tt := range tests {
+		got, found, wrapped, err := d.findMatch(p, tt.from, tt.backwards, never)
+		if err != nil || !found || got != tt.want || wrapped != tt.wrapped {
+			t.Errorf("from %v, backwards=%v: got %v, found=%v, wrapped=%v, err=%v", tt.from, tt.backwards, got, found, wrapped, err)
+		}
+	}
+	if _, found, _, _ := d.findMatch(newSearchPattern("warning"), 0, false, never); found {
+		t.Errorf("found a pattern which doesn't appear")
+	}
+}
@@ -1,3 +1,6 @@
+//go:build !js
+// +build !js
+
 package main
 
 // struct winsize {
@@ -30,19 +33,37 @@ func termSetSize(fd uintptr, sz sizeStruct) {
 }
 
 func termSetRaw() syscall.Termios {
+	return termSetRawFd(0)
+}
+
+func termSetRawFd(fd uintptr) syscall.Termios {
 	ttyAttr := syscall.Termios{}
-	termios.Tcgetattr(0, &ttyAttr)
+	termios.Tcgetattr(fd, &ttyAttr)
 	copy := ttyAttr
 	copy.Iflag &= ^uint32(syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON)
 	copy.Oflag &= ^uint32(syscall.OPOST)
 	copy.Lflag &= ^uint32(syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN)
 	copy.Cflag |= uint32(syscall.CS8)
-	termios.Tcsetattr(0, termios.TCSADRAIN, &copy)
+	termios.Tcsetattr(fd, termios.TCSADRAIN, &copy)
 	return ttyAttr
 }
 
+// termSetReadTimeout makes reads from the terminal return with no data after tenths / 10 seconds without
+// input, instead of blocking. The terminal must be in raw mode.
+func termSetReadTimeout(fd uintptr, tenths uint8) {
+	ttyAttr := syscall.Termios{}
+	termios.Tcgetattr(fd, &ttyAttr)
+	ttyAttr.Cc[syscall.VMIN] = 0
+	ttyAttr.Cc[syscall.VTIME] = tenths
+	termios.Tcsetattr(fd, termios.TCSANOW, &ttyAttr)
+}
+
 func termRestore(attr syscall.Termios) {
-	termios.Tcsetattr(0, termios.TCSADRAIN, &attr)
+	termRestoreFd(0, attr)
+}
+
+func termRestoreFd(fd uintptr, attr syscall.Termios) {
+	termios.Tcsetattr(fd, termios.TCSADRAIN, &attr)
 }
 
 func tiocsctty(fd uintptr) {
@@ -0,0 +1,422 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// terminfo is a terminal description from the compiled terminfo database, in the format written by ncurses'
+// tic. Only the standard capabilities are read. Extended ones, like Tc or RGB, are skipped.
+type terminfo struct {
+	names   []string
+	bools   []bool
+	numbers []int
+	strings []string
+}
+
+const (
+	terminfoMagic   = 0432
+	terminfoMagic32 = 01036 // numbers are 32 bits instead of 16
+)
+
+// Indices of the capabilities used, in the order of the standard capabilities in term.h.
+const (
+	tiColors = 13
+
+	tiClearScreen        = 5
+	tiClrEos             = 7
+	tiCursorAddress      = 10
+	tiCursorHome         = 12
+	tiEnterBoldMode      = 27
+	tiEnterCaMode        = 28
+	tiEnterUnderlineMode = 36
+	tiExitAttributeMode  = 39
+	tiExitCaMode         = 40
+)
+
+func parseTerminfo(data []byte) (*terminfo, error) {
+	var header [6]int16
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("Truncated terminfo header")
+	}
+	numberSize := 2
+	switch header[0] {
+	case terminfoMagic:
+	case terminfoMagic32:
+		numberSize = 4
+	default:
+		return nil, fmt.Errorf("Not a compiled terminfo entry")
+	}
+	namesSize, boolCount, numberCount, stringCount, tableSize := int(header[1]), int(header[2]), int(header[3]), int(header[4]), int(header[5])
+	if namesSize < 0 || boolCount < 0 || numberCount < 0 || stringCount < 0 || tableSize < 0 {
+		return nil, fmt.Errorf("Invalid terminfo header")
+	}
+	pos := 12
+	need := func(n int) error {
+		if pos+n > len(data) {
+			return fmt.Errorf("Truncated terminfo entry")
+		}
+		return nil
+	}
+	ti := &terminfo{}
+	if err := need(namesSize); err != nil {
+		return nil, err
+	}
+	ti.names = strings.Split(strings.TrimRight(string(data[pos:pos+namesSize]), "\x00"), "|")
+	pos += namesSize
+	if err := need(boolCount); err != nil {
+		return nil, err
+	}
+	ti.bools = make([]bool, boolCount)
+	for i := range ti.bools {
+		ti.bools[i] = data[pos+i] == 1
+	}
+	pos += boolCount
+	// numbers start on an even byte
+	if pos%2 == 1 {
+		pos++
+	}
+	if err := need(numberCount * numberSize); err != nil {
+		return nil, err
+	}
+	ti.numbers = make([]int, numberCount)
+	for i := range ti.numbers {
+		if numberSize == 2 {
+			ti.numbers[i] = int(int16(binary.LittleEndian.Uint16(data[pos+2*i:])))
+		} else {
+			ti.numbers[i] = int(int32(binary.LittleEndian.Uint32(data[pos+4*i:])))
+		}
+	}
+	pos += numberCount * numberSize
+	if err := need(stringCount*2 + tableSize); err != nil {
+		return nil, err
+	}
+	table := data[pos+stringCount*2 : pos+stringCount*2+tableSize]
+	ti.strings = make([]string, stringCount)
+	for i := range ti.strings {
+		// negative offsets are absent or cancelled capabilities
+		off := int(int16(binary.LittleEndian.Uint16(data[pos+2*i:])))
+		if off < 0 || off >= len(table) {
+			continue
+		}
+		end := bytes.IndexByte(table[off:], 0)
+		if end < 0 {
+			return nil, fmt.Errorf("Unterminated terminfo string")
+		}
+		ti.strings[i] = string(table[off : off+end])
+	}
+	return ti, nil
+}
+
+// getNumber returns -1 for numbers the terminal doesn't have.
+func (ti *terminfo) getNumber(i int) int {
+	if i >= len(ti.numbers) || ti.numbers[i] < 0 {
+		return -1
+	}
+	return ti.numbers[i]
+}
+
+func (ti *terminfo) getString(i int) string {
+	if i >= len(ti.strings) {
+		return ""
+	}
+	return ti.strings[i]
+}
+
+// terminfoDirs lists where to look for terminfo entries, in the same order as ncurses.
+func terminfoDirs(getenv func(string) string) []string {
+	var dirs []string
+	if d := getenv("TERMINFO"); d != "" {
+		dirs = append(dirs, d)
+	}
+	if home := getenv("HOME"); home != "" {
+		dirs = append(dirs, filepath.Join(home, ".terminfo"))
+	}
+	defaults := []string{"/etc/terminfo", "/lib/terminfo", "/usr/share/terminfo", "/usr/lib/terminfo"}
+	if list := getenv("TERMINFO_DIRS"); list != "" {
+		for _, d := range strings.Split(list, ":") {
+			// an empty entry stands for the default locations
+			if d == "" {
+				dirs = append(dirs, defaults...)
+			} else {
+				dirs = append(dirs, d)
+			}
+		}
+	} else {
+		dirs = append(dirs, defaults...)
+	}
+	return dirs
+}
+
+// loadTerminfo finds and reads the terminfo entry for term. Entries are stored under the first letter of
+// their name, or its hex code on case-insensitive filesystems like macOS'.
+func loadTerminfo(term string, getenv func(string) string) (*terminfo, error) {
+	if term == "" || strings.ContainsAny(term, "/") || term[0] == '.' {
+		return nil, fmt.Errorf("Invalid terminal name %v", strconv.Quote(term))
+	}
+	for _, dir := range terminfoDirs(getenv) {
+		for _, sub := range []string{term[:1], fmt.Sprintf("%02x", term[0])} {
+			data, err := ioutil.ReadFile(filepath.Join(dir, sub, term))
+			if err == nil {
+				return parseTerminfo(data)
+			}
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("No terminfo entry for %v", term)
+}
+
+// regPadding matches padding like $<5> or $<2*/>, which only matters for real serial terminals.
+var regPadding = regexp.MustCompile(`\$<[0-9.]*[*/]*>`)
+
+// tparm expands the parameterized string s, such as cup=\E[%i%p1%d;%p2%dH, with integer parameters.
+func tparm(s string, params ...int) string {
+	var p [9]int
+	copy(p[:], params)
+	var dynamic, static [26]int
+	var stack []int
+	push := func(v int) {
+		stack = append(stack, v)
+	}
+	pop := func() int {
+		if len(stack) == 0 {
+			return 0
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	boolInt := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	// skip returns the position after the %; ending the current conditional, or after its %e if orElse.
+	skip := func(i int, orElse bool) int {
+		depth := 0
+		for ; i < len(s)-1; i++ {
+			if s[i] != '%' {
+				continue
+			}
+			i++
+			switch s[i] {
+			case '?':
+				depth++
+			case ';':
+				if depth == 0 {
+					return i + 1
+				}
+				depth--
+			case 'e':
+				if depth == 0 && orElse {
+					return i + 1
+				}
+			}
+		}
+		return len(s)
+	}
+	s = regPadding.ReplaceAllString(s, "")
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+1 >= len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case '%':
+			out.WriteByte('%')
+		case 'c':
+			out.WriteByte(byte(pop()))
+		case 'p':
+			if i+1 < len(s) && s[i+1] >= '1' && s[i+1] <= '9' {
+				i++
+				push(p[s[i]-'1'])
+			}
+		case 'P', 'g':
+			if i+1 >= len(s) {
+				break
+			}
+			i++
+			v := s[i]
+			var vars *[26]int
+			switch {
+			case v >= 'a' && v <= 'z':
+				vars, v = &dynamic, v-'a'
+			case v >= 'A' && v <= 'Z':
+				vars, v = &static, v-'A'
+			default:
+				continue
+			}
+			if c == 'P' {
+				vars[v] = pop()
+			} else {
+				push(vars[v])
+			}
+		case '\'':
+			if i+2 < len(s) {
+				push(int(s[i+1]))
+				i += 2
+			}
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				break
+			}
+			n, _ := strconv.Atoi(s[i+1 : i+end])
+			push(n)
+			i += end
+		case 'l':
+			push(len(strconv.Itoa(pop())))
+		case '+', '-', '*', '/', 'm', '&', '|', '^', '=', '>', '<', 'A', 'O':
+			b, a := pop(), pop()
+			switch c {
+			case '+':
+				push(a + b)
+			case '-':
+				push(a - b)
+			case '*':
+				push(a * b)
+			case '/':
+				if b != 0 {
+					push(a / b)
+				} else {
+					push(0)
+				}
+			case 'm':
+				if b != 0 {
+					push(a % b)
+				} else {
+					push(0)
+				}
+			case '&':
+				push(a & b)
+			case '|':
+				push(a | b)
+			case '^':
+				push(a ^ b)
+			case '=':
+				push(boolInt(a == b))
+			case '>':
+				push(boolInt(a > b))
+			case '<':
+				push(boolInt(a < b))
+			case 'A':
+				push(boolInt(a != 0 && b != 0))
+			case 'O':
+				push(boolInt(a != 0 || b != 0))
+			}
+		case '!':
+			push(boolInt(pop() == 0))
+		case '~':
+			push(^pop())
+		case 'i':
+			p[0]++
+			p[1]++
+		case '?', ';':
+		case 't':
+			if pop() == 0 {
+				i = skip(i+1, true) - 1
+			}
+		case 'e':
+			// the then-part was taken, so skip the rest
+			i = skip(i+1, false) - 1
+		default:
+			// %[[:]flags][width[.precision]][doxXs]
+			j := i
+			if s[j] == ':' {
+				j++
+			}
+			for j < len(s) && strings.IndexByte("-+# 0123456789.", s[j]) >= 0 {
+				j++
+			}
+			if j >= len(s) || strings.IndexByte("doxXs", s[j]) < 0 {
+				break
+			}
+			spec := strings.TrimPrefix(s[i:j], ":")
+			if s[j] == 's' {
+				fmt.Fprintf(&out, "%"+spec+"s", strconv.Itoa(pop()))
+			} else {
+				fmt.Fprintf(&out, "%"+spec+string(s[j]), pop())
+			}
+			i = j
+		}
+	}
+	return out.String()
+}
+
+// termCaps holds the control sequences the player writes to the terminal.
+type termCaps struct {
+	enterAltScreen, exitAltScreen string
+	clearScreen                   string // clears the screen and moves the cursor to the top left
+	cursorAddress                 string // a parameterized string taking the row and column
+	resetAttrs, bold, underline   string
+	colors                        int // -1 if not known
+}
+
+// xtermCaps is used when there's no terminfo entry for the terminal.
+var xtermCaps = termCaps{
+	enterAltScreen: "\033[?1049h",
+	exitAltScreen:  "\033[?1049l",
+	clearScreen:    "\033[H\033[2J",
+	cursorAddress:  "\033[%i%p1%d;%p2%dH",
+	resetAttrs:     "\033[0m",
+	bold:           "\033[1m",
+	underline:      "\033[4m",
+	colors:         -1,
+}
+
+// loadTermCaps reads the capabilities of term from terminfo. A terminal without an alternate screen, like the
+// Linux console, gets the screen cleared instead when the player starts and exits.
+func loadTermCaps(term string, getenv func(string) string) termCaps {
+	ti, err := loadTerminfo(term, getenv)
+	if err != nil {
+		log("%v, using xterm control sequences", err.Error())
+		return xtermCaps
+	}
+	// strings without parameters are expanded once here, which removes their padding
+	tc := termCaps{
+		enterAltScreen: tparm(ti.getString(tiEnterCaMode)),
+		exitAltScreen:  tparm(ti.getString(tiExitCaMode)),
+		clearScreen:    tparm(ti.getString(tiClearScreen)),
+		cursorAddress:  ti.getString(tiCursorAddress),
+		resetAttrs:     tparm(ti.getString(tiExitAttributeMode)),
+		bold:           tparm(ti.getString(tiEnterBoldMode)),
+		underline:      tparm(ti.getString(tiEnterUnderlineMode)),
+		colors:         ti.getNumber(tiColors),
+	}
+	if tc.cursorAddress == "" {
+		log("%v can't move the cursor, using xterm control sequences", term)
+		return xtermCaps
+	}
+	if tc.clearScreen == "" {
+		tc.clearScreen = tparm(ti.getString(tiCursorHome) + ti.getString(tiClrEos))
+		if tc.clearScreen == "" {
+			tc.clearScreen = xtermCaps.clearScreen
+		}
+	}
+	if tc.enterAltScreen == "" {
+		tc.enterAltScreen = tc.clearScreen
+	}
+	if tc.exitAltScreen == "" {
+		tc.exitAltScreen = tc.clearScreen
+	}
+	return tc
+}
+
+func (tc *termCaps) moveTo(row, col int) string {
+	return tparm(tc.cursorAddress, row, col)
+}
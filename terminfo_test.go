@@ -0,0 +1,116 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// compileTerminfo writes an entry in the legacy (16 bit numbers) format, like tic does.
+func compileTerminfo(names string, numbers []int16, strs []string) []byte {
+	var table bytes.Buffer
+	offsets := make([]int16, len(strs))
+	for i, s := range strs {
+		if s == "" {
+			offsets[i] = -1
+			continue
+		}
+		offsets[i] = int16(table.Len())
+		table.WriteString(s)
+		table.WriteByte(0)
+	}
+	var buf bytes.Buffer
+	bools := []byte{1}
+	header := []int16{terminfoMagic, int16(len(names) + 1), int16(len(bools)), int16(len(numbers)), int16(len(strs)), int16(table.Len())}
+	binary.Write(&buf, binary.LittleEndian, header)
+	buf.WriteString(names)
+	buf.WriteByte(0)
+	buf.Write(bools)
+	if buf.Len()%2 == 1 {
+		buf.WriteByte(0)
+	}
+	binary.Write(&buf, binary.LittleEndian, numbers)
+	binary.Write(&buf, binary.LittleEndian, offsets)
+	buf.Write(table.Bytes())
+	return buf.Bytes()
+}
+
+func Test_loadTermCaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ts-player-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	strs := make([]string, tiExitCaMode+1)
+	strs[tiClearScreen] = "\033[H\033[J$<50>"
+	strs[tiCursorAddress] = "\033[%i%p1%d;%p2%dH$<5>"
+	strs[tiExitAttributeMode] = "\033[m"
+	numbers := make([]int16, tiColors+1)
+	for i := range numbers {
+		numbers[i] = -1
+	}
+	numbers[tiColors] = 8
+	// no alternate screen, like the Linux console
+	os.MkdirAll(filepath.Join(dir, "t"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "t", "testterm"), compileTerminfo("testterm|test terminal", numbers, strs), 0644)
+	strs[tiEnterCaMode] = "\033[?1049h"
+	strs[tiExitCaMode] = "\033[?1049l"
+	os.MkdirAll(filepath.Join(dir, "74"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "74", "testterm-alt"), compileTerminfo("testterm-alt", numbers, strs), 0644)
+	getenv := func(k string) string {
+		if k == "TERMINFO_DIRS" {
+			return dir
+		}
+		return ""
+	}
+
+	tc := loadTermCaps("testterm", getenv)
+	if tc.colors != 8 {
+		t.Errorf("colors = %v, expected 8", tc.colors)
+	}
+	if got := tc.moveTo(4, 9); got != "\033[5;10H" {
+		t.Errorf("moveTo(4, 9) = %q", got)
+	}
+	if tc.enterAltScreen != "\033[H\033[J" || tc.exitAltScreen != "\033[H\033[J" {
+		t.Errorf("expected the screen to be cleared without an alternate screen, got %q and %q", tc.enterAltScreen, tc.exitAltScreen)
+	}
+	tc = loadTermCaps("testterm-alt", getenv)
+	if tc.enterAltScreen != "\033[?1049h" || tc.exitAltScreen != "\033[?1049l" {
+		t.Errorf("got alternate screen %q and %q", tc.enterAltScreen, tc.exitAltScreen)
+	}
+	tc = loadTermCaps("nonexistent", getenv)
+	if tc != xtermCaps {
+		t.Errorf("expected xterm sequences for an unknown terminal")
+	}
+}
+
+func Test_tparm(t *testing.T) {
+	const setaf = "\033[%?%p1%{8}%<%t3%p1%d%e%p1%{16}%<%t9%p1%{8}%-%d%e38;5;%p1%d%;m"
+	tests := []struct {
+		s      string
+		params []int
+		want   string
+	}{
+		{"\033[%i%p1%d;%p2%dH", []int{0, 0}, "\033[1;1H"},
+		{setaf, []int{3}, "\033[33m"},
+		{setaf, []int{12}, "\033[94m"},
+		{setaf, []int{208}, "\033[38;5;208m"},
+		{"%p1%02d%p2%:-3d|%p1%x", []int{7, 5}, "075  |7"},
+		{"%p1%Pa%ga%ga%*%d", []int{6}, "36"},
+		{"%'A'%p1%+%c", []int{2}, "C"},
+		{"%?%p1%t%?%p2%tboth%eone%;%eneither%;", []int{1, 0}, "one"},
+		{"100%%", nil, "100%"},
+		{"%p1%l%d", []int{12345}, "5"},
+	}
+	for _, tt := range tests {
+		if got := tparm(tt.s, tt.params...); got != tt.want {
+			t.Errorf("tparm(%q, %v) = %q, expected %q", tt.s, tt.params, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"math"
+	"os"
+	"sort"
+)
+
+// GIF delays are in centiseconds, and most viewers show anything shorter than 2cs as 10cs, so shorter
+// frames are merged into the next one.
+const gifMinDelay = 2
+
+func doOpToGIF(opt options) {
+	d := initPlayer(opt)
+	if d.translateColor == nil {
+		cf := xtermColorProfile()
+		d.translateColor = &cf
+	}
+	colorCounts := make(map[[2]color.RGBA]int)
+	extent := d.scanFrames(func(finfo *frame, content frameContent) {
+		for i := range content {
+			fg, bg := cellRGB(&content[i])
+			colorCounts[[2]color.RGBA{fg, bg}]++
+		}
+		if finfo.index%10 == 0 {
+			fmt.Fprintf(os.Stderr, "\r\033[2KScanning frame %v / %v", finfo.index, d.lastFrameId+1)
+		}
+	})
+	if opt.bufferSizeSet {
+		extent = opt.bufferSize
+	}
+	if extent.rows == 0 || extent.cols == 0 {
+		extent = sizeStruct{1, 1}
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[2KUsing %vx%v cells\n", extent.rows, extent.cols)
+
+	rasterizer := newCellRasterizer(opt)
+	bounds := image.Rect(0, 0, extent.cols*rasterizer.cellWidth, extent.rows*rasterizer.cellHeight)
+	canvas := image.NewRGBA(bounds)
+	q := newGIFQuantizer(colorCounts)
+
+	out := &gif.GIF{}
+	out.Config = image.Config{ColorModel: q.palette, Width: bounds.Dx(), Height: bounds.Dy()}
+	var emitted frameContent
+	var merger gifFrameMerger
+	emit := func(content frameContent, delay int) {
+		rect := bounds
+		if emitted != nil {
+			rect = rasterizer.changedRect(emitted, content, d.frameSize, extent.rows, extent.cols)
+			if rect.Empty() {
+				out.Delay[len(out.Delay)-1] += delay
+				return
+			}
+		}
+		rasterizer.drawFrame(canvas, emitted, content, d.frameSize, extent.rows, extent.cols)
+		out.Image = append(out.Image, q.quantize(canvas, rect))
+		out.Delay = append(out.Delay, delay)
+		out.Disposal = append(out.Disposal, gif.DisposalNone)
+		emitted = content
+	}
+	for i := uint64(0); i <= d.lastFrameId; i++ {
+		byteOffset, _ := d.frameIdLookup(i)
+		finfo, content, err, _ := d.readFrameFromOffset(byteOffset)
+		if err != nil {
+			panic(err)
+		}
+		merger.add(&finfo, content, i == d.lastFrameId, emit)
+		if i%10 == 0 {
+			fmt.Fprintf(os.Stderr, "\r\033[2KRendering frame %v / %v", i, d.lastFrameId+1)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[2KWriting %v GIF frames...\n", len(out.Image))
+
+	fOut, err := os.OpenFile(opt.videoOutput, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		panic(fmt.Errorf("%v when opening %v for writing", err.Error(), opt.videoOutput))
+	}
+	defer fOut.Close()
+	err = gif.EncodeAll(fOut, out)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// gifFrameMerger holds back each frame until the next one starts, to know its delay, and merges frames too
+// short for a GIF into the one after them.
+type gifFrameMerger struct {
+	pending      frameContent
+	pendingStart float64
+}
+
+func gifCentiseconds(t float64) int {
+	return int(math.Round(t * 100))
+}
+
+// add takes the next frame of the recording, and calls emit with the frame to show before it, if any. If last
+// is true, the frame itself is emitted as well.
+func (m *gifFrameMerger) add(finfo *frame, content frameContent, last bool, emit func(content frameContent, delay int)) {
+	if m.pending == nil {
+		m.pendingStart = finfo.time
+	} else if delay := gifCentiseconds(finfo.time) - gifCentiseconds(m.pendingStart); delay >= gifMinDelay {
+		emit(m.pending, delay)
+		m.pendingStart = finfo.time
+	}
+	// a frame too short to be emitted is replaced by this one, which is shown from when it would have
+	// started, so that the GIF stays in time with the recording.
+	m.pending = content
+	if last {
+		delay := gifCentiseconds(finfo.time+finfo.duration) - gifCentiseconds(m.pendingStart)
+		if delay < gifMinDelay {
+			delay = gifMinDelay
+		}
+		emit(m.pending, delay)
+	}
+}
+
+// changedRect returns the bounding box, in pixels, of the cells which differ between perv and next, including
+// the cell to the right of a changed one if either covers it.
+func (r *cellRasterizer) changedRect(perv, next frameContent, frameSize sizeStruct, rows, cols int) image.Rectangle {
+	changed := image.Rectangle{}
+	for row := 0; row < frameSize.rows && row < rows; row++ {
+		for col := 0; col < frameSize.cols && col < cols; col++ {
+			pervCell, nextCell := perv.getCellAt(row, col, &frameSize), next.getCellAt(row, col, &frameSize)
+			if !pervCell.equalsTo(nextCell) {
+				changed = changed.Union(r.cellRect(row, col))
+				if col+1 < cols && (r.isWide(nextCell) || r.isWide(pervCell)) {
+					changed = changed.Union(r.cellRect(row, col+1))
+				}
+			}
+		}
+	}
+	return changed
+}
+
+func cellRGB(cell *frameCell) (fg, bg color.RGBA) {
+	fR, fG, fB, _ := cell.style.fg.GetRGB()
+	bR, bG, bB, _ := cell.style.bg.GetRGB()
+	return color.RGBA{fR, fG, fB, 255}, color.RGBA{bR, bG, bB, 255}
+}
+
+// gifQuantizer maps rendered pixels to a palette made from the colors used in the recording. Since text is
+// anti-aliased, blends between the most common foreground/background pairs are added as well.
+type gifQuantizer struct {
+	palette color.Palette
+	cache   map[color.RGBA]uint8
+}
+
+func newGIFQuantizer(colorCounts map[[2]color.RGBA]int) *gifQuantizer {
+	type pairCount struct {
+		pair  [2]color.RGBA
+		count int
+	}
+	pairs := make([]pairCount, 0, len(colorCounts))
+	singleCounts := make(map[color.RGBA]int)
+	for pair, count := range colorCounts {
+		pairs = append(pairs, pairCount{pair, count})
+		singleCounts[pair[0]] += count
+		singleCounts[pair[1]] += count
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].count > pairs[j].count
+	})
+	singles := make([]color.RGBA, 0, len(singleCounts))
+	for c := range singleCounts {
+		singles = append(singles, c)
+	}
+	sort.Slice(singles, func(i, j int) bool {
+		return singleCounts[singles[i]] > singleCounts[singles[j]]
+	})
+
+	q := &gifQuantizer{cache: make(map[color.RGBA]uint8)}
+	seen := make(map[color.RGBA]bool)
+	add := func(c color.RGBA) {
+		if len(q.palette) < 256 && !seen[c] {
+			seen[c] = true
+			q.palette = append(q.palette, c)
+		}
+	}
+	for _, c := range singles {
+		add(c)
+	}
+	for _, level := range []int{2, 1, 3} {
+		for _, p := range pairs {
+			fg, bg := p.pair[0], p.pair[1]
+			if fg == bg {
+				continue
+			}
+			blend := func(a, b uint8) uint8 {
+				return uint8((int(a)*level + int(b)*(4-level)) / 4)
+			}
+			add(color.RGBA{blend(fg.R, bg.R), blend(fg.G, bg.G), blend(fg.B, bg.B), 255})
+		}
+	}
+	if len(q.palette) == 0 {
+		add(color.RGBA{0, 0, 0, 255})
+	}
+	return q
+}
+
+func (q *gifQuantizer) quantize(canvas *image.RGBA, rect image.Rectangle) *image.Paletted {
+	img := image.NewPaletted(rect, q.palette)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := canvas.RGBAAt(x, y)
+			c.A = 255
+			index, ok := q.cache[c]
+			if !ok {
+				index = uint8(q.palette.Index(c))
+				q.cache[c] = index
+			}
+			img.SetColorIndex(x, y, index)
+		}
+	}
+	return img
+}
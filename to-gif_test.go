@@ -0,0 +1,72 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+func Test_gifFrameMerger(t *testing.T) {
+	tests := []struct {
+		name   string
+		times  []float64
+		last   float64 // duration of the last frame
+		frames []int   // index of each emitted frame
+		delays []int
+	}{
+		{"one frame", []float64{0}, 1, []int{0}, []int{100}},
+		{"short frames merged into the next", []float64{0, 0.005, 0.01, 0.5}, 1, []int{2, 3}, []int{50, 100}},
+		{"merged frame keeps its start", []float64{0, 0.3, 0.305, 0.31, 0.4}, 0.1, []int{0, 3, 4}, []int{30, 10, 10}},
+		{"short last frame", []float64{0, 1}, 0.001, []int{0, 1}, []int{100, gifMinDelay}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contents := make([]frameContent, len(tt.times))
+			var frames, delays []int
+			var m gifFrameMerger
+			for i, time := range tt.times {
+				contents[i] = frameContent{testCell(rune('a' + i))}
+				finfo := &frame{index: uint64(i), time: time, duration: tt.last}
+				m.add(finfo, contents[i], i == len(tt.times)-1, func(content frameContent, delay int) {
+					frames = append(frames, int(content[0].chars[0]-'a'))
+					delays = append(delays, delay)
+				})
+			}
+			if !reflect.DeepEqual(frames, tt.frames) || !reflect.DeepEqual(delays, tt.delays) {
+				t.Errorf("emitted frames %v with delays %v, expected %v with %v", frames, delays, tt.frames, tt.delays)
+			}
+		})
+	}
+}
+
+func Test_cellRasterizer_changedRect(t *testing.T) {
+	r := newTestRasterizer(t)
+	size := sizeStruct{rows: 2, cols: 4}
+	wide := testCell('中')
+	cacheWideGlyph(r, &wide)
+	blank := frameContent{testCell(' '), testCell(' '), testCell(' '), testCell(' '), testCell(' '), testCell(' '), testCell(' '), testCell(' ')}
+	with := func(row, col int, cell frameCell) frameContent {
+		fc := append(frameContent(nil), blank...)
+		fc.setCellAt(row, col, cell, &size)
+		return fc
+	}
+	tests := []struct {
+		name       string
+		perv, next frameContent
+		want       image.Rectangle
+	}{
+		{"unchanged", blank, blank, image.Rectangle{}},
+		{"one cell", blank, with(1, 2, testCell('x')), r.cellRect(1, 2)},
+		{"wide character added", blank, with(0, 1, wide), r.cellRect(0, 1).Union(r.cellRect(0, 2))},
+		{"wide character removed", with(0, 1, wide), with(0, 1, testCell('x')), r.cellRect(0, 1).Union(r.cellRect(0, 2))},
+		{"wide character in the last column", blank, with(0, 3, wide), r.cellRect(0, 3)},
+	}
+	for _, tt := range tests {
+		if got := r.changedRect(tt.perv, tt.next, size, size.rows, size.cols); got != tt.want {
+			t.Errorf("%v: got %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+}
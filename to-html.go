@@ -0,0 +1,368 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/micromaomao/go-libvterm"
+	"html"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Frames are exported as deltas: each frame lists the rows that changed since the previous one, and every
+// htmlKeyframeInterval frames contains all rows so that the player can seek without replaying from the start.
+const htmlKeyframeInterval = 50
+
+type htmlFrame struct {
+	Time     float64         `json:"t"`
+	Duration float64         `json:"d"`
+	Key      bool            `json:"k,omitempty"`
+	Rows     [][]interface{} `json:"r"` // [rowIndex, [text, styleId, text, styleId, ...]]
+}
+
+type htmlRecording struct {
+	Rows             int         `json:"rows"`
+	Cols             int         `json:"cols"`
+	Bg               string      `json:"bg"`
+	Styles           [][]string  `json:"styles"`      // [fg, bg, flags]
+	KeyframeInterval int         `json:"keyInterval"` // htmlKeyframeInterval, for the player to find the last keyframe
+	Frames           []htmlFrame `json:"frames"`
+}
+
+type htmlExporter struct {
+	d          *decoderState
+	cf         *colorProfile
+	styleIds   map[uint64]int
+	rec        htmlRecording
+	blankStyle uint64
+}
+
+func doOpToHTML(opt options) {
+	d := initPlayer(opt)
+	x := &htmlExporter{d: d}
+	x.cf = d.translateColor
+	if x.cf == nil {
+		cf := xtermColorProfile()
+		x.cf = &cf
+	}
+	x.styleIds = make(map[uint64]int)
+	x.rec.Styles = make([][]string, 0)
+	x.rec.KeyframeInterval = htmlKeyframeInterval
+	x.rec.Frames = make([]htmlFrame, 0, d.index.GetCount())
+
+	var pervRows []string
+	for i := uint64(0); i <= d.lastFrameId; i++ {
+		byteOffset, _ := d.frameIdLookup(i)
+		finfo, content, err, _ := d.readFrameFromOffset(byteOffset)
+		if err != nil {
+			panic(err)
+		}
+		if i == 0 {
+			blank := content.getCellAt(d.frameSize.rows-1, d.frameSize.cols-1, &d.frameSize)
+			x.blankStyle = blankStyleOf(content, &d.frameSize)
+			x.rec.Bg = x.styleOf(blank)[1]
+		}
+		hf := htmlFrame{Time: finfo.time, Duration: finfo.duration, Rows: make([][]interface{}, 0)}
+		hf.Key = i%htmlKeyframeInterval == 0
+		rows := make([]string, d.frameSize.rows)
+		for row := 0; row < d.frameSize.rows; row++ {
+			spans := x.rowSpans(content, row)
+			if len(spans) == 0 {
+				continue
+			}
+			encoded, _ := json.Marshal(spans)
+			rows[row] = string(encoded)
+			if hf.Key || pervRows == nil || pervRows[row] != rows[row] {
+				hf.Rows = append(hf.Rows, []interface{}{row, spans})
+			}
+		}
+		if !hf.Key && pervRows != nil {
+			for row := range rows {
+				if rows[row] == "" && pervRows[row] != "" {
+					// row cleared
+					hf.Rows = append(hf.Rows, []interface{}{row, []interface{}{}})
+				}
+			}
+		}
+		pervRows = rows
+		x.rec.Frames = append(x.rec.Frames, hf)
+		if i%10 == 0 {
+			fmt.Fprintf(os.Stderr, "\r\033[2KConverting frame %v / %v", i, d.lastFrameId+1)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[2KConverted %v frames, writing %v...\n", d.lastFrameId+1, opt.htmlOutput)
+
+	data, err := json.Marshal(x.rec)
+	if err != nil {
+		panic(err)
+	}
+	title := html.EscapeString(filepath.Base(opt.itsInput))
+	page := strings.Replace(htmlPlayerTemplate, "{{title}}", title, -1)
+	// json.Marshal escapes <, > and &, so the data can not end the script element.
+	page = strings.Replace(page, "{{data}}", string(data), 1)
+	fOut, err := os.OpenFile(opt.htmlOutput, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		panic(fmt.Errorf("%v when opening %v for writing", err.Error(), opt.htmlOutput))
+	}
+	defer fOut.Close()
+	_, err = fOut.WriteString(page)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// rowSpans groups a row into runs of the same style, leaving out trailing blanks. Also updates the used
+// area of the recording.
+func (x *htmlExporter) rowSpans(content frameContent, row int) []interface{} {
+	spans := make([]interface{}, 0)
+	d := x.d
+	end := d.frameSize.cols
+	for end > 0 {
+		cell := content.getCellAt(row, end-1, &d.frameSize)
+		if !isBlankCell(cell, x.blankStyle) {
+			break
+		}
+		end--
+	}
+	if end == 0 {
+		return spans
+	}
+	var text strings.Builder
+	lastStyle := -1
+	for col := 0; col < end; col++ {
+		cell := content.getCellAt(row, col, &d.frameSize)
+		styleId := x.styleId(cell)
+		if styleId != lastStyle && text.Len() > 0 {
+			spans = append(spans, text.String(), lastStyle)
+			text.Reset()
+		}
+		lastStyle = styleId
+		text.WriteString(string(cell.chars))
+	}
+	spans = append(spans, text.String(), lastStyle)
+	if row+1 > x.rec.Rows {
+		x.rec.Rows = row + 1
+	}
+	if end > x.rec.Cols {
+		x.rec.Cols = end
+	}
+	return spans
+}
+
+func (x *htmlExporter) styleId(cell *frameCell) int {
+	code := cell.attrCode(nil)
+	id, ok := x.styleIds[code]
+	if !ok {
+		id = len(x.rec.Styles)
+		x.styleIds[code] = id
+		x.rec.Styles = append(x.rec.Styles, x.styleOf(cell))
+	}
+	return id
+}
+
+func (x *htmlExporter) styleOf(cell *frameCell) []string {
+	resolve := func(c vterm.VTermColor) string {
+		if c.IsRGB() {
+			r, g, b, _ := c.GetRGB()
+			return rgbaToHex(color.RGBA{r, g, b, 255})
+		}
+		index, _ := c.GetIndex()
+		return rgbaToHex(x.cf.palette[index])
+	}
+	flags := ""
+	if cell.style.bold {
+		flags += "b"
+	}
+	if cell.style.underline {
+		flags += "u"
+	}
+	return []string{resolve(cell.style.fg), resolve(cell.style.bg), flags}
+}
+
+const htmlPlayerTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{title}}</title>
+<style>
+body { margin: 0; padding: 1em; font-family: sans-serif; background: #222; color: #ddd; }
+#screen { display: inline-block; margin: 0; padding: 0.5em; font: 14px/1.2 monospace; white-space: pre; overflow: auto; max-width: 100%; box-sizing: border-box; }
+#screen .b { font-weight: bold; }
+#screen .u { text-decoration: underline; }
+#controls { display: flex; align-items: center; gap: 0.5em; margin-top: 0.5em; }
+#seek { flex-grow: 1; }
+#time { font-family: monospace; }
+</style>
+</head>
+<body>
+<pre id="screen"></pre>
+<div id="controls">
+  <button id="play">Pause</button>
+  <input id="seek" type="range" min="0" max="1000" value="0">
+  <span id="time"></span>
+  <select id="speed">
+    <option value="0.25">0.25x</option><option value="0.5">0.5x</option><option value="1" selected>1x</option>
+    <option value="2">2x</option><option value="4">4x</option><option value="8">8x</option>
+  </select>
+</div>
+<script id="ts-data" type="application/json">{{data}}</script>
+<script>
+(function () {
+  "use strict";
+  var rec = JSON.parse(document.getElementById("ts-data").textContent);
+  var frames = rec.frames;
+  var screen = document.getElementById("screen");
+  var playBtn = document.getElementById("play");
+  var seek = document.getElementById("seek");
+  var timeText = document.getElementById("time");
+  var speedSel = document.getElementById("speed");
+  var last = frames[frames.length - 1];
+  var totalTime = last.t + last.d;
+
+  var css = "#screen { background: " + rec.bg + "; min-width: " + rec.cols + "ch; }\n";
+  rec.styles.forEach(function (s, i) {
+    css += ".s" + i + " { color: " + s[0] + "; background: " + s[1] + "; }\n";
+  });
+  var styleEl = document.createElement("style");
+  styleEl.textContent = css;
+  document.head.appendChild(styleEl);
+
+  var rowEls = [];
+  for (var i = 0; i < rec.rows; i++) {
+    var el = document.createElement("div");
+    el.textContent = " ";
+    screen.appendChild(el);
+    rowEls.push(el);
+  }
+
+  var rows = [];
+  var shownFrame = -1;
+  var dirty = {};
+
+  function applyFrame(f) {
+    if (f.k) {
+      for (var i = 0; i < rec.rows; i++) {
+        if (rows[i] && rows[i].length) {
+          rows[i] = [];
+          dirty[i] = true;
+        }
+      }
+    }
+    f.r.forEach(function (r) {
+      rows[r[0]] = r[1];
+      dirty[r[0]] = true;
+    });
+  }
+
+  function flush() {
+    Object.keys(dirty).forEach(function (i) {
+      var el = rowEls[i], spans = rows[i] || [];
+      el.textContent = "";
+      for (var j = 0; j < spans.length; j += 2) {
+        var span = document.createElement("span");
+        var style = rec.styles[spans[j + 1]];
+        span.className = "s" + spans[j + 1] + (style[2].indexOf("b") >= 0 ? " b" : "") + (style[2].indexOf("u") >= 0 ? " u" : "");
+        span.textContent = spans[j];
+        el.appendChild(span);
+      }
+      if (spans.length === 0) {
+        el.textContent = " ";
+      }
+    });
+    dirty = {};
+  }
+
+  function frameAt(t) {
+    var lo = 0, hi = frames.length - 1;
+    while (lo < hi) {
+      var mid = (lo + hi + 1) >> 1;
+      if (frames[mid].t <= t) {
+        lo = mid;
+      } else {
+        hi = mid - 1;
+      }
+    }
+    return lo;
+  }
+
+  function showFrame(n) {
+    if (n === shownFrame) {
+      return;
+    }
+    var from = shownFrame + 1;
+    if (n < shownFrame || n - shownFrame > rec.keyInterval) {
+      from = n - (n % rec.keyInterval);
+    }
+    for (var i = from; i <= n; i++) {
+      applyFrame(frames[i]);
+    }
+    shownFrame = n;
+    flush();
+  }
+
+  function fmtTime(t) {
+    var s = Math.floor(t), h = Math.floor(s / 3600), m = Math.floor(s / 60) % 60;
+    s = s % 60;
+    return (h > 0 ? h + ":" + (m < 10 ? "0" : "") : "") + m + ":" + (s < 10 ? "0" : "") + s;
+  }
+
+  var playing = true, speed = 1, position = 0, lastTick = null;
+
+  function tick(now) {
+    if (lastTick !== null && playing) {
+      position += (now - lastTick) / 1000 * speed;
+      if (position >= totalTime) {
+        position = totalTime;
+        setPlaying(false);
+      }
+    }
+    lastTick = now;
+    showFrame(frameAt(position));
+    if (document.activeElement !== seek) {
+      seek.value = Math.round(position / totalTime * 1000);
+    }
+    timeText.textContent = fmtTime(position) + " / " + fmtTime(totalTime);
+    requestAnimationFrame(tick);
+  }
+
+  function setPlaying(p) {
+    playing = p;
+    playBtn.textContent = p ? "Pause" : "Play";
+  }
+
+  playBtn.addEventListener("click", function () {
+    if (!playing && position >= totalTime) {
+      position = 0;
+    }
+    setPlaying(!playing);
+  });
+  seek.addEventListener("input", function () {
+    position = seek.value / 1000 * totalTime;
+  });
+  speedSel.addEventListener("change", function () {
+    speed = parseFloat(speedSel.value);
+  });
+  document.addEventListener("keydown", function (e) {
+    if (e.target !== document.body) {
+      return;
+    }
+    if (e.key === " " || e.key === "k") {
+      playBtn.click();
+      e.preventDefault();
+    } else if (e.key === "j" || e.key === "ArrowLeft") {
+      position = Math.max(0, position - 5);
+    } else if (e.key === "l" || e.key === "ArrowRight") {
+      position = Math.min(totalTime, position + 5);
+    }
+  });
+  requestAnimationFrame(tick);
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,58 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_doOpToHTML(t *testing.T) {
+	size := sizeStruct{rows: 3, cols: 10}
+	path := writeTestRecording(t, size,
+		testFrame(size, "$ ls"),
+		testFrame(size, "$ ls", "a<b.txt"),
+		testFrame(size, "$"))
+	defer os.Remove(path)
+	out := path + ".html"
+	defer os.Remove(out)
+	doOpToHTML(options{itsInput: path, htmlOutput: out})
+
+	page, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const dataStart = `<script id="ts-data" type="application/json">`
+	start := strings.Index(string(page), dataStart)
+	if start < 0 {
+		t.Fatalf("no data in the page")
+	}
+	data := string(page[start+len(dataStart):])
+	data = data[:strings.Index(data, "</script>")]
+	if strings.Contains(data, "<") {
+		t.Errorf("data is not escaped: %v", data)
+	}
+	var rec htmlRecording
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Rows != 2 || rec.Cols != 7 || rec.KeyframeInterval != htmlKeyframeInterval || len(rec.Styles) != 1 || len(rec.Frames) != 3 {
+		t.Fatalf("got %vx%v, keyframe interval %v, %v styles, %v frames", rec.Rows, rec.Cols, rec.KeyframeInterval, len(rec.Styles), len(rec.Frames))
+	}
+	// only changed rows are listed, except in keyframes
+	want := []string{
+		`[[0,["$ ls",0]]]`,
+		`[[1,["a\u003cb.txt",0]]]`,
+		`[[0,["$",0]],[1,[]]]`,
+	}
+	for i, f := range rec.Frames {
+		rows, _ := json.Marshal(f.Rows)
+		if string(rows) != want[i] || f.Key != (i == 0) || f.Time != float64(i) || f.Duration != 1 {
+			t.Errorf("frame %v: got rows %v, key=%v at t=%v+%v, expected %v", i, string(rows), f.Key, f.Time, f.Duration, want[i])
+		}
+	}
+}
@@ -0,0 +1,281 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Geometry of the SVG output, in px. 0.602em is the advance of most monospace fonts, but every character is
+// positioned at its cell, so other fonts only change how the text looks within the grid.
+const (
+	svgFontSize   = 14
+	svgCellWidth  = 8.43
+	svgCellHeight = 17
+	svgBaseline   = 13
+)
+
+// svgExporter turns a recording into a single SVG, where every distinct row is defined once and each frame
+// is a stack of <use> elements. All frames are laid out vertically in a "film strip", which a single CSS
+// animation moves through. The keyframe percentages come from the frames' time offsets, so a long idle
+// period is just one frame.
+type svgExporter struct {
+	d          *decoderState
+	blankStyle uint64
+	blankBg    color.RGBA
+	rowIds     map[string]int
+	defs       bytes.Buffer
+	colorIds   map[color.RGBA]int
+	colors     []color.RGBA
+}
+
+type svgFrame struct {
+	time float64
+	rows []int // row id for each row, -1 for blank
+}
+
+func doOpToSVG(opt options) {
+	d := initPlayer(opt)
+	if d.translateColor == nil {
+		cf := xtermColorProfile()
+		d.translateColor = &cf
+	}
+	x := &svgExporter{d: d}
+	x.rowIds = make(map[string]int)
+	x.colorIds = make(map[color.RGBA]int)
+
+	startTime := opt.startTime
+	endTime := opt.endTime
+	if opt.duration > 0 {
+		endTime = startTime + opt.duration
+	}
+	firstFrameId, _ := d.searchForFrame(startTime)
+	lastFrameId := d.lastFrameId
+	if endTime > 0 {
+		lastFrameId, _ = d.searchForFrame(endTime)
+	}
+
+	frames := make([]svgFrame, 0)
+	var extent sizeStruct
+	var totalTime float64
+	for i := firstFrameId; i <= lastFrameId; i++ {
+		byteOffset, _ := d.frameIdLookup(i)
+		finfo, content, err, _ := d.readFrameFromOffset(byteOffset)
+		if err != nil {
+			panic(err)
+		}
+		if i == firstFrameId {
+			blank := content.getCellAt(d.frameSize.rows-1, d.frameSize.cols-1, &d.frameSize)
+			x.blankStyle = blankStyleOf(content, &d.frameSize)
+			_, x.blankBg = cellRGB(blank)
+		}
+		frameTime := finfo.time - startTime
+		if frameTime < 0 {
+			frameTime = 0
+		}
+		totalTime = finfo.time + finfo.duration - startTime
+		if endTime > 0 && finfo.time+finfo.duration > endTime {
+			totalTime = endTime - startTime
+		}
+		sf := svgFrame{time: frameTime, rows: make([]int, 0)}
+		for row := 0; row < d.frameSize.rows; row++ {
+			id, width := x.rowId(content, row)
+			sf.rows = append(sf.rows, id)
+			if id >= 0 {
+				if row+1 > extent.rows {
+					extent.rows = row + 1
+				}
+				if width > extent.cols {
+					extent.cols = width
+				}
+			}
+		}
+		if len(frames) > 0 && intsEqual(frames[len(frames)-1].rows, sf.rows) {
+			// nothing visible changed
+			continue
+		}
+		frames = append(frames, sf)
+		if i%10 == 0 {
+			fmt.Fprintf(os.Stderr, "\r\033[2KConverting frame %v / %v", i, lastFrameId+1)
+		}
+	}
+	if opt.bufferSizeSet {
+		extent = opt.bufferSize
+	}
+	if extent.rows == 0 || extent.cols == 0 {
+		extent = sizeStruct{1, 1}
+	}
+	if totalTime <= 0 {
+		totalTime = 1
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[2KConverted %v frames (%v distinct rows), writing %v...\n", len(frames), len(x.rowIds), opt.videoOutput)
+
+	width := float64(extent.cols) * svgCellWidth
+	height := float64(extent.rows * svgCellHeight)
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="%.2f" height="%d" viewBox="0 0 %.2f %d">`+"\n", width, int(height), width, int(height))
+	out.WriteString("<style>\n")
+	fmt.Fprintf(out, "text { font-family: \"DejaVu Sans Mono\", Menlo, Consolas, monospace; font-size: %dpx; white-space: pre; }\n", svgFontSize)
+	out.WriteString(".b { font-weight: bold; } .u { text-decoration: underline; }\n")
+	for i, c := range x.colors {
+		fmt.Fprintf(out, ".c%d { fill: %v; }\n", i, rgbaToHex(c))
+	}
+	if len(frames) > 1 {
+		fmt.Fprintf(out, ".film { animation: film %.3fs steps(1, end) infinite; }\n", totalTime)
+		out.WriteString("@keyframes film {\n")
+		for i, f := range frames {
+			fmt.Fprintf(out, "  %.4f%% { transform: translateY(%dpx); }\n", f.time/totalTime*100, -i*int(height))
+		}
+		fmt.Fprintf(out, "  100%% { transform: translateY(%dpx); }\n", -(len(frames)-1)*int(height))
+		out.WriteString("}\n")
+	}
+	out.WriteString("</style>\n<defs>\n")
+	out.Write(x.defs.Bytes())
+	out.WriteString("</defs>\n")
+	fmt.Fprintf(out, "<rect width=\"100%%\" height=\"100%%\" fill=\"%v\"/>\n", rgbaToHex(x.blankBg))
+	out.WriteString("<g class=\"film\">\n")
+	for i, f := range frames {
+		fmt.Fprintf(out, "<g transform=\"translate(0 %d)\">", i*int(height))
+		for row, id := range f.rows {
+			if id >= 0 && row < extent.rows {
+				fmt.Fprintf(out, "<use xlink:href=\"#r%d\" y=\"%d\"/>", id, row*svgCellHeight)
+			}
+		}
+		out.WriteString("</g>\n")
+	}
+	out.WriteString("</g>\n</svg>\n")
+
+	fOut, err := os.OpenFile(opt.videoOutput, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		panic(fmt.Errorf("%v when opening %v for writing", err.Error(), opt.videoOutput))
+	}
+	defer fOut.Close()
+	_, err = out.WriteTo(fOut)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func intsEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (x *svgExporter) colorId(c color.RGBA) int {
+	id, ok := x.colorIds[c]
+	if !ok {
+		id = len(x.colors)
+		x.colorIds[c] = id
+		x.colors = append(x.colors, c)
+	}
+	return id
+}
+
+// rowId returns the id of the definition for this row, adding one if it is new, or -1 if the row is blank.
+// Also returns the width of the row, ignoring trailing blanks.
+func (x *svgExporter) rowId(content frameContent, row int) (id int, width int) {
+	d := x.d
+	end := d.frameSize.cols
+	for end > 0 {
+		cell := content.getCellAt(row, end-1, &d.frameSize)
+		if !isBlankCell(cell, x.blankStyle) {
+			break
+		}
+		end--
+	}
+	if end == 0 {
+		return -1, 0
+	}
+	var rects, text bytes.Buffer
+	// backgrounds
+	for col := 0; col < end; {
+		_, bg := cellRGB(content.getCellAt(row, col, &d.frameSize))
+		runEnd := col + 1
+		for runEnd < end {
+			_, nextBg := cellRGB(content.getCellAt(row, runEnd, &d.frameSize))
+			if nextBg != bg {
+				break
+			}
+			runEnd++
+		}
+		if bg != x.blankBg {
+			fmt.Fprintf(&rects, "<rect x=\"%.2f\" width=\"%.2f\" height=\"%d\" class=\"c%d\"/>", float64(col)*svgCellWidth, float64(runEnd-col)*svgCellWidth, svgCellHeight, x.colorId(bg))
+		}
+		col = runEnd
+	}
+	// text, in runs of the same foreground style
+	styleOf := func(cell *frameCell) string {
+		fg, _ := cellRGB(cell)
+		class := fmt.Sprintf("c%d", x.colorId(fg))
+		if cell.style.bold {
+			class += " b"
+		}
+		if cell.style.underline {
+			class += " u"
+		}
+		return class
+	}
+	for col := 0; col < end; {
+		cell := content.getCellAt(row, col, &d.frameSize)
+		if strings.TrimSpace(string(cell.chars)) == "" && !cell.style.underline {
+			col++
+			continue
+		}
+		class := styleOf(cell)
+		// Each character is placed at its own cell, since the font used, or a fallback for characters it
+		// doesn't have, may not have the same advance as the grid.
+		var runText []rune
+		var xs []string
+		runEnd := col
+		for runEnd < end {
+			c := content.getCellAt(row, runEnd, &d.frameSize)
+			if styleOf(c) != class {
+				break
+			}
+			for _, r := range c.chars {
+				if r == 0 || !utf8.ValidRune(r) {
+					continue
+				}
+				if r < 0x20 {
+					r = ' '
+				}
+				runText = append(runText, r)
+				xs = append(xs, fmt.Sprintf("%.2f", float64(runEnd)*svgCellWidth))
+			}
+			runEnd++
+		}
+		for len(runText) > 0 && runText[len(runText)-1] == ' ' {
+			runText = runText[:len(runText)-1]
+			xs = xs[:len(xs)-1]
+		}
+		fmt.Fprintf(&text, "<tspan x=\"%v\" class=\"%v\">", strings.Join(xs, " "), class)
+		xml.EscapeText(&text, []byte(string(runText)))
+		text.WriteString("</tspan>")
+		col = runEnd
+	}
+	def := rects.String()
+	if text.Len() > 0 {
+		def += fmt.Sprintf("<text y=\"%d\">%v</text>", svgBaseline, text.String())
+	}
+	id, ok := x.rowIds[def]
+	if !ok {
+		id = len(x.rowIds)
+		x.rowIds[def] = id
+		fmt.Fprintf(&x.defs, "<g id=\"r%d\">%v</g>\n", id, def)
+	}
+	return id, end
+}
@@ -0,0 +1,65 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_doOpToSVG(t *testing.T) {
+	size := sizeStruct{rows: 3, cols: 10}
+	path := writeTestRecording(t, size,
+		testFrame(size, "$ ls"),
+		testFrame(size, "$ ls"),
+		testFrame(size, "$ ls", "a<b"))
+	defer os.Remove(path)
+	out := path + ".svg"
+	defer os.Remove(out)
+	convert := func(opt options) string {
+		opt.itsInput = path
+		opt.videoOutput = out
+		doOpToSVG(opt)
+		svg, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec := xml.NewDecoder(bytes.NewReader(svg))
+		for {
+			if _, err := dec.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("invalid XML: %v", err)
+			}
+		}
+		return string(svg)
+	}
+
+	svg := convert(options{})
+	for _, want := range []string{
+		`width="33.72" height="34"`,
+		// each character at its cell
+		`<tspan x="0.00 8.43 16.86" class="c0">a&lt;b</tspan>`,
+		// the second frame looks like the first, so the animation has two steps
+		"  0.0000% { transform: translateY(0px); }\n  66.6667% { transform: translateY(-34px); }\n",
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("output doesn't contain %q:\n%v", want, svg)
+		}
+	}
+	if n := strings.Count(svg, `<g id="r`); n != 2 {
+		t.Errorf("%v distinct rows, expected 2", n)
+	}
+
+	// up to the second frame, nothing changes
+	svg = convert(options{endTime: 1.5})
+	if strings.Contains(svg, "@keyframes") || strings.Count(svg, `<g transform=`) != 1 {
+		t.Errorf("expected a single frame without animation:\n%v", svg)
+	}
+}
@@ -1,76 +1,24 @@
+//go:build !js
+// +build !js
+
 package main
 
 import (
 	"bytes"
 	"fmt"
-	"github.com/dustin/go-humanize"
-	"github.com/golang/freetype/truetype"
-	"golang.org/x/image/font"
-	"golang.org/x/image/math/fixed"
 	"image"
-	"image/color"
 	"image/draw"
-	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"os/signal"
-	"strconv"
-	"strings"
-	"sync"
+	"path/filepath"
 	"syscall"
 )
 
 func doOpToVideo(opt options) {
 	d := initPlayer(opt)
-	ffmpeg_bin, err := exec.LookPath("ffmpeg")
-	if err != nil {
-		panic(fmt.Errorf("failed to find ffmpeg."))
-	}
-	ffplay_bin, err := exec.LookPath("ffplay")
-	if err != nil && opt.ffplay {
-		panic(fmt.Errorf("failed to find ffplay."))
-	}
-	var videoCols, videoRows = opt.bufferSize.cols, opt.bufferSize.rows
-	var fps = opt.fps
-	var fontSizePoints float64 = 11
-	var dpi float64 = opt.dpi
-	var mediumFontFace = getFontFace(opt.fontFamily, "medium", fontSizePoints, dpi)
-	var boldFontFace = getFontFace(opt.fontFamily, "bold", fontSizePoints, dpi)
-	var cellWidth, cellHeight int
-	var baseOff image.Point
-	{
-		const set string = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-		bound, _ := font.BoundBytes(mediumFontFace, []byte(set))
-		log("%v", bound)
-		var width = bound.Max.X - bound.Min.X
-		var height = bound.Max.Y - bound.Min.Y
-		cellWidth = width.Ceil() / len(set)
-		cellHeight = height.Ceil()
-		baseOff.X = -bound.Min.X.Round()
-		baseOff.Y = -bound.Max.Y.Round()
-	}
-	var videoWidth = videoCols * cellWidth
-	var videoHeight = videoRows * cellHeight
-	if videoWidth%16 != 0 {
-		videoWidth = (videoWidth / 16) * 16
-	}
-	if videoHeight%16 != 0 {
-		videoHeight = (videoHeight / 16) * 16
-	}
-	var videoSizeArg = fmt.Sprintf("%dx%d", videoWidth, videoHeight)
-	var args = []string{"-f", "rawvideo", "-video_size", videoSizeArg,
-		"-pixel_format", "rgb24", "-vcodec", "rawvideo", "-framerate", strconv.Itoa(fps),
-		"-i", "pipe:0"}
-	if !opt.ffplay {
-		args = append(args, "-framerate", strconv.Itoa(fps), "-preset", "veryfast", "-crf", "20", opt.videoOutput)
-	}
-	bin := ffmpeg_bin
-	if opt.ffplay {
-		bin = ffplay_bin
-	}
 	if opt.colorProfileInput != "" {
-		cf, err := processColorProfile(opt.colorProfileInput)
+		cf, err := loadColorProfile(opt.colorProfileInput)
 		if err != nil {
 			panic(err)
 		}
@@ -78,220 +26,230 @@ func doOpToVideo(opt options) {
 	} else {
 		d.translateColor = nil
 	}
-	proc := exec.Command(bin, args...)
-	var videoDataBuf = make([]byte, 0, 1000000)
-	var videoDataBufLock = &sync.Mutex{}
-	var eof = false
-	proc.Stdin = &videoDataReader{buf: &videoDataBuf, lock: videoDataBufLock, eof: &eof}
-	proc.Stdout = os.Stdout
-	proc.Stderr = os.Stderr
-	err = proc.Start()
-	if err != nil {
-		panic(err)
-	}
+	var videoCols, videoRows = opt.bufferSize.cols, opt.bufferSize.rows
+	var fps = opt.fps
+	var rasterizer = newCellRasterizer(opt)
+	var background, foreground = d.translateColor.bg, d.translateColor.fg
+	if opt.backgroundSet {
+		background = opt.background
+	}
+	var title = opt.title
+	if title == "" {
+		title = d.title
+	}
+	if title == "" {
+		title = filepath.Base(opt.itsInput)
+	}
+	var layout = newVideoLayout(opt, rasterizer, videoRows, videoCols, background, foreground, title)
+	var clock = newVideoClock(d, opt)
+	var totalTime = clock.totalTime()
+	var chaptersMetadata = exportMarkers(opt, d.index.GetMarkers(), newVideoTimeline(clock.clone(), fps), title)
+	var sink = newVideoSink(opt, layout.bounds, chaptersMetadata)
 	defer func() {
 		p := recover()
 		if p != nil {
-			if proc.Process != nil {
-				proc.Process.Signal(syscall.SIGTERM)
-				proc.Wait()
-			}
+			sink.kill()
 			panic(p)
 		}
 	}()
+	var signalChannel = make(chan os.Signal, 1)
 	go func() {
-		e := proc.Wait()
-		if e != nil {
-			os.Exit(1)
-		} else {
-			os.Exit(0)
-		}
-	}()
-	var signalChannel = make(chan os.Signal)
-	go func() {
-		for {
-			_ = <-signalChannel
-			if proc.Process != nil {
-				proc.Process.Signal(syscall.SIGTERM)
-				proc.Wait()
-			}
-			os.Exit(1)
-		}
+		<-signalChannel
+		sink.kill()
+		os.Exit(1)
 	}()
 	signal.Notify(signalChannel, syscall.SIGTERM, syscall.SIGINT)
-	var bounds = image.Rect(0, 0, videoWidth, videoHeight)
-	var canvas = image.NewRGBA(bounds)
+	var canvas = image.NewRGBA(layout.bounds)
+	var termCanvas = canvas.SubImage(layout.term).(*image.RGBA)
+	layout.drawStatic(canvas)
+	var pervContent frameContent
 	var videoFrame uint64 = 0
-	var lastFrameId = d.index.GetCount() - 1
-	var lastFrameIdDrawn uint64 = lastFrameId + 1
-	var drawer = font.Drawer{
-		Dst:  canvas,
-		Src:  nil,
-		Face: mediumFontFace,
-		Dot:  fixed.Point26_6{},
-	}
-	for {
-		var currentTimeOffset = float64(videoFrame) / float64(fps)
-		currentFrameId, index := d.searchForFrame(currentTimeOffset)
-		log("Frame %v => %v => %v/%v", videoFrame, currentTimeOffset, currentFrameId, lastFrameId)
-		if currentFrameId == lastFrameIdDrawn {
-			pushData(&videoDataBuf, videoDataBufLock, canvas)
-			videoFrame++
-			continue
+	var lastFrameIdDrawn = d.index.GetCount()
+	var input = d.index.GetInput()
+	if opt.keys && len(input) == 0 {
+		fmt.Fprintf(os.Stderr, "This recording has no keyboard input. Record with --record-input to use --keys.\n")
+	}
+	var lastCaption string
+	// emitFrame writes the canvas as the next video frame. changed tells whether the terminal has changed
+	// since the last call.
+	var emitFrame = func(changed bool, currentTime float64) {
+		if !layout.overlay.Empty() {
+			layout.drawOverlay(canvas, currentTime-opt.startTime, totalTime)
+			changed = true
 		}
-		if currentFrameId < opt.ss {
-			videoFrame++
-			continue
+		var caption string
+		if opt.keys {
+			caption = keystrokeCaption(input, currentTime)
 		}
-		if opt.t != 0 && currentFrameId >= opt.ss+opt.t {
-			videoDataBufLock.Lock()
-			eof = true
-			videoDataBufLock.Unlock()
-			break
+		changed = changed || caption != lastCaption
+		lastCaption = caption
+		var captionRect image.Rectangle
+		var underCaption *image.RGBA
+		if changed && caption != "" {
+			// The caption covers the terminal, so the pixels under it are restored afterwards for the
+			// next frame to be drawn incrementally.
+			captionRect = layout.captionRect(caption)
+			underCaption = image.NewRGBA(captionRect)
+			draw.Draw(underCaption, captionRect, canvas, captionRect.Min, draw.Src)
+			layout.drawCaption(canvas, caption)
 		}
-		finfo, fcontent, err, _ := d.readFrameFromOffset(index.GetByteOffset())
-		if err != nil {
+		if err := sink.writeFrame(canvas, changed); err != nil {
 			panic(err)
 		}
-		for row := 0; row < d.frameSize.rows; row++ {
-			for col := 0; col < d.frameSize.cols; col++ {
-				var frameCell = fcontent.getCellAt(row, col, &d.frameSize)
-				var cellRect = image.Rect(col*cellWidth, row*cellHeight, (col+1)*cellWidth, (row+1)*cellHeight)
-				var vtBg = frameCell.style.bg
-				if !vtBg.IsRGB() {
-					if d.translateColor == nil {
-						panic(fmt.Sprintf("No color profile provided, but the recording does not encode color. Can't convert to video."))
-					}
-					panic("!")
-				}
-				bgR, bgG, bgB, _ := vtBg.GetRGB()
-				var vtFg = frameCell.style.fg
-				if !vtFg.IsRGB() {
-					if d.translateColor == nil {
-						panic(fmt.Sprintf("No color profile provided, but the recording does not encode color. Can't convert to video."))
-					}
-					panic("!")
-				}
-				fgR, fgG, fgB, _ := vtFg.GetRGB()
-				var bgImg = image.NewUniform(color.RGBA{bgR, bgG, bgB, 255})
-				var fgImg = image.NewUniform(color.RGBA{fgR, fgG, fgB, 255})
-				draw.Draw(canvas, cellRect, bgImg, cellRect.Min, draw.Over)
-				if frameCell.style.bold {
-					drawer.Face = boldFontFace
-				} else {
-					drawer.Face = mediumFontFace
-				}
-				drawer.Src = fgImg
-				drawer.Dot = fixed.P(cellRect.Min.X+baseOff.X, cellRect.Max.Y+baseOff.Y)
-				drawer.DrawString(string(frameCell.chars))
-			}
+		if underCaption != nil {
+			draw.Draw(canvas, captionRect, underCaption, captionRect.Min, draw.Src)
 		}
-		pushData(&videoDataBuf, videoDataBufLock, canvas)
 		videoFrame++
-		if currentFrameId == lastFrameId {
-			log("EOF\n")
-			for i := 0; i < int(finfo.duration*float64(fps)+1); i++ {
-				pushData(&videoDataBuf, videoDataBufLock, canvas)
-			}
-			videoDataBufLock.Lock()
-			eof = true
-			videoDataBufLock.Unlock()
+	}
+	for {
+		currentTime, currentFrameId, index, ok := clock.next()
+		if !ok {
 			break
 		}
+		log("Frame %v => %v => %v/%v", videoFrame, currentTime, currentFrameId, clock.lastFrameId)
+		if currentFrameId == lastFrameIdDrawn {
+			emitFrame(false, currentTime)
+			continue
+		}
+		_, fcontent, err, _ := d.readFrameFromOffset(index.GetByteOffset())
+		if err != nil {
+			panic(err)
+		}
+		rasterizer.drawFrame(termCanvas, pervContent, fcontent, d.frameSize, videoRows, videoCols)
+		pervContent = fcontent
+		lastFrameIdDrawn = currentFrameId
+		emitFrame(true, currentTime)
+	}
+	if err := sink.close(); err != nil {
+		panic(err)
 	}
-	select {}
 }
 
-func pushData(buf *[]byte, lock *sync.Mutex, canvas *image.RGBA) {
-	lock.Lock()
-	defer lock.Unlock()
-	var bd = canvas.Bounds()
-	var sz uint64 = 0
-	for y := bd.Min.Y; y < bd.Max.Y; y++ {
-		for x := bd.Min.X; x < bd.Max.X; x++ {
-			var pix = canvas.RGBAAt(x, y)
-			*buf = append(*buf, byte(pix.R), byte(pix.G), byte(pix.B))
-			sz += 3
+// exportMarkers writes the subtitle and chapter files asked for in opt, and returns the chapters in ffmpeg's
+// metadata format, or nil if the recording has no markers.
+func exportMarkers(opt options, markers []*ITSIndex_Marker, tl *videoTimeline, title string) []byte {
+	if len(markers) == 0 {
+		if len(opt.subtitleFiles) > 0 || opt.chaptersFile != "" {
+			fmt.Fprintf(os.Stderr, "This recording has no shell integration marks (OSC 133), so subtitles and chapters will be empty.\n")
+		} else {
+			return nil
 		}
 	}
-}
-
-type videoDataReader struct {
-	buf  *[]byte
-	lock *sync.Mutex
-	eof  *bool
-	leak uint64
-}
-
-func (r *videoDataReader) Read(p []byte) (n int, err error) {
-	if len(p) == 0 {
-		panic("len(p) == 0")
+	cues := commandCues(markers, tl)
+	for _, filename := range opt.subtitleFiles {
+		writeCaptionFile(filename, cues)
 	}
-	r.lock.Lock()
-	n = copy(p, *r.buf)
-	if n == 0 {
-		if *r.eof && len(*r.buf) == 0 {
-			err = io.EOF
+	var metadata bytes.Buffer
+	if err := writeFFMetadata(&metadata, title, videoChapters(markers, tl, title)); err != nil {
+		panic(err)
+	}
+	if opt.chaptersFile != "" {
+		if err := ioutil.WriteFile(opt.chaptersFile, metadata.Bytes(), 0644); err != nil {
+			panic(err)
 		}
-		r.lock.Unlock()
-		return
 	}
-	if len(p) >= len(*r.buf) {
-		*r.buf = make([]byte, 0, cap(*r.buf))
-		r.leak = 0
-	} else {
-		// newbuf := make([]byte, len(*r.buf)-len(p), cap(*r.buf))
-		// start := time.Now()
-		// n := copy(newbuf, (*r.buf)[len(p):])
-		// log("Copying %v took %v", humanize.Bytes(uint64(n)), time.Now().Sub(start))
-		// *r.buf = newbuf
-		*r.buf = (*r.buf)[len(p):]
-		r.leak += uint64(len(p))
-		log("Leaking %v", humanize.Bytes(r.leak))
+	if len(markers) == 0 {
+		return nil
 	}
-	r.lock.Unlock()
-	return
+	return metadata.Bytes()
 }
 
-func findFont(family, weight string) string {
-	var proc = exec.Command("fc-match", "-f", "%{file}\n", family+":fontformat=TrueType:spacing=mono:weight="+weight)
-	var outBuffer = bytes.NewBuffer(make([]byte, 0, 10000))
-	proc.Stdin = nil
-	proc.Stdout = outBuffer
-	proc.Stderr = os.Stderr
-	proc.Start()
-	if err := proc.Wait(); err != nil {
+// videoClock decides which point of the recording each video frame shows. Each video frame advances the
+// recording time by speed / fps, except that it jumps to the next frame once the current one has been shown
+// for maxIdle. Subtitles and chapters are timed by running the same clock.
+type videoClock struct {
+	d             *decoderState
+	frames        []*ITSIndex_FrameIndex
+	lastFrameId   uint64
+	startTime     float64
+	endTime       float64
+	step          float64
+	maxIdle       float64
+	recordingTime float64
+	// the last frame is shown for its duration (up to maxIdle) at the end of the video
+	lastFrameTime     float64
+	lastFrameDuration float64
+	tailFrames        int
+	tailTime          float64
+	inTail            bool
+}
+
+func newVideoClock(d *decoderState, opt options) *videoClock {
+	c := &videoClock{d: d}
+	c.frames = d.index.GetFrames()
+	c.lastFrameId = d.index.GetCount() - 1
+	c.startTime = opt.startTime
+	c.endTime = opt.endTime
+	if opt.duration > 0 {
+		c.endTime = opt.startTime + opt.duration
+	}
+	c.step = opt.speed / float64(opt.fps)
+	c.maxIdle = opt.maxIdle
+	c.recordingTime = opt.startTime
+	lastFrameStruct, _, err := d.readFrameStructFromOffset(c.frames[c.lastFrameId].GetByteOffset())
+	if err != nil {
 		panic(err)
 	}
-	outStr, err := outBuffer.ReadString('\n')
-	if err != nil && err != io.EOF {
-		panic(err)
+	c.lastFrameTime = lastFrameStruct.GetTimeOffset()
+	c.lastFrameDuration = lastFrameStruct.GetDuration()
+	return c
+}
+
+// clone returns a clock at the same position, sharing the recording.
+func (c *videoClock) clone() *videoClock {
+	nc := *c
+	return &nc
+}
+
+// totalTime returns the length of the recording shown in the video, in recording time.
+func (c *videoClock) totalTime() float64 {
+	var totalTime = c.lastFrameTime + c.lastFrameDuration - c.startTime
+	if c.maxIdle > 0 && c.lastFrameDuration > c.maxIdle {
+		totalTime = c.lastFrameTime + c.maxIdle - c.startTime
 	}
-	if outStr == "" {
-		panic("No fonts found.")
+	if c.endTime > 0 {
+		totalTime = c.endTime - c.startTime
 	}
-	return strings.TrimSuffix(outStr, "\n")
+	return totalTime
 }
 
-func getFontFace(fontFam, weight string, fontSizePoints, dpi float64) font.Face {
-	var fontFile = findFont(fontFam, weight)
-	log("Using font %v for %v", fontFile, weight)
-	fFont, err := os.OpenFile(fontFile, os.O_RDONLY, 0)
-	if err != nil {
-		panic(err)
-	}
-	defer fFont.Close()
-	fontBuf, err := ioutil.ReadAll(fFont)
-	if err != nil {
-		panic(err)
+// next returns the recording time and frame for the next video frame, or ok = false at the end.
+func (c *videoClock) next() (currentTime float64, frameId uint64, index *ITSIndex_FrameIndex, ok bool) {
+	if c.inTail {
+		if c.tailFrames <= 0 {
+			return 0, 0, nil, false
+		}
+		c.tailFrames--
+		c.tailTime += c.step
+		return c.tailTime, c.lastFrameId, c.frames[c.lastFrameId], true
+	}
+	if c.endTime > 0 && c.recordingTime >= c.endTime {
+		return 0, 0, nil, false
+	}
+	currentTime = c.recordingTime
+	frameId, index = c.d.searchForFrame(currentTime)
+	c.recordingTime += c.step
+	if c.maxIdle > 0 && frameId < c.lastFrameId {
+		frameTime := index.GetTimeOffset()
+		if frameTime < c.startTime {
+			frameTime = c.startTime
+		}
+		if nextTime := c.frames[frameId+1].GetTimeOffset(); c.recordingTime < nextTime && c.recordingTime-frameTime > c.maxIdle {
+			c.recordingTime = nextTime
+		}
 	}
-	fnt, err := truetype.Parse(fontBuf)
-	if err != nil {
-		panic(err)
+	if frameId == c.lastFrameId {
+		log("EOF\n")
+		c.inTail = true
+		c.tailTime = currentTime
+		var remaining = c.lastFrameDuration
+		if c.maxIdle > 0 && remaining > c.maxIdle {
+			remaining = c.maxIdle
+		}
+		if c.endTime > 0 && c.lastFrameTime+remaining > c.endTime {
+			remaining = c.endTime - c.lastFrameTime
+		}
+		c.tailFrames = int(remaining/c.step + 1)
 	}
-	return truetype.NewFace(fnt, &truetype.Options{
-		Size:    fontSizePoints,
-		DPI:     dpi,
-		Hinting: font.HintingNone,
-	})
+	return currentTime, frameId, index, true
 }
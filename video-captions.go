@@ -0,0 +1,230 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A command caption is shown for at least this many seconds of video, unless the next one starts earlier.
+const commandCueMinDuration = 2
+
+// videoCue is a subtitle cue or a chapter, in video time.
+type videoCue struct {
+	start, end float64
+	text       string
+}
+
+// videoTimeline maps recording time to video time, by recording the recording time shown in each frame of
+// a video made by the given clock.
+type videoTimeline struct {
+	frameTimes []float64
+	fps        int
+}
+
+func newVideoTimeline(clock *videoClock, fps int) *videoTimeline {
+	tl := &videoTimeline{fps: fps}
+	for {
+		t, _, _, ok := clock.next()
+		if !ok {
+			break
+		}
+		tl.frameTimes = append(tl.frameTimes, t)
+	}
+	return tl
+}
+
+// duration returns the length of the video in seconds.
+func (tl *videoTimeline) duration() float64 {
+	return float64(len(tl.frameTimes)) / float64(tl.fps)
+}
+
+// videoTime returns when something that appears at recording time t is first shown in the video, or
+// ok = false if it is before or after the part of the recording in the video.
+func (tl *videoTimeline) videoTime(t float64) (vt float64, ok bool) {
+	if len(tl.frameTimes) == 0 || t < tl.frameTimes[0]-0.0001 {
+		return 0, false
+	}
+	i := sort.Search(len(tl.frameTimes), func(i int) bool {
+		return tl.frameTimes[i] >= t-0.0001
+	})
+	if i >= len(tl.frameTimes) {
+		return 0, false
+	}
+	return float64(i) / float64(tl.fps), true
+}
+
+// commandCues returns a subtitle cue for each command run, shown from when it starts until the next prompt.
+func commandCues(markers []*ITSIndex_Marker, tl *videoTimeline) []videoCue {
+	cues := make([]videoCue, 0)
+	var open *videoCue
+	closeCue := func(end float64) {
+		if open == nil {
+			return
+		}
+		open.end = end
+		cues = append(cues, *open)
+		open = nil
+	}
+	for _, m := range markers {
+		vt, ok := tl.videoTime(m.GetTimeOffset())
+		if !ok {
+			continue
+		}
+		switch m.GetType() {
+		case ITSIndex_Marker_MARKERTYPE_PROMPT:
+			closeCue(vt)
+		case ITSIndex_Marker_MARKERTYPE_COMMAND:
+			closeCue(vt)
+			if m.GetLabel() != "" {
+				open = &videoCue{start: vt, text: m.GetLabel()}
+			}
+		}
+	}
+	closeCue(tl.duration())
+	for i := range cues {
+		limit := tl.duration()
+		if i+1 < len(cues) {
+			limit = cues[i+1].start
+		}
+		if cues[i].end-cues[i].start < commandCueMinDuration {
+			cues[i].end = math.Min(cues[i].start+commandCueMinDuration, limit)
+		}
+	}
+	return cues
+}
+
+// videoChapters returns a chapter for each prompt at which a command is run, named after the command, and
+// for each bookmark. The chapters cover the whole video, with the title used for any part before the first.
+func videoChapters(markers []*ITSIndex_Marker, tl *videoTimeline, title string) []videoCue {
+	chapters := make([]videoCue, 0)
+	addChapter := func(start float64, text string) {
+		if n := len(chapters); n > 0 && chapters[n-1].start >= start {
+			chapters = chapters[:n-1]
+		}
+		chapters = append(chapters, videoCue{start: start, text: text})
+	}
+	var promptTime float64
+	var havePrompt bool
+	var bookmarks int
+	for _, m := range markers {
+		vt, ok := tl.videoTime(m.GetTimeOffset())
+		if !ok {
+			continue
+		}
+		switch m.GetType() {
+		case ITSIndex_Marker_MARKERTYPE_PROMPT:
+			promptTime = vt
+			havePrompt = true
+		case ITSIndex_Marker_MARKERTYPE_COMMAND:
+			if !havePrompt {
+				promptTime = vt
+			}
+			havePrompt = false
+			label := m.GetLabel()
+			if label == "" {
+				label = fmt.Sprintf("Command at %v", formatTimestamp(vt))
+			}
+			addChapter(promptTime, label)
+		case ITSIndex_Marker_MARKERTYPE_BOOKMARK:
+			bookmarks++
+			addChapter(vt, fmt.Sprintf("Bookmark %v", bookmarks))
+		}
+	}
+	if len(chapters) > 0 && chapters[0].start > 0 {
+		chapters = append([]videoCue{{start: 0, text: title}}, chapters...)
+	}
+	for i := range chapters {
+		if i+1 < len(chapters) {
+			chapters[i].end = chapters[i+1].start
+		} else {
+			chapters[i].end = tl.duration()
+		}
+	}
+	return chapters
+}
+
+// formatCueTime formats seconds as hh:mm:ss followed by sep and milliseconds.
+func formatCueTime(t float64, sep string) string {
+	ms := int64(math.Round(t * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d%v%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// cueText returns text, which can be a multi-line command, without the blank lines and "-->" which would end
+// a cue or be taken for its timing.
+func cueText(text string) string {
+	lines := strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == '\r' })
+	kept := lines[:0]
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			kept = append(kept, line)
+		}
+	}
+	text = strings.Join(kept, "\n")
+	for strings.Contains(text, "-->") {
+		text = strings.Replace(text, "-->", "->", -1)
+	}
+	return text
+}
+
+func writeWebVTT(w io.Writer, cues []videoCue) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("WEBVTT\n")
+	escaper := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	for _, c := range cues {
+		fmt.Fprintf(bw, "\n%v --> %v\n%v\n", formatCueTime(c.start, "."), formatCueTime(c.end, "."), escaper.Replace(cueText(c.text)))
+	}
+	return bw.Flush()
+}
+
+func writeSRT(w io.Writer, cues []videoCue) error {
+	bw := bufio.NewWriter(w)
+	for i, c := range cues {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		fmt.Fprintf(bw, "%v\n%v --> %v\n%v\n", i+1, formatCueTime(c.start, ","), formatCueTime(c.end, ","), cueText(c.text))
+	}
+	return bw.Flush()
+}
+
+// writeFFMetadata writes the title and chapters in ffmpeg's metadata format, which can be given to ffmpeg
+// as an extra input with -map_metadata and -map_chapters.
+func writeFFMetadata(w io.Writer, title string, chapters []videoCue) error {
+	bw := bufio.NewWriter(w)
+	escaper := strings.NewReplacer("\\", "\\\\", "=", "\\=", ";", "\\;", "#", "\\#", "\n", "\\\n")
+	bw.WriteString(";FFMETADATA1\n")
+	if title != "" {
+		fmt.Fprintf(bw, "title=%v\n", escaper.Replace(title))
+	}
+	for _, c := range chapters {
+		fmt.Fprintf(bw, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%v\nEND=%v\ntitle=%v\n",
+			int64(math.Round(c.start*1000)), int64(math.Round(c.end*1000)), escaper.Replace(c.text))
+	}
+	return bw.Flush()
+}
+
+// writeCaptionFile writes cues to filename as WebVTT or SRT, depending on its extension.
+func writeCaptionFile(filename string, cues []videoCue) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		panic(fmt.Errorf("%v when opening %v for writing", err.Error(), filename))
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(filename), ".srt") {
+		err = writeSRT(f, cues)
+	} else {
+		err = writeWebVTT(f, cues)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,114 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testTimeline() *videoTimeline {
+	// 10 fps, 2x speed, with recording time 4-20 skipped as idle
+	tl := &videoTimeline{fps: 10}
+	for i := 0; i < 20; i++ {
+		tl.frameTimes = append(tl.frameTimes, float64(i)*0.2)
+	}
+	for i := 0; i < 20; i++ {
+		tl.frameTimes = append(tl.frameTimes, 20+float64(i)*0.2)
+	}
+	return tl
+}
+
+func Test_videoTimeline_videoTime(t *testing.T) {
+	tl := testTimeline()
+	tests := []struct {
+		t    float64
+		want float64
+		ok   bool
+	}{
+		{0, 0, true},
+		{1, 0.5, true},
+		{1.1, 0.6, true},
+		{10, 2, true},
+		{21, 2.5, true},
+		{30, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := tl.videoTime(tt.t)
+		if ok != tt.ok || (ok && !floatNear(got, tt.want)) {
+			t.Errorf("videoTime(%v) = %v, %v, want %v, %v", tt.t, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func floatNear(a, b float64) bool {
+	return a-b < 0.0001 && b-a < 0.0001
+}
+
+func Test_commandCues_videoChapters(t *testing.T) {
+	tl := testTimeline()
+	markers := []*ITSIndex_Marker{
+		{TimeOffset: 1, Type: ITSIndex_Marker_MARKERTYPE_PROMPT},
+		{TimeOffset: 2, Type: ITSIndex_Marker_MARKERTYPE_COMMAND, Label: "make"},
+		{TimeOffset: 21, Type: ITSIndex_Marker_MARKERTYPE_PROMPT},
+		{TimeOffset: 21.2, Type: ITSIndex_Marker_MARKERTYPE_COMMAND, Label: "ls"},
+		{TimeOffset: 21.6, Type: ITSIndex_Marker_MARKERTYPE_PROMPT},
+		{TimeOffset: 22, Type: ITSIndex_Marker_MARKERTYPE_BOOKMARK},
+		{TimeOffset: 50, Type: ITSIndex_Marker_MARKERTYPE_COMMAND, Label: "exit"},
+	}
+	cues := commandCues(markers, tl)
+	wantCues := []videoCue{{1, 2.6, "make"}, {2.6, 4, "ls"}}
+	chapters := videoChapters(markers, tl, "demo")
+	wantChapters := []videoCue{{0, 0.5, "demo"}, {0.5, 2.5, "make"}, {2.5, 3, "ls"}, {3, 4, "Bookmark 1"}}
+	for _, c := range [][2][]videoCue{{cues, wantCues}, {chapters, wantChapters}} {
+		if len(c[0]) != len(c[1]) {
+			t.Errorf("got %v, want %v", c[0], c[1])
+			continue
+		}
+		for i := range c[0] {
+			if !floatNear(c[0][i].start, c[1][i].start) || !floatNear(c[0][i].end, c[1][i].end) || c[0][i].text != c[1][i].text {
+				t.Errorf("got %v, want %v", c[0], c[1])
+				break
+			}
+		}
+	}
+}
+
+func Test_writeCaptions(t *testing.T) {
+	cues := []videoCue{{0.5, 2, "a <b>"}, {3661.25, 3662, "c=d"}}
+	tests := []struct {
+		write func(*bytes.Buffer) error
+		want  string
+	}{
+		{func(b *bytes.Buffer) error { return writeWebVTT(b, cues) },
+			"WEBVTT\n\n00:00:00.500 --> 00:00:02.000\na &lt;b&gt;\n\n01:01:01.250 --> 01:01:02.000\nc=d\n"},
+		{func(b *bytes.Buffer) error { return writeSRT(b, cues) },
+			"1\n00:00:00,500 --> 00:00:02,000\na <b>\n\n2\n01:01:01,250 --> 01:01:02,000\nc=d\n"},
+		{func(b *bytes.Buffer) error { return writeFFMetadata(b, "x;y", cues) },
+			";FFMETADATA1\ntitle=x\\;y\n\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=500\nEND=2000\ntitle=a <b>\n\n" +
+				"[CHAPTER]\nTIMEBASE=1/1000\nSTART=3661250\nEND=3662000\ntitle=c\\=d\n"},
+	}
+	for i, tt := range tests {
+		var b bytes.Buffer
+		if err := tt.write(&b); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("%v: got %q, want %q", i, b.String(), tt.want)
+		}
+	}
+
+	// a heredoc typed as one command
+	cues = []videoCue{{0, 1, "cat <<EOF\n\n  \nx --> y\r\nEOF"}}
+	var b bytes.Buffer
+	writeSRT(&b, cues)
+	if want := "1\n00:00:00,000 --> 00:00:01,000\ncat <<EOF\nx -> y\nEOF\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+	b.Reset()
+	writeWebVTT(&b, cues)
+	if want := "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\ncat &lt;&lt;EOF\nx -&gt; y\nEOF\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
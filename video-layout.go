@@ -0,0 +1,154 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// videoLayout positions the terminal, the optional window chrome (a title bar) and the optional progress
+// overlay within a video frame, and draws everything except the terminal itself.
+type videoLayout struct {
+	bounds   image.Rectangle
+	term     image.Rectangle
+	titleBar image.Rectangle // empty if no chrome
+	overlay  image.Rectangle // empty if no progress overlay
+
+	background, foreground color.RGBA
+	title                  string
+	// A face of its own, since the rasterizer's faces may be in use by other goroutines. Fallback fonts
+	// are shared with the rasterizer, so text must not be drawn while it is drawing a frame.
+	face       font.Face
+	faceAscent int
+	fonts      *fontSet
+}
+
+// blend returns a color between a (t = 0) and b (t = 1).
+func blend(a, b color.RGBA, t float64) color.RGBA {
+	mix := func(x, y uint8) uint8 {
+		return uint8(float64(x)*(1-t) + float64(y)*t + 0.5)
+	}
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 0xff}
+}
+
+func newVideoLayout(opt options, r *cellRasterizer, rows, cols int, background, foreground color.RGBA, title string) *videoLayout {
+	l := &videoLayout{background: background, foreground: foreground, title: title}
+	l.face = newFontFace(r.fonts.mediumFont, opt.fontSize, opt.dpi)
+	l.fonts = r.fonts
+	l.faceAscent = l.face.Metrics().Ascent.Ceil()
+	barHeight := r.cellHeight * 3 / 2
+	width := cols*r.cellWidth + 2*opt.padding
+	y := 0
+	if opt.chrome {
+		l.titleBar = image.Rect(0, 0, width, barHeight)
+		y = barHeight
+	}
+	l.term = image.Rect(opt.padding, y+opt.padding, opt.padding+cols*r.cellWidth, y+opt.padding+rows*r.cellHeight)
+	y = l.term.Max.Y + opt.padding
+	if opt.progress {
+		l.overlay = image.Rect(0, y, width, y+barHeight)
+		y += barHeight
+	}
+	// Most encoders need even dimensions. Pad with background rather than cutting anything off.
+	l.bounds = image.Rect(0, 0, width+width%2, y+y%2)
+	return l
+}
+
+// drawStatic draws the background and the window chrome.
+func (l *videoLayout) drawStatic(canvas *image.RGBA) {
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(l.background), image.Point{}, draw.Src)
+	if l.titleBar.Empty() {
+		return
+	}
+	bar := l.titleBar
+	draw.Draw(canvas, bar, image.NewUniform(blend(l.background, l.foreground, 0.15)), image.Point{}, draw.Src)
+	radius := bar.Dy() / 5
+	for i, c := range []color.RGBA{{0xff, 0x5f, 0x56, 0xff}, {0xff, 0xbd, 0x2e, 0xff}, {0x27, 0xc9, 0x3f, 0xff}} {
+		center := image.Pt(bar.Min.X+bar.Dy()/2+i*radius*3, bar.Min.Y+bar.Dy()/2)
+		fillCircle(canvas, center, radius, c)
+	}
+	titleWidth := l.measureText(l.title)
+	l.drawText(canvas, l.title, (bar.Dx()-titleWidth)/2, bar, blend(l.background, l.foreground, 0.8))
+}
+
+// drawOverlay draws the current time and a progress bar.
+func (l *videoLayout) drawOverlay(canvas *image.RGBA, currentTime, totalTime float64) {
+	if l.overlay.Empty() {
+		return
+	}
+	o := l.overlay
+	draw.Draw(canvas, o, image.NewUniform(l.background), image.Point{}, draw.Src)
+	margin := o.Dy() / 2
+	text := fmt.Sprintf("%v / %v", formatTimestamp(currentTime), formatTimestamp(totalTime))
+	textEnd := l.drawText(canvas, text, o.Min.X+margin, o, l.foreground)
+	track := image.Rect(textEnd+margin, o.Min.Y+o.Dy()/2-2, o.Max.X-margin, o.Min.Y+o.Dy()/2+2)
+	if track.Empty() {
+		return
+	}
+	draw.Draw(canvas, track, image.NewUniform(blend(l.background, l.foreground, 0.25)), image.Point{}, draw.Src)
+	progress := 1.0
+	if totalTime > 0 && currentTime < totalTime {
+		progress = currentTime / totalTime
+	}
+	filled := track
+	filled.Max.X = track.Min.X + int(float64(track.Dx())*progress)
+	draw.Draw(canvas, filled, image.NewUniform(blend(l.background, l.foreground, 0.7)), image.Point{}, draw.Src)
+}
+
+// faceFor returns the face to draw r with, using the rasterizer's fallback fonts if needed.
+func (l *videoLayout) faceFor(r rune) font.Face {
+	if l.fonts.mediumFont.Index(r) != 0 {
+		return l.face
+	}
+	return l.fonts.faceFor(r, false)
+}
+
+func (l *videoLayout) measureText(text string) int {
+	var width fixed.Int26_6
+	for _, r := range text {
+		adv, _ := l.faceFor(r).GlyphAdvance(r)
+		width += adv
+	}
+	return width.Ceil()
+}
+
+// drawText draws text vertically centered in box, starting at x, and returns where it ends.
+func (l *videoLayout) drawText(canvas *image.RGBA, text string, x int, box image.Rectangle, c color.RGBA) int {
+	d := font.Drawer{Dst: canvas.SubImage(box).(*image.RGBA), Src: image.NewUniform(c)}
+	d.Dot = fixed.P(x, box.Min.Y+(box.Dy()+l.faceAscent)/2-1)
+	for _, r := range text {
+		d.Face = l.faceFor(r)
+		d.DrawString(string(r))
+	}
+	return d.Dot.X.Ceil()
+}
+
+func fillCircle(canvas *image.RGBA, center image.Point, radius int, c color.RGBA) {
+	for y := -radius; y <= radius; y++ {
+		for x := -radius; x <= radius; x++ {
+			if x*x+y*y <= radius*radius {
+				canvas.SetRGBA(center.X+x, center.Y+y, c)
+			}
+		}
+	}
+}
+
+// captionRect returns the box a caption is drawn in, at the bottom center of the terminal.
+func (l *videoLayout) captionRect(text string) image.Rectangle {
+	height := l.faceAscent * 2
+	width := l.measureText(text) + height
+	box := image.Rect(0, 0, width, height).Add(image.Pt(l.term.Min.X+(l.term.Dx()-width)/2, l.term.Max.Y-height*3/2))
+	return box.Intersect(l.bounds)
+}
+
+func (l *videoLayout) drawCaption(canvas *image.RGBA, text string) {
+	box := l.captionRect(text)
+	draw.Draw(canvas, box, image.NewUniform(blend(l.background, l.foreground, 0.2)), image.Point{}, draw.Src)
+	l.drawText(canvas, text, box.Min.X+box.Dy()/2, box, l.foreground)
+}
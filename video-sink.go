@@ -0,0 +1,250 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// videoSink receives the frames rendered by to-video, at a constant frame rate.
+type videoSink interface {
+	// writeFrame writes the next frame. changed is false if canvas is the same as in the last call, so
+	// that sinks can reuse their encoded data. canvas must not be retained.
+	writeFrame(canvas *image.RGBA, changed bool) error
+	// close finishes the output, and waits for any encoder to exit.
+	close() error
+	// kill stops any encoder early, e.g. on SIGINT.
+	kill()
+}
+
+// newVideoSink chooses the output format from opt.videoOutput: a YUV4MPEG2 stream for "-" (stdout) or
+// *.y4m, a PNG sequence for a name with a %d pattern or ending with "/", otherwise a video made by ffmpeg.
+// metadata, if not nil, is muxed into videos made by ffmpeg, and is ignored by other formats.
+func newVideoSink(opt options, bounds image.Rectangle, metadata []byte) videoSink {
+	out := opt.videoOutput
+	switch {
+	case opt.ffplay:
+		return newFFmpegSink(opt, bounds, nil)
+	case out == "-":
+		return newY4MSink(nopWriteCloser{os.Stdout}, bounds, opt.fps)
+	case strings.EqualFold(filepath.Ext(out), ".y4m"):
+		f, err := os.OpenFile(out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			panic(fmt.Errorf("%v when opening %v for writing", err.Error(), out))
+		}
+		return newY4MSink(f, bounds, opt.fps)
+	case strings.HasSuffix(out, "/"):
+		return newPNGSequenceSink(filepath.Join(out, "%06d.png"))
+	case strings.Contains(out, "%"):
+		return newPNGSequenceSink(out)
+	default:
+		return newFFmpegSink(opt, bounds, metadata)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// ffmpegSink pipes raw RGB frames into ffmpeg or ffplay. Writes block while the encoder is busy, so memory
+// use doesn't grow when rendering is faster than encoding.
+type ffmpegSink struct {
+	proc     *exec.Cmd
+	pipe     *io.PipeWriter
+	frameRGB []byte
+	exited   chan struct{}
+	waitErr  error
+	// ffmetadata file with the chapters, removed once ffmpeg exits
+	metadataFile string
+}
+
+// newFFmpegSink starts ffmpeg, or ffplay if opt.ffplay is set. metadata is an ffmetadata file to take the
+// title and chapters from, or nil.
+func newFFmpegSink(opt options, bounds image.Rectangle, metadata []byte) *ffmpegSink {
+	bin := "ffmpeg"
+	if opt.ffplay {
+		bin = "ffplay"
+	}
+	binPath, err := exec.LookPath(bin)
+	if err != nil {
+		panic(fmt.Errorf("failed to find %v.", bin))
+	}
+	var videoSizeArg = fmt.Sprintf("%dx%d", bounds.Dx(), bounds.Dy())
+	var args = []string{"-f", "rawvideo", "-video_size", videoSizeArg,
+		"-pixel_format", "rgb24", "-vcodec", "rawvideo", "-framerate", strconv.Itoa(opt.fps),
+		"-i", "pipe:0"}
+	s := &ffmpegSink{}
+	if metadata != nil {
+		f, err := ioutil.TempFile("", "ts-player-chapters-*.txt")
+		if err != nil {
+			panic(err)
+		}
+		s.metadataFile = f.Name()
+		_, err = f.Write(metadata)
+		f.Close()
+		if err != nil {
+			os.Remove(s.metadataFile)
+			panic(err)
+		}
+		args = append(args, "-f", "ffmetadata", "-i", s.metadataFile, "-map", "0:v", "-map_metadata", "1", "-map_chapters", "1")
+	}
+	if !opt.ffplay {
+		args = append(args, "-framerate", strconv.Itoa(opt.fps), "-preset", "veryfast", "-crf", "20", opt.videoOutput)
+	}
+	s.proc = exec.Command(binPath, args...)
+	var pr *io.PipeReader
+	pr, s.pipe = io.Pipe()
+	s.proc.Stdin = pr
+	s.proc.Stdout = os.Stdout
+	s.proc.Stderr = os.Stderr
+	err = s.proc.Start()
+	if err != nil {
+		if s.metadataFile != "" {
+			os.Remove(s.metadataFile)
+		}
+		panic(err)
+	}
+	s.exited = make(chan struct{})
+	go func() {
+		s.waitErr = s.proc.Wait()
+		// if the encoder exits early, fail writes instead of blocking forever
+		pr.CloseWithError(fmt.Errorf("%v exited", bin))
+		if s.metadataFile != "" {
+			os.Remove(s.metadataFile)
+		}
+		close(s.exited)
+	}()
+	return s
+}
+
+func (s *ffmpegSink) writeFrame(canvas *image.RGBA, changed bool) error {
+	if changed || s.frameRGB == nil {
+		s.frameRGB = appendRGB(s.frameRGB[:0], canvas)
+	}
+	_, err := s.pipe.Write(s.frameRGB)
+	return err
+}
+
+func (s *ffmpegSink) close() error {
+	s.pipe.Close()
+	<-s.exited
+	if s.waitErr != nil {
+		return fmt.Errorf("%v: %v", filepath.Base(s.proc.Path), s.waitErr.Error())
+	}
+	return nil
+}
+
+func (s *ffmpegSink) kill() {
+	if s.proc.Process != nil {
+		s.proc.Process.Signal(syscall.SIGTERM)
+		<-s.exited
+	}
+}
+
+// pngSequenceSink writes each frame to a numbered PNG file, with the number formatted into pattern.
+type pngSequenceSink struct {
+	pattern  string
+	frameNum int
+	encoded  bytes.Buffer
+}
+
+func newPNGSequenceSink(pattern string) *pngSequenceSink {
+	if dir := filepath.Dir(pattern); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			panic(err)
+		}
+	}
+	return &pngSequenceSink{pattern: pattern}
+}
+
+func (s *pngSequenceSink) writeFrame(canvas *image.RGBA, changed bool) error {
+	if changed || s.encoded.Len() == 0 {
+		s.encoded.Reset()
+		enc := png.Encoder{CompressionLevel: png.BestSpeed}
+		if err := enc.Encode(&s.encoded, canvas); err != nil {
+			return err
+		}
+	}
+	err := ioutil.WriteFile(fmt.Sprintf(s.pattern, s.frameNum), s.encoded.Bytes(), 0644)
+	s.frameNum++
+	return err
+}
+
+func (s *pngSequenceSink) close() error {
+	return nil
+}
+
+func (s *pngSequenceSink) kill() {}
+
+// y4mSink writes a YUV4MPEG2 stream, in 4:4:4 so that colored text stays sharp, with BT.601 limited-range
+// colors.
+type y4mSink struct {
+	out   io.WriteCloser
+	buf   *bufio.Writer
+	frame []byte
+}
+
+func newY4MSink(out io.WriteCloser, bounds image.Rectangle, fps int) *y4mSink {
+	s := &y4mSink{out: out, buf: bufio.NewWriterSize(out, 1<<20)}
+	fmt.Fprintf(s.buf, "YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 C444\n", bounds.Dx(), bounds.Dy(), fps)
+	return s
+}
+
+// rgbToYCbCr converts to BT.601 limited range.
+func rgbToYCbCr(r, g, b uint8) (y, cb, cr uint8) {
+	R, G, B := int32(r), int32(g), int32(b)
+	y = uint8(((66*R + 129*G + 25*B + 128) >> 8) + 16)
+	cb = uint8(((-38*R - 74*G + 112*B + 128) >> 8) + 128)
+	cr = uint8(((112*R - 94*G - 18*B + 128) >> 8) + 128)
+	return
+}
+
+func (s *y4mSink) writeFrame(canvas *image.RGBA, changed bool) error {
+	if changed || s.frame == nil {
+		bd := canvas.Bounds()
+		planeSize := bd.Dx() * bd.Dy()
+		if len(s.frame) != 3*planeSize {
+			s.frame = make([]byte, 3*planeSize)
+		}
+		yPlane, cbPlane, crPlane := s.frame[:planeSize], s.frame[planeSize:2*planeSize], s.frame[2*planeSize:]
+		i := 0
+		for y := bd.Min.Y; y < bd.Max.Y; y++ {
+			pix := canvas.Pix[canvas.PixOffset(bd.Min.X, y):]
+			for x := 0; x < bd.Dx(); x++ {
+				yPlane[i], cbPlane[i], crPlane[i] = rgbToYCbCr(pix[x*4], pix[x*4+1], pix[x*4+2])
+				i++
+			}
+		}
+	}
+	if _, err := s.buf.WriteString("FRAME\n"); err != nil {
+		return err
+	}
+	_, err := s.buf.Write(s.frame)
+	return err
+}
+
+func (s *y4mSink) close() error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	return s.out.Close()
+}
+
+func (s *y4mSink) kill() {}
@@ -0,0 +1,118 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func testCanvas(c color.RGBA) *image.RGBA {
+	canvas := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			canvas.SetRGBA(x, y, c)
+		}
+	}
+	return canvas
+}
+
+func Test_y4mSink(t *testing.T) {
+	out := &bufferCloser{}
+	s := newY4MSink(out, image.Rect(0, 0, 4, 2), 25)
+	white := testCanvas(color.RGBA{255, 255, 255, 255})
+	black := testCanvas(color.RGBA{0, 0, 0, 255})
+	for i, c := range []*image.RGBA{white, white, black} {
+		if err := s.writeFrame(c, i != 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	if !out.closed {
+		t.Error("output not closed")
+	}
+	plane := func(v byte) []byte {
+		return bytes.Repeat([]byte{v}, 8)
+	}
+	frame := func(y byte) []byte {
+		f := append([]byte("FRAME\n"), plane(y)...)
+		return append(append(f, plane(128)...), plane(128)...)
+	}
+	expected := []byte("YUV4MPEG2 W4 H2 F25:1 Ip A1:1 C444\n")
+	expected = append(expected, frame(235)...)
+	expected = append(expected, frame(235)...)
+	expected = append(expected, frame(16)...)
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Errorf("got %q\nwant %q", out.Bytes(), expected)
+	}
+}
+
+func Test_rgbToYCbCr(t *testing.T) {
+	tests := []struct {
+		r, g, b   uint8
+		y, cb, cr uint8
+	}{
+		{0, 0, 0, 16, 128, 128},
+		{255, 255, 255, 235, 128, 128},
+		{255, 0, 0, 82, 90, 240},
+		{0, 0, 255, 41, 240, 110},
+	}
+	for _, tt := range tests {
+		y, cb, cr := rgbToYCbCr(tt.r, tt.g, tt.b)
+		if y != tt.y || cb != tt.cb || cr != tt.cr {
+			t.Errorf("rgbToYCbCr(%v, %v, %v) = %v, %v, %v, want %v, %v, %v", tt.r, tt.g, tt.b, y, cb, cr, tt.y, tt.cb, tt.cr)
+		}
+	}
+}
+
+func Test_pngSequenceSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ts-player-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newVideoSink(options{videoOutput: dir + "/frames/"}, image.Rect(0, 0, 4, 2), nil)
+	colors := []color.RGBA{{255, 0, 0, 255}, {255, 0, 0, 255}, {0, 0, 255, 255}}
+	for i, c := range colors {
+		if err := s.writeFrame(testCanvas(c), i != 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range colors {
+		f, err := os.Open(filepath.Join(dir, "frames", fmt.Sprintf("%06d.png", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := color.RGBAModel.Convert(img.At(1, 1)); got != c {
+			t.Errorf("frame %v has %v, want %v", i, got, c)
+		}
+	}
+}
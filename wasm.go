@@ -0,0 +1,287 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"syscall/js"
+)
+
+// The WebAssembly build exposes the decoder as a global tsPlayer object:
+//
+//   tsPlayer.open(source) -> Promise<recording>
+//
+// source can be a Uint8Array, a Blob (including File) or a URL. Blobs and URLs are read lazily with
+// slice() or HTTP Range requests, so opening and seeking a large recording only downloads the index and
+// the frames actually shown. A recording has the properties rows, cols, frameCount and lastFrameTime, and the
+// methods frameAt(time) and frame(frameId), both returning a Promise of
+//
+//   {frameId, time, duration, contents: Array<string>, fg: Uint32Array, bg: Uint32Array, flags: Uint8Array}
+//
+// with one element per cell, row by row. Colors are 0xRRGGBB, or the palette index with bit 24 set for
+// indexed colors. Bit 0 of flags is bold, bit 1 is underline.
+
+const wasmIndexedColor = 1 << 24
+
+func main() {
+	tsPlayer := js.Global().Get("Object").New()
+	tsPlayer.Set("open", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			return rejected(errors.New("open(source) requires an argument"))
+		}
+		source := args[0]
+		return promise(func() (interface{}, error) {
+			r, size, err := jsSourceReaderAt(source)
+			if err != nil {
+				return nil, err
+			}
+			d, err := openITS(r, size)
+			if err != nil {
+				return nil, err
+			}
+			return wrapRecording(d), nil
+		})
+	}))
+	js.Global().Set("tsPlayer", tsPlayer)
+	select {}
+}
+
+func wrapRecording(d *itsReader) js.Value {
+	rec := js.Global().Get("Object").New()
+	rec.Set("rows", d.frameSize.rows)
+	rec.Set("cols", d.frameSize.cols)
+	rec.Set("frameCount", float64(d.lastFrameId+1))
+	_, lastTime := d.frameIdLookup(d.lastFrameId)
+	rec.Set("lastFrameTime", lastTime)
+	rec.Set("frameAt", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			return rejected(errors.New("frameAt(time) requires an argument"))
+		}
+		frameId, _ := d.searchForFrame(args[0].Float())
+		return promise(func() (interface{}, error) {
+			return readFrameForJS(d, frameId)
+		})
+	}))
+	rec.Set("frame", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 || args[0].Int() < 0 || uint64(args[0].Int()) > d.lastFrameId {
+			return rejected(errors.New("frame(frameId): invalid frameId"))
+		}
+		frameId := uint64(args[0].Int())
+		return promise(func() (interface{}, error) {
+			return readFrameForJS(d, frameId)
+		})
+	}))
+	return rec
+}
+
+func readFrameForJS(d *itsReader, frameId uint64) (interface{}, error) {
+	byteOffset, _ := d.frameIdLookup(frameId)
+	frameStruct, _, err := d.readFrameStructFromOffset(byteOffset)
+	if err != nil {
+		return nil, err
+	}
+	if frameStruct.GetType() != ITSFrame_FRAMETYPE_K {
+		return nil, errors.New("Unrecognized frame type")
+	}
+	body := frameStruct.GetBodyK()
+	contents := body.GetContents()
+	attrs := body.GetAttrs()
+	nbCells := d.frameSize.rows * d.frameSize.cols
+	if len(contents) < nbCells || len(attrs) < nbCells {
+		return nil, errors.New("Frame " + strconv.FormatUint(frameId, 10) + " has too few cells")
+	}
+	jsContents := make([]interface{}, nbCells)
+	fg := make([]byte, nbCells*4)
+	bg := make([]byte, nbCells*4)
+	flags := make([]byte, nbCells)
+	for i := 0; i < nbCells; i++ {
+		jsContents[i] = contents[i]
+		a := unpackAttrCode(attrs[i])
+		putColor := func(buf []byte, c uint32, indexed bool) {
+			if indexed {
+				c = wasmIndexedColor | (c & 0xff)
+			}
+			// little endian, to match Uint32Array on every browser we care about
+			buf[i*4] = byte(c)
+			buf[i*4+1] = byte(c >> 8)
+			buf[i*4+2] = byte(c >> 16)
+			buf[i*4+3] = byte(c >> 24)
+		}
+		putColor(fg, uint32(a.fg.R)<<16|uint32(a.fg.G)<<8|uint32(a.fg.B), a.fgIndexed)
+		putColor(bg, uint32(a.bg.R)<<16|uint32(a.bg.G)<<8|uint32(a.bg.B), a.bgIndexed)
+		if a.bold {
+			flags[i] |= 1
+		}
+		if a.underline {
+			flags[i] |= 2
+		}
+	}
+	result := js.Global().Get("Object").New()
+	result.Set("frameId", float64(frameStruct.GetFrameId()))
+	result.Set("time", frameStruct.GetTimeOffset())
+	result.Set("duration", frameStruct.GetDuration())
+	result.Set("contents", js.ValueOf(jsContents))
+	result.Set("fg", toUint32Array(fg))
+	result.Set("bg", toUint32Array(bg))
+	flagsArr := js.Global().Get("Uint8Array").New(len(flags))
+	js.CopyBytesToJS(flagsArr, flags)
+	result.Set("flags", flagsArr)
+	return result, nil
+}
+
+func toUint32Array(buf []byte) js.Value {
+	u8 := js.Global().Get("Uint8Array").New(len(buf))
+	js.CopyBytesToJS(u8, buf)
+	return js.Global().Get("Uint32Array").New(u8.Get("buffer"))
+}
+
+// promise runs fn on a new goroutine, since fn may block on other promises, which can't be done from within
+// a js.Func callback.
+func promise(fn func() (interface{}, error)) js.Value {
+	var executor js.Func
+	executor = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		resolve, reject := args[0], args[1]
+		go func() {
+			defer executor.Release()
+			result, err := fn()
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+			} else {
+				resolve.Invoke(result)
+			}
+		}()
+		return nil
+	})
+	return js.Global().Get("Promise").New(executor)
+}
+
+func rejected(err error) js.Value {
+	return js.Global().Get("Promise").Call("reject", js.Global().Get("Error").New(err.Error()))
+}
+
+// await blocks the current goroutine until p settles.
+func await(p js.Value) (js.Value, error) {
+	type settled struct {
+		value js.Value
+		err   error
+	}
+	ch := make(chan settled, 1)
+	onResolve := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		ch <- settled{value: args[0]}
+		return nil
+	})
+	onReject := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		ch <- settled{err: errors.New(args[0].Call("toString").String())}
+		return nil
+	})
+	defer onResolve.Release()
+	defer onReject.Release()
+	p.Call("then", onResolve, onReject)
+	s := <-ch
+	return s.value, s.err
+}
+
+func jsSourceReaderAt(source js.Value) (io.ReaderAt, int64, error) {
+	switch {
+	case source.Type() == js.TypeString:
+		return newHTTPReaderAt(source.String())
+	case source.InstanceOf(js.Global().Get("Uint8Array")):
+		buf := make([]byte, source.Get("length").Int())
+		js.CopyBytesToGo(buf, source)
+		return byteReaderAt(buf), int64(len(buf)), nil
+	case source.InstanceOf(js.Global().Get("Blob")):
+		return &blobReaderAt{source}, int64(source.Get("size").Float()), nil
+	default:
+		return nil, 0, errors.New("source must be a URL, Uint8Array or Blob")
+	}
+}
+
+type byteReaderAt []byte
+
+func (b byteReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+type blobReaderAt struct {
+	blob js.Value
+}
+
+func (r *blobReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	slice := r.blob.Call("slice", float64(off), float64(off)+float64(len(p)))
+	buf, err := await(slice.Call("arrayBuffer"))
+	if err != nil {
+		return 0, err
+	}
+	return copyArrayBuffer(p, buf)
+}
+
+type httpReaderAt struct {
+	url string
+}
+
+func newHTTPReaderAt(url string) (io.ReaderAt, int64, error) {
+	opts := js.Global().Get("Object").New()
+	opts.Set("method", "HEAD")
+	resp, err := await(js.Global().Call("fetch", url, opts))
+	if err != nil {
+		return nil, 0, err
+	}
+	if !resp.Get("ok").Bool() {
+		return nil, 0, errors.New("HEAD " + url + ": " + resp.Get("statusText").String())
+	}
+	length := resp.Get("headers").Call("get", "Content-Length")
+	if length.IsNull() {
+		return nil, 0, errors.New(url + ": server did not report Content-Length")
+	}
+	size, err := strconv.ParseInt(length.String(), 10, 64)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &httpReaderAt{url}, size, nil
+}
+
+func (r *httpReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	headers := js.Global().Get("Object").New()
+	headers.Set("Range", "bytes="+strconv.FormatInt(off, 10)+"-"+strconv.FormatInt(off+int64(len(p))-1, 10))
+	opts := js.Global().Get("Object").New()
+	opts.Set("headers", headers)
+	resp, err := await(js.Global().Call("fetch", r.url, opts))
+	if err != nil {
+		return 0, err
+	}
+	status := resp.Get("status").Int()
+	if status == 416 {
+		return 0, io.EOF
+	}
+	if status != 206 {
+		// A 200 would mean that the server ignored the range and is sending the whole file.
+		return 0, errors.New(r.url + ": server does not support range requests (status " + strconv.Itoa(status) + ")")
+	}
+	buf, err := await(resp.Call("arrayBuffer"))
+	if err != nil {
+		return 0, err
+	}
+	return copyArrayBuffer(p, buf)
+}
+
+func copyArrayBuffer(p []byte, buf js.Value) (int, error) {
+	u8 := js.Global().Get("Uint8Array").New(buf)
+	n := js.CopyBytesToGo(p, u8)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
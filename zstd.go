@@ -0,0 +1,22 @@
+//go:build cgo && !js
+// +build cgo,!js
+
+package main
+
+import (
+	"github.com/valyala/gozstd"
+)
+
+type zstdDDict = gozstd.DDict
+
+func zstdNewDDict(dict []byte) (*zstdDDict, error) {
+	return gozstd.NewDDict(dict)
+}
+
+func zstdDecompress(dst, src []byte) ([]byte, error) {
+	return gozstd.Decompress(dst, src)
+}
+
+func zstdDecompressDict(dst, src []byte, dd *zstdDDict) ([]byte, error) {
+	return gozstd.DecompressDict(dst, src, dd)
+}
@@ -0,0 +1,33 @@
+//go:build !cgo || js
+// +build !cgo js
+
+package main
+
+import (
+	"github.com/klauspost/compress/zstd"
+)
+
+// Pure Go decompression for where gozstd can't be built, such as WebAssembly. Frames are only ever read
+// this way, so compression is left to gozstd.
+
+type zstdDDict struct {
+	decoder *zstd.Decoder
+}
+
+var zstdPlainDecoder, _ = zstd.NewReader(nil)
+
+func zstdNewDDict(dict []byte) (*zstdDDict, error) {
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderDicts(dict))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdDDict{decoder}, nil
+}
+
+func zstdDecompress(dst, src []byte) ([]byte, error) {
+	return zstdPlainDecoder.DecodeAll(src, dst)
+}
+
+func zstdDecompressDict(dst, src []byte, dd *zstdDDict) ([]byte, error) {
+	return dd.decoder.DecodeAll(src, dst)
+}